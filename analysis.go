@@ -6,11 +6,37 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"sort"
+	"time"
 )
 
+// HistoricalPrice is a day of trading as returned by the FMP API. Synthetic and older
+// stored prices may only have a date and close; the other fields are then zero.
 type HistoricalPrice struct {
-	Date  string  `json:"date"`
-	Close float64 `json:"close"`
+	Date     string  `json:"date"`
+	Open     float64 `json:"open,omitempty"`
+	High     float64 `json:"high,omitempty"`
+	Low      float64 `json:"low,omitempty"`
+	Close    float64 `json:"close"`
+	AdjClose float64 `json:"adjClose,omitempty"` // Close adjusted for later splits and dividends
+	Volume   float64 `json:"volume,omitempty"`
+}
+
+// priceBasis selects which close the indicators are computed from.
+type priceBasis int
+
+const (
+	rawClose      priceBasis = iota // The price traded on the day
+	adjustedClose                   // Adjusted for splits and dividends, so returns are total returns
+)
+
+// value returns the close of the day in the given basis. Prices without an adjusted
+// close fall back to the raw one.
+func (p HistoricalPrice) value(basis priceBasis) float64 {
+	if basis == adjustedClose && p.AdjClose > 0 {
+		return p.AdjClose
+	}
+	return p.Close
 }
 
 // StockSearchResult matches the FMP search API response structure.
@@ -45,14 +71,14 @@ func searchStocks(query string) ([]StockSearchResult, error) {
 	return results, nil
 }
 
-func calculateSMA(prices []HistoricalPrice, period int) (float64, error) {
+func calculateSMA(prices []HistoricalPrice, period int, basis priceBasis) (float64, error) {
 	if len(prices) < period {
 		return 0, fmt.Errorf("not enough data tocalculate %d-day SMA", period)
 	}
 
 	var sum float64
 	for i := 0; i < period; i++ {
-		sum += prices[i].Close
+		sum += prices[i].value(basis)
 	}
 
 	return sum / float64(period), nil
@@ -60,8 +86,9 @@ func calculateSMA(prices []HistoricalPrice, period int) (float64, error) {
 }
 
 // The function signature now includes a new return value: []HistoricalPrice
-func fetchAndAnalyzeStock(ticker string) (currentPrice float64, ma200 float64, emaTrend float64, historicalData []HistoricalPrice, err error) {
-	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/historical-price-full/%s?timeseries=250&apikey=%s", ticker, fmpApiKey)
+// The indicators are computed from the closes in basis; currentPrice is always the raw close.
+func fetchAndAnalyzeStock(ticker string, basis priceBasis) (currentPrice float64, ma200 float64, emaTrend float64, historicalData []HistoricalPrice, err error) {
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/historical-price-full/%s?timeseries=%d&apikey=%s", ticker, historyWindow, fmpApiKey)
 
 	resp, err := http.Get(url)
 	if err != nil {
@@ -91,14 +118,16 @@ func fetchAndAnalyzeStock(ticker string) (currentPrice float64, ma200 float64, e
 	}
 
 	currentPrice = historicalData[0].Close
+	// The indicators only look at the most recent part of the history
+	indicators := historicalData[:min(len(historicalData), analysisWindow)]
 
-	ma200, err = calculateSMA(historicalData, 200)
+	ma200, err = calculateSMA(indicators, defaultStrategyParams.MAPeriod, basis)
 	if err != nil {
 		// Return the historical data even if SMA calculation fails, but also return the error
 		return currentPrice, 0, 0, historicalData, fmt.Errorf("failed to calculate SMA: %w", err)
 	}
 
-	emaTrend, err = calculateEMATrend(historicalData, 112)
+	emaTrend, err = calculateEMATrend(indicators, defaultStrategyParams.EMAPeriod, basis)
 	if err != nil {
 		return currentPrice, ma200, 0, historicalData, fmt.Errorf("failed to calculate EMA Trend: %w", err)
 	}
@@ -107,7 +136,133 @@ func fetchAndAnalyzeStock(ticker string) (currentPrice float64, ma200 float64, e
 	return currentPrice, ma200, emaTrend, historicalData, nil
 }
 
-func calculateEMATrend(prices []HistoricalPrice, period int) (float64, error) {
+// fetchPriceHistory returns the daily closes of ticker between from and to (inclusive),
+// ordered oldest to newest.
+func fetchPriceHistory(ticker string, from, to time.Time) ([]HistoricalPrice, error) {
+	url := fmt.Sprintf("https://financialmodelingprep.com/api/v3/historical-price-full/%s?from=%s&to=%s&apikey=%s",
+		url.PathEscape(ticker), from.Format("2006-01-02"), to.Format("2006-01-02"), fmpApiKey)
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status from API: %s", resp.Status)
+	}
+
+	var result struct {
+		Symbol     string            `json:"symbol"`
+		Historical []HistoricalPrice `json:"historical"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	if len(result.Historical) == 0 {
+		return nil, fmt.Errorf("no historical prices found for %s", ticker)
+	}
+
+	// The API returns the newest price first
+	prices := result.Historical
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Date < prices[j].Date })
+	return prices, nil
+}
+
+// fetchSplits returns the stock splits of ticker on or after from, as pending actions.
+func fetchSplits(ticker string, from time.Time) ([]CorporateAction, error) {
+	var result struct {
+		Historical []struct {
+			Date        string  `json:"date"`
+			Numerator   float64 `json:"numerator"`
+			Denominator float64 `json:"denominator"`
+		} `json:"historical"`
+	}
+	if err := getFMP("historical-price-full/stock_split/"+url.PathEscape(ticker), &result); err != nil {
+		return nil, err
+	}
+	var actions []CorporateAction
+	for _, h := range result.Historical {
+		if h.Date < from.Format("2006-01-02") || h.Numerator <= 0 || h.Denominator <= 0 {
+			continue
+		}
+		actions = append(actions, CorporateAction{Ticker: ticker, Type: actionSplit, Date: h.Date, Ratio: h.Numerator / h.Denominator})
+	}
+	return actions, nil
+}
+
+// fetchDividends returns the dividends of ticker that went ex on or after from, as
+// pending actions.
+func fetchDividends(ticker string, from time.Time) ([]CorporateAction, error) {
+	var result struct {
+		Historical []struct {
+			Date        string  `json:"date"` // Ex-dividend date
+			PaymentDate string  `json:"paymentDate"`
+			Dividend    float64 `json:"dividend"`
+		} `json:"historical"`
+	}
+	if err := getFMP("historical-price-full/stock_dividend/"+url.PathEscape(ticker), &result); err != nil {
+		return nil, err
+	}
+	var actions []CorporateAction
+	for _, h := range result.Historical {
+		if h.Date < from.Format("2006-01-02") || h.Dividend <= 0 {
+			continue
+		}
+		actions = append(actions, CorporateAction{Ticker: ticker, Type: actionDividend, Date: h.Date, PayDate: h.PaymentDate, Amount: h.Dividend})
+	}
+	return actions, nil
+}
+
+// fetchProfile returns the company profile of ticker: its sector, industry, country,
+// trading currency and asset type.
+func fetchProfile(ticker string) (CompanyProfile, error) {
+	var result []struct {
+		Sector   string `json:"sector"`
+		Industry string `json:"industry"`
+		Country  string `json:"country"`
+		Currency string `json:"currency"`
+		IsETF    bool   `json:"isEtf"`
+		IsFund   bool   `json:"isFund"`
+		IsADR    bool   `json:"isAdr"`
+	}
+	if err := getFMP("profile/"+url.PathEscape(ticker), &result); err != nil {
+		return CompanyProfile{}, err
+	}
+	if len(result) == 0 {
+		return CompanyProfile{}, fmt.Errorf("no profile found for %s", ticker)
+	}
+	r := result[0]
+	profile := CompanyProfile{Sector: r.Sector, Industry: r.Industry, Country: r.Country, Currency: r.Currency, AssetType: assetStock, Updated: time.Now()}
+	switch {
+	case r.IsETF:
+		profile.AssetType = assetETF
+	case r.IsFund:
+		profile.AssetType = assetFund
+	case r.IsADR:
+		profile.AssetType = assetADR
+	}
+	return profile, nil
+}
+
+// getFMP decodes the JSON returned by an FMP v3 endpoint into v.
+func getFMP(path string, v any) error {
+	resp, err := http.Get(fmt.Sprintf("https://financialmodelingprep.com/api/v3/%s?apikey=%s", path, fmpApiKey))
+	if err != nil {
+		return fmt.Errorf("failed to get data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status from API: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	return nil
+}
+
+func calculateEMATrend(prices []HistoricalPrice, period int, basis priceBasis) (float64, error) {
 	if len(prices) < period {
 		return 0, fmt.Errorf("not enough data to calculate %d-day EMA Trend", period)
 	}
@@ -116,7 +271,7 @@ func calculateEMATrend(prices []HistoricalPrice, period int) (float64, error) {
 	var returns []float64
 	for i := 0; i < len(prices)-1; i++ {
 		// Formula: (today's price - yesterday's price) / yesterday's price
-		ret := (prices[i].Close - prices[i+1].Close) / prices[i+1].Close
+		ret := (prices[i].value(basis) - prices[i+1].value(basis)) / prices[i+1].value(basis)
 		returns = append(returns, ret)
 	}
 
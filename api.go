@@ -0,0 +1,517 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiRoutes lists every JSON route. The router, the OpenAPI document and the request
+// validation middleware are all generated from this table.
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{Method: http.MethodGet, Path: "/api/v1/holdings", ID: "listHoldings", Summary: "List all holdings", Tag: "holdings",
+			Responses: map[int]any{http.StatusOK: []Stock{}}, Handler: apiListHoldings},
+		{Method: http.MethodPost, Path: "/api/v1/holdings", ID: "createHolding", Summary: "Add a holding, replacing any with the same ticker", Tag: "holdings",
+			Body: CreateHoldingRequest{}, Responses: map[int]any{http.StatusCreated: Stock{}}, Handler: apiCreateHolding},
+		{Method: http.MethodGet, Path: "/api/v1/holdings/:ticker", ID: "getHolding", Summary: "Get a holding", Tag: "holdings",
+			Responses: map[int]any{http.StatusOK: Stock{}}, Handler: apiGetHolding},
+		{Method: http.MethodPut, Path: "/api/v1/holdings/:ticker", ID: "updateHolding", Summary: "Update the quantity, purchase price and order rules of a holding", Tag: "holdings",
+			Body: UpdateHoldingRequest{}, Responses: map[int]any{http.StatusOK: Stock{}}, Handler: apiUpdateHolding},
+		{Method: http.MethodDelete, Path: "/api/v1/holdings/:ticker", ID: "deleteHolding", Summary: "Delete a holding", Tag: "holdings",
+			Responses: map[int]any{http.StatusNoContent: nil}, Handler: apiDeleteHolding},
+
+		{Method: http.MethodGet, Path: "/api/v1/holdings/:ticker/dividends", ID: "listDividends", Summary: "List the dividends of a holding, applied or pending review", Tag: "holdings",
+			Responses: map[int]any{http.StatusOK: []CorporateAction{}}, Handler: apiListDividends},
+		{Method: http.MethodGet, Path: "/api/v1/dividends/income", ID: "dividendIncome", Summary: "Project the yearly dividend income and total return of the holdings", Tag: "holdings",
+			Responses: map[int]any{http.StatusOK: IncomeProjection{}}, Handler: apiDividendIncome},
+		{Method: http.MethodGet, Path: "/api/v1/exposure", ID: "exposure", Summary: "Break the holdings down by sector, country, currency and asset class, with concentration and limit flags", Tag: "holdings",
+			Responses: map[int]any{http.StatusOK: ExposureReport{}}, Handler: apiExposure},
+
+		{Method: http.MethodGet, Path: "/api/v1/settings", ID: "getSettings", Summary: "Get the budget and next batch number", Tag: "settings",
+			Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiGetSettings},
+		{Method: http.MethodPut, Path: "/api/v1/settings", ID: "updateSettings", Summary: "Update the budget for the next allocation", Tag: "settings",
+			Body: SettingsRequest{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateSettings},
+		{Method: http.MethodPut, Path: "/api/v1/settings/costs", ID: "updateCosts", Summary: "Update the fees, spread and slippage applied to allocations", Tag: "settings",
+			Body: CostModel{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateCosts},
+		{Method: http.MethodPut, Path: "/api/v1/settings/drift", ID: "updateDriftBand", Summary: "Update the drift at which the rebalance strategy sells", Tag: "settings",
+			Body: DriftBandRequest{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateDriftBand},
+		{Method: http.MethodPut, Path: "/api/v1/settings/prices", ID: "updatePriceBasis", Summary: "Choose raw or adjusted closes for the MA-200 and EMA trend", Tag: "settings",
+			Body: PriceBasisRequest{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdatePriceBasis},
+		{Method: http.MethodPut, Path: "/api/v1/settings/benchmark", ID: "updateBenchmark", Summary: "Choose the index or ETF the portfolio and strategies are compared with", Tag: "settings",
+			Body: BenchmarkRequest{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateBenchmark},
+		{Method: http.MethodPut, Path: "/api/v1/settings/indicators", ID: "updateIndicatorColumns", Summary: "Choose the indicator columns of the dashboard's holdings table", Tag: "settings",
+			Body: IndicatorColumnsRequest{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateIndicators},
+		{Method: http.MethodPut, Path: "/api/v1/settings/value-averaging", ID: "updateValueAveraging", Summary: "Update the value averaging plan that sizes contributions", Tag: "settings",
+			Body: ValueAveraging{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateValueAveraging},
+		{Method: http.MethodPut, Path: "/api/v1/settings/exposure", ID: "updateExposureLimits", Summary: "Update the shares of the portfolio above which exposures are flagged", Tag: "settings",
+			Body: ExposureLimits{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiUpdateExposureLimits},
+		{Method: http.MethodPost, Path: "/api/v1/settings/watchlist", ID: "addToWatchlist", Summary: "Add a stock to the watchlist, or rename it", Tag: "settings",
+			Body: WatchlistItem{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiAddToWatchlist},
+		{Method: http.MethodDelete, Path: "/api/v1/settings/watchlist/:ticker", ID: "removeFromWatchlist", Summary: "Remove a stock from the watchlist", Tag: "settings",
+			Responses: map[int]any{http.StatusNoContent: nil}, Handler: apiRemoveFromWatchlist},
+
+		{Method: http.MethodGet, Path: "/api/v1/rules", ID: "listRuleStrategies", Summary: "List the user-defined rule strategies", Tag: "rules",
+			Responses: map[int]any{http.StatusOK: []RuleStrategy{}}, Handler: apiListRuleStrategies},
+		{Method: http.MethodPut, Path: "/api/v1/rules/:key", ID: "saveRuleStrategy", Summary: "Create or replace a rule strategy", Tag: "rules",
+			Body: RuleStrategy{}, Responses: map[int]any{http.StatusOK: Settings{}}, Handler: apiSaveRuleStrategy},
+		{Method: http.MethodDelete, Path: "/api/v1/rules/:key", ID: "deleteRuleStrategy", Summary: "Delete a rule strategy; its logs are kept", Tag: "rules",
+			Responses: map[int]any{http.StatusNoContent: nil}, Handler: apiDeleteRuleStrategy},
+
+		{Method: http.MethodGet, Path: "/api/v1/corporate-actions", ID: "listCorporateActions", Summary: "List the splits and dividends of the holdings, newest first", Tag: "corporate-actions",
+			Query: []Parameter{
+				queryParam("status", "Only actions in this review state", &Schema{Type: "string", Enum: []string{actionPending, actionApplied, actionDismissed}}),
+				queryParam("limit", "Page size", &Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(maxLogPageSize))}),
+				queryParam("offset", "Number of actions to skip", &Schema{Type: "integer", Minimum: ptr(0.0)}),
+			},
+			Responses: map[int]any{http.StatusOK: CorporateActionPage{}}, Handler: apiListCorporateActions},
+		{Method: http.MethodPost, Path: "/api/v1/corporate-actions", ID: "addCorporateAction", Summary: "Enter a split or dividend by hand for review", Tag: "corporate-actions",
+			Body: CorporateActionRequest{}, Responses: map[int]any{http.StatusCreated: CorporateAction{}}, Handler: apiAddCorporateAction},
+		{Method: http.MethodPost, Path: "/api/v1/corporate-actions/fetch", ID: "fetchCorporateActions", Summary: "Fetch the recent splits and dividends of every holding from the provider", Tag: "corporate-actions",
+			Responses: map[int]any{http.StatusOK: CorporateActionFetch{}}, Handler: apiFetchCorporateActions},
+		{Method: http.MethodPost, Path: "/api/v1/corporate-actions/:id/apply", ID: "applyCorporateAction", Summary: "Apply a pending split to its holding, or a dividend to the holding (DRIP) or the budget", Tag: "corporate-actions",
+			Responses: map[int]any{http.StatusOK: CorporateAction{}}, Handler: apiApplyCorporateAction},
+		{Method: http.MethodPost, Path: "/api/v1/corporate-actions/:id/dismiss", ID: "dismissCorporateAction", Summary: "Mark a pending action as not applying to the portfolio", Tag: "corporate-actions",
+			Responses: map[int]any{http.StatusOK: CorporateAction{}}, Handler: apiDismissCorporateAction},
+
+		{Method: http.MethodPost, Path: "/api/v1/analysis", ID: "runAnalysis", Summary: "Refresh prices, MA-200 and EMA trend of every holding", Tag: "analysis",
+			Responses: map[int]any{http.StatusOK: AnalysisResult{}}, Handler: apiRunAnalysis},
+
+		{Method: http.MethodGet, Path: "/api/v1/allocations/preview", ID: "previewAllocation", Summary: "Show what every strategy would do without writing anything", Tag: "allocations",
+			Responses: map[int]any{http.StatusOK: AllocationPlan{}}, Handler: apiPreviewAllocation},
+		{Method: http.MethodPost, Path: "/api/v1/allocations", ID: "commitAllocation", Summary: "Allocate the budget and log every strategy", Tag: "allocations",
+			Responses: map[int]any{http.StatusCreated: AllocationPlan{}}, Handler: apiCommitAllocation},
+
+		{Method: http.MethodGet, Path: "/api/v1/logs", ID: "listLogs", Summary: "Query investment logs", Tag: "logs",
+			Query: []Parameter{
+				queryParam("strategy", "Only logs of this strategy, a built-in or rule strategy key", &Schema{Type: "string"}),
+				queryParam("ticker", "Only logs for this ticker", &Schema{Type: "string"}),
+				queryParam("batch", "Only logs of this batch", &Schema{Type: "integer", Minimum: ptr(1.0)}),
+				queryParam("from", "Only logs on or after this date", &Schema{Type: "string", Format: "date"}),
+				queryParam("to", "Only logs on or before this date", &Schema{Type: "string", Format: "date"}),
+				queryParam("limit", "Page size", &Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(maxLogPageSize))}),
+				queryParam("offset", "Number of logs to skip", &Schema{Type: "integer", Minimum: ptr(0.0)}),
+			},
+			Responses: map[int]any{http.StatusOK: LogPage{}}, Handler: apiListLogs},
+		{Method: http.MethodDelete, Path: "/api/v1/logs/:strategy/:id", ID: "deleteLog", Summary: "Delete a log entry", Tag: "logs",
+			Responses: map[int]any{http.StatusNoContent: nil}, Handler: apiDeleteLog},
+
+		{Method: http.MethodGet, Path: "/api/v1/audit", ID: "listAudit", Summary: "Query the audit trail of user actions", Tag: "audit",
+			Query: []Parameter{
+				queryParam("action", "Only entries for this action", &Schema{Type: "string", Enum: auditActions}),
+				queryParam("actor", "Only entries by this user", &Schema{Type: "string"}),
+				queryParam("from", "Only entries on or after this date", &Schema{Type: "string", Format: "date"}),
+				queryParam("to", "Only entries on or before this date", &Schema{Type: "string", Format: "date"}),
+				queryParam("limit", "Page size", &Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(maxLogPageSize))}),
+				queryParam("offset", "Number of entries to skip", &Schema{Type: "integer", Minimum: ptr(0.0)}),
+			},
+			Responses: map[int]any{http.StatusOK: AuditPage{}}, Handler: apiListAudit},
+
+		{Method: http.MethodPost, Path: "/api/v1/backtests", ID: "runBacktest", Summary: "Replay every strategy over historical prices with a simulated contribution schedule", Tag: "backtests",
+			Body: BacktestRequest{}, Responses: map[int]any{http.StatusOK: BacktestResult{}}, Handler: apiRunBacktest},
+		{Method: http.MethodPost, Path: "/api/v1/backtests/sweep", ID: "runSweep", Summary: "Backtest a grid of strategy parameters with walk-forward train/test validation", Tag: "backtests",
+			Body: SweepRequest{}, Responses: map[int]any{http.StatusOK: SweepResult{}}, Handler: apiRunSweep},
+		{Method: http.MethodPost, Path: "/api/v1/backtests/montecarlo", ID: "runMonteCarlo", Summary: "Simulate every strategy over block-bootstrapped synthetic price paths", Tag: "backtests",
+			Body: MonteCarloRequest{}, Responses: map[int]any{http.StatusOK: MonteCarloResult{}}, Handler: apiRunMonteCarlo},
+
+		{Method: http.MethodGet, Path: "/api/v1/portfolio/as-of", ID: "portfolioAsOf", Summary: "Rebuild holdings, settings and logs as of a point in time by replaying events", Tag: "events",
+			Query: []Parameter{
+				queryParam("at", "Date (YYYY-MM-DD, end of day UTC) or RFC 3339 timestamp; defaults to now", &Schema{Type: "string"}),
+			},
+			Responses: map[int]any{http.StatusOK: PortfolioState{}}, Handler: apiPortfolioAsOf},
+		{Method: http.MethodGet, Path: "/api/v1/events", ID: "listEvents", Summary: "List the recorded state-change events, oldest first", Tag: "events",
+			Query: []Parameter{
+				queryParam("limit", "Page size", &Schema{Type: "integer", Minimum: ptr(1.0), Maximum: ptr(float64(maxLogPageSize))}),
+				queryParam("offset", "Number of events to skip", &Schema{Type: "integer", Minimum: ptr(0.0)}),
+			},
+			Responses: map[int]any{http.StatusOK: EventPage{}}, Handler: apiListEvents},
+		{Method: http.MethodPost, Path: "/api/v1/projections/rebuild", ID: "rebuildProjections", Summary: "Overwrite the portfolio, settings and log collections by replaying every event", Tag: "events",
+			Responses: map[int]any{http.StatusOK: PortfolioState{}}, Handler: apiRebuildProjections},
+
+		{Method: http.MethodGet, Path: "/api/portfolio-history", ID: "portfolioHistory", Summary: "Value of every strategy over time", Tag: "history",
+			Query:     historyRangeParams(),
+			Responses: map[int]any{http.StatusOK: PortfolioHistory{}}, Handler: handlePortfolioHistory},
+		{Method: http.MethodGet, Path: "/api/v1/performance", ID: "performance", Summary: "Time and money-weighted returns and risk of the portfolio and each strategy", Tag: "history",
+			Query: append([]Parameter{
+				queryParam("riskFree", "Yearly risk-free rate for the Sharpe and Sortino ratios, 0.03 = 3%; defaults to 0", &Schema{Type: "number"}),
+			}, historyRangeParams()...),
+			Responses: map[int]any{http.StatusOK: PerformanceReport{}}, Handler: apiPerformance},
+	}
+}
+
+// historyRangeParams are the query parameters of the endpoints built on the portfolio
+// history.
+func historyRangeParams() []Parameter {
+	return []Parameter{
+		queryParam("start", "First date; defaults to the first log", &Schema{Type: "string", Format: "date"}),
+		queryParam("end", "Last date; defaults to today", &Schema{Type: "string", Format: "date"}),
+		queryParam("resolution", "Value every weekday, every Friday or the last weekday of every month; defaults to weekly", &Schema{Type: "string", Enum: []string{resolutionDaily, resolutionWeekly, resolutionMonthly}}),
+	}
+}
+
+// registerAPIRoutes adds every JSON route to the given (authenticated) router group,
+// each behind the request validation middleware.
+func registerAPIRoutes(rg *gin.RouterGroup) {
+	for _, r := range apiRoutes() {
+		rg.Handle(r.Method, r.Path, validateRequest(r), r.Handler)
+	}
+}
+
+// APIError is the body returned with every non-2xx API response.
+type APIError struct {
+	Error   string   `json:"error"`
+	Details []string `json:"details,omitempty"`
+}
+
+// respondError writes err as a JSON error body, choosing the status code from its type.
+func respondError(c *gin.Context, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errInvalidInput):
+		code = http.StatusBadRequest
+	case errors.Is(err, errNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errUpstream):
+		code = http.StatusBadGateway
+	}
+	if code == http.StatusInternalServerError {
+		log.Printf("API error on %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+	c.AbortWithStatusJSON(code, APIError{Error: err.Error()})
+}
+
+func badRequest(c *gin.Context, msg string) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, APIError{Error: msg})
+}
+
+func apiListHoldings(c *gin.Context) {
+	stocks, err := listStocks(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if stocks == nil {
+		stocks = []Stock{}
+	}
+	c.JSON(http.StatusOK, stocks)
+}
+
+func apiGetHolding(c *gin.Context) {
+	stock, err := getStock(c.Request.Context(), c.Param("ticker"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stock)
+}
+
+// CreateHoldingRequest is the body accepted by POST /api/v1/holdings.
+type CreateHoldingRequest struct {
+	Ticker       string  `json:"ticker" openapi:"required,minLength=1"`
+	Name         string  `json:"name"`
+	Quantity     float64 `json:"quantity" openapi:"required,min=0"`
+	Price        float64 `json:"price" openapi:"required,min=0"`
+	WholeShares  bool    `json:"wholeShares"`
+	MinOrder     float64 `json:"minOrder" openapi:"min=0"`
+	TargetWeight float64 `json:"targetWeight" openapi:"min=0,max=1"`
+	DRIP         bool    `json:"drip"`
+	Withholding  float64 `json:"withholding" openapi:"min=0,max=1"`
+}
+
+// UpdateHoldingRequest is the body accepted by PUT /api/v1/holdings/:ticker.
+type UpdateHoldingRequest struct {
+	Quantity     float64     `json:"quantity" openapi:"required,min=0"`
+	Price        float64     `json:"price" openapi:"required,min=0"`
+	Rules        *OrderRules `json:"rules"`                              // Left unchanged when omitted
+	TargetWeight *float64    `json:"targetWeight" openapi:"min=0,max=1"` // Left unchanged when omitted
+	// Left unchanged when omitted
+	Dividends *DividendOptions `json:"dividends"`
+}
+
+func apiCreateHolding(c *gin.Context) {
+	var req CreateHoldingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	stock := Stock{Ticker: req.Ticker, Name: req.Name, Quantity: req.Quantity, Price: req.Price, WholeShares: req.WholeShares, MinOrder: req.MinOrder, TargetWeight: req.TargetWeight,
+		DRIP: req.DRIP, Withholding: req.Withholding}
+	if err := saveStock(c.Request.Context(), stock); err != nil {
+		respondError(c, err)
+		return
+	}
+	created, err := getStock(c.Request.Context(), stock.Ticker)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Header("Location", "/api/v1/holdings/"+created.Ticker)
+	c.JSON(http.StatusCreated, created)
+}
+
+func apiUpdateHolding(c *gin.Context) {
+	var req UpdateHoldingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	ticker := c.Param("ticker")
+	if err := updateHolding(c.Request.Context(), ticker, req.Quantity, req.Price, req.Rules, req.TargetWeight, req.Dividends); err != nil {
+		respondError(c, err)
+		return
+	}
+	stock, err := getStock(c.Request.Context(), ticker)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, stock)
+}
+
+func apiDeleteHolding(c *gin.Context) {
+	if err := deleteStock(c.Request.Context(), c.Param("ticker")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func apiGetSettings(c *gin.Context) {
+	settings, err := getSettings(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// SettingsRequest is the body accepted by PUT /api/v1/settings.
+type SettingsRequest struct {
+	Amount float64 `json:"amount" openapi:"required,min=0"`
+}
+
+func apiUpdateSettings(c *gin.Context) {
+	var req SettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateBudget(c.Request.Context(), req.Amount)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func apiUpdateCosts(c *gin.Context) {
+	var req CostModel
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateCosts(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// DriftBandRequest is the body accepted by PUT /api/v1/settings/drift.
+type DriftBandRequest struct {
+	DriftBand float64 `json:"driftBand" openapi:"required,min=0,max=1"` // 0.05 = sell above target + 5 points; 0 never sells
+}
+
+func apiUpdateDriftBand(c *gin.Context) {
+	var req DriftBandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateDriftBand(c.Request.Context(), req.DriftBand)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// BenchmarkRequest is the body accepted by PUT /api/v1/settings/benchmark.
+type BenchmarkRequest struct {
+	Benchmark string `json:"benchmark"` // Ticker of an index or ETF, e.g. URTH; empty removes the benchmark
+}
+
+func apiUpdateBenchmark(c *gin.Context) {
+	var req BenchmarkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateBenchmark(c.Request.Context(), req.Benchmark)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// PriceBasisRequest is the body accepted by PUT /api/v1/settings/prices.
+type PriceBasisRequest struct {
+	AdjustedPrices bool `json:"adjustedPrices"` // true = split- and dividend-adjusted closes, false = raw closes
+}
+
+func apiUpdatePriceBasis(c *gin.Context) {
+	var req PriceBasisRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updatePriceBasis(c.Request.Context(), req.AdjustedPrices)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func apiUpdateExposureLimits(c *gin.Context) {
+	var req ExposureLimits
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateExposureLimits(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func apiUpdateValueAveraging(c *gin.Context) {
+	var req ValueAveraging
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateValueAveraging(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func apiRunAnalysis(c *gin.Context) {
+	result, err := runAnalysis(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func apiPreviewAllocation(c *gin.Context) {
+	plan, _, err := previewAllocation(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, plan)
+}
+
+func apiCommitAllocation(c *gin.Context) {
+	plan, err := commitAllocation(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, plan)
+}
+
+// LogPage is a single page of results from GET /api/v1/logs.
+type LogPage struct {
+	Total  int             `json:"total"`
+	Limit  int             `json:"limit"`
+	Offset int             `json:"offset"`
+	Items  []InvestmentLog `json:"items"`
+}
+
+const (
+	defaultLogPageSize = 50
+	maxLogPageSize     = 500
+)
+
+func apiListLogs(c *gin.Context) {
+	filter := LogFilter{
+		Strategy: c.Query("strategy"),
+		Ticker:   c.Query("ticker"),
+		Limit:    defaultLogPageSize,
+	}
+
+	var err error
+	if s := c.Query("limit"); s != "" {
+		if filter.Limit, err = strconv.Atoi(s); err != nil || filter.Limit < 1 || filter.Limit > maxLogPageSize {
+			badRequest(c, "limit must be between 1 and "+strconv.Itoa(maxLogPageSize))
+			return
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if filter.Offset, err = strconv.Atoi(s); err != nil || filter.Offset < 0 {
+			badRequest(c, "offset must be a non-negative integer")
+			return
+		}
+	}
+	if s := c.Query("batch"); s != "" {
+		if filter.Batch, err = strconv.Atoi(s); err != nil || filter.Batch < 1 {
+			badRequest(c, "batch must be a positive integer")
+			return
+		}
+	}
+	if s := c.Query("from"); s != "" {
+		if filter.From, err = time.Parse("2006-01-02", s); err != nil {
+			badRequest(c, "from must be a date in YYYY-MM-DD format")
+			return
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if filter.To, err = time.Parse("2006-01-02", s); err != nil {
+			badRequest(c, "to must be a date in YYYY-MM-DD format")
+			return
+		}
+		// Make the end date inclusive
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+
+	logs, total, err := queryLogs(c.Request.Context(), filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if logs == nil {
+		logs = []InvestmentLog{}
+	}
+	c.JSON(http.StatusOK, LogPage{Total: total, Limit: filter.Limit, Offset: filter.Offset, Items: logs})
+}
+
+func apiDeleteLog(c *gin.Context) {
+	if err := deleteLog(c.Request.Context(), c.Param("strategy"), c.Param("id")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
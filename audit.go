@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+// auditCollection holds one document per user action.
+const auditCollection = "audit_log"
+
+// Audited actions.
+const (
+	auditLogin          = "auth.login"
+	auditLogout         = "auth.logout"
+	auditStockAdd       = "stock.add"
+	auditStockUpdate    = "stock.update"
+	auditStockDelete    = "stock.delete"
+	auditBudgetUpdate   = "settings.budget"
+	auditCostsUpdate    = "settings.costs"
+	auditDriftUpdate    = "settings.drift"
+	auditValueAvgUpdate = "settings.valueavg"
+	auditWatchlist      = "watchlist.update"
+	auditRuleUpdate     = "rules.update"
+	auditIndicators     = "settings.columns"
+	auditPriceBasis     = "settings.prices"
+	auditBenchmark      = "settings.benchmark"
+	auditExposureLimits = "settings.exposure"
+	auditActionFetch    = "action.fetch"
+	auditActionAdd      = "action.add"
+	auditActionApply    = "action.apply"
+	auditActionDismiss  = "action.dismiss"
+	auditAnalysis       = "analysis.run"
+	auditAllocation     = "allocation.commit"
+	auditLogDelete      = "log.delete"
+	auditLogBatchDelete = "log.delete_batch"
+)
+
+// Actor identifies who performed an action and from where.
+type Actor struct {
+	User string
+	IP   string
+}
+
+type actorKey struct{}
+
+// withActor returns a copy of ctx that carries the acting user, so the service layer
+// can attribute the changes it makes.
+func withActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return actor
+	}
+	return Actor{User: "unknown"}
+}
+
+// AuditEntry is a document in the audit_log collection.
+type AuditEntry struct {
+	ID        string                 `firestore:"-" json:"id"`
+	Action    string                 `firestore:"action" json:"action"`
+	Actor     string                 `firestore:"actor" json:"actor"`
+	IP        string                 `firestore:"ip" json:"ip"`
+	Target    string                 `firestore:"target" json:"target"`
+	Before    map[string]interface{} `firestore:"before" json:"before"`
+	After     map[string]interface{} `firestore:"after" json:"after"`
+	Outcome   string                 `firestore:"outcome" json:"outcome"` // "success" or "failure"
+	Error     string                 `firestore:"error,omitempty" json:"error,omitempty"`
+	Timestamp time.Time              `firestore:"timestamp" json:"timestamp"`
+}
+
+// BeforeJSON renders the state before the action for display.
+func (e AuditEntry) BeforeJSON() string { return auditValueJSON(e.Before) }
+
+// AfterJSON renders the state after the action for display.
+func (e AuditEntry) AfterJSON() string { return auditValueJSON(e.After) }
+
+func auditValueJSON(v map[string]interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// jsonObject converts a value into the generic map stored in Firestore, using its
+// JSON field names so stored documents read the same as the API.
+func jsonObject(v any) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return map[string]interface{}{"value": fmt.Sprint(v)}
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		// Not an object, e.g. a plain number
+		var value interface{}
+		json.Unmarshal(b, &value)
+		return map[string]interface{}{"value": value}
+	}
+	return m
+}
+
+// recordAudit writes an audit entry for action on target. A nil before or after means
+// the target did not exist before or after the action. Failures to write the audit
+// entry are logged but never fail the action itself.
+func recordAudit(ctx context.Context, action, target string, before, after any, actionErr error) {
+	actor := actorFrom(ctx)
+	entry := AuditEntry{
+		Action:    action,
+		Actor:     actor.User,
+		IP:        actor.IP,
+		Target:    target,
+		Before:    jsonObject(before),
+		After:     jsonObject(after),
+		Outcome:   "success",
+		Timestamp: time.Now(),
+	}
+	if actionErr != nil {
+		entry.Outcome = "failure"
+		entry.Error = actionErr.Error()
+	}
+
+	// Use a fresh context so the entry is written even if the request was cancelled
+	if _, _, err := firestoreClient.Collection(auditCollection).Add(context.WithoutCancel(ctx), entry); err != nil {
+		log.Printf("Failed to write audit entry for %s %s: %v", action, target, err)
+	}
+}
+
+// AuditFilter narrows down an audit query. Zero values mean "no filter".
+type AuditFilter struct {
+	Action string
+	Actor  string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// queryAudit returns the audit entries matching filter, newest first, together with
+// the total number of matches before pagination was applied. The filters and the page
+// are evaluated by Firestore, so only the requested entries are read.
+func queryAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, int, error) {
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, 0, fmt.Errorf("%w: limit and offset must not be negative", errInvalidInput)
+	}
+
+	q := firestoreClient.Collection(auditCollection).Query
+	if filter.Action != "" {
+		q = q.Where("action", "==", filter.Action)
+	}
+	if filter.Actor != "" {
+		q = q.Where("actor", "==", filter.Actor)
+	}
+	if !filter.From.IsZero() {
+		q = q.Where("timestamp", ">=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		q = q.Where("timestamp", "<", filter.To)
+	}
+	q = q.OrderBy("timestamp", firestore.Desc)
+
+	paginated := filter.Limit > 0 || filter.Offset > 0
+	total := 0
+	if paginated {
+		count, err := countDocuments(ctx, q)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+		}
+		total = count
+		q = q.Offset(filter.Offset)
+		if filter.Limit > 0 {
+			q = q.Limit(filter.Limit)
+		}
+	}
+
+	matches := []AuditEntry{}
+	iter := q.Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to iterate audit log: %w", err)
+		}
+
+		var entry AuditEntry
+		if err := doc.DataTo(&entry); err != nil {
+			log.Printf("Failed to convert audit document %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		entry.ID = doc.Ref.ID
+		matches = append(matches, entry)
+	}
+	if !paginated {
+		total = len(matches)
+	}
+	return matches, total, nil
+}
+
+// auditFilterFromQuery reads the filters shared by the audit page, export and API.
+func auditFilterFromQuery(c *gin.Context) (AuditFilter, error) {
+	filter := AuditFilter{Action: c.Query("action"), Actor: c.Query("actor")}
+	var err error
+	if s := c.Query("from"); s != "" {
+		if filter.From, err = time.Parse("2006-01-02", s); err != nil {
+			return filter, fmt.Errorf("%w: from must be a date in YYYY-MM-DD format", errInvalidInput)
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if filter.To, err = time.Parse("2006-01-02", s); err != nil {
+			return filter, fmt.Errorf("%w: to must be a date in YYYY-MM-DD format", errInvalidInput)
+		}
+		// Make the end date inclusive
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// auditActions lists every audited action, for the filter on the audit page.
+var auditActions = []string{
+	auditLogin, auditLogout, auditStockAdd, auditStockUpdate, auditStockDelete, auditBudgetUpdate,
+	auditCostsUpdate, auditDriftUpdate, auditValueAvgUpdate, auditWatchlist, auditRuleUpdate, auditIndicators,
+	auditPriceBasis, auditBenchmark, auditExposureLimits, auditActionFetch, auditActionAdd, auditActionApply, auditActionDismiss, auditAnalysis,
+	auditAllocation, auditLogDelete, auditLogBatchDelete,
+}
+
+func showAuditPage(c *gin.Context) {
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit = 500
+
+	entries, total, err := queryAudit(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to load audit log: %v", err)
+	}
+
+	c.HTML(http.StatusOK, "audit.tmpl.html", gin.H{
+		"Entries":  entries,
+		"Total":    total,
+		"Actions":  auditActions,
+		"Filter":   filter,
+		"FromDate": c.Query("from"),
+		"ToDate":   c.Query("to"),
+	})
+}
+
+// handleAuditExport downloads the (filtered) audit log as CSV or JSON.
+func handleAuditExport(c *gin.Context) {
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, _, err := queryAudit(c.Request.Context(), filter)
+	if err != nil {
+		log.Printf("Failed to export audit log: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to export audit log")
+		return
+	}
+
+	filename := "audit-" + time.Now().Format("2006-01-02")
+	switch c.DefaultQuery("format", "csv") {
+	case "json":
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		c.JSON(http.StatusOK, entries)
+	case "csv":
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"timestamp", "action", "actor", "ip", "target", "outcome", "error", "before", "after"})
+		for _, e := range entries {
+			w.Write([]string{
+				e.Timestamp.Format(time.RFC3339), e.Action, e.Actor, e.IP, e.Target,
+				e.Outcome, e.Error, e.BeforeJSON(), e.AfterJSON(),
+			})
+		}
+		w.Flush()
+	default:
+		c.String(http.StatusBadRequest, "format must be csv or json")
+	}
+}
+
+// AuditPage is a single page of results from GET /api/v1/audit.
+type AuditPage struct {
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+	Items  []AuditEntry `json:"items"`
+}
+
+func apiListAudit(c *gin.Context) {
+	filter, err := auditFilterFromQuery(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	filter.Limit = defaultLogPageSize
+	if s := c.Query("limit"); s != "" {
+		if filter.Limit, err = strconv.Atoi(s); err != nil || filter.Limit < 1 || filter.Limit > maxLogPageSize {
+			badRequest(c, "limit must be between 1 and "+strconv.Itoa(maxLogPageSize))
+			return
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if filter.Offset, err = strconv.Atoi(s); err != nil || filter.Offset < 0 {
+			badRequest(c, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	entries, total, err := queryAudit(c.Request.Context(), filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, AuditPage{Total: total, Limit: filter.Limit, Offset: filter.Offset, Items: entries})
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The backtest engine replays every registered strategy over historical prices. It
+// is a pure simulation: prices are fetched from the market-data provider, but
+// nothing is read from or written to the strategy logs.
+
+const (
+	// analysisWindow is the number of trading days the indicators are computed from.
+	analysisWindow = 250
+	// historyWindow is the number of daily closes fetchAndAnalyzeStock fetches and
+	// the analysis stores with each holding, for strategies that look further back.
+	historyWindow = 300
+	// warmupDays is how many calendar days of prices are fetched before the start
+	// date so the indicators can be computed from the first contribution on.
+	warmupDays = 400
+)
+
+// indicatorWindow returns the number of daily closes the indicators are computed from.
+func (p StrategyParams) indicatorWindow() int {
+	return max(analysisWindow, p.MAPeriod, p.EMAPeriod)
+}
+
+// lookback returns the number of daily closes the indicators and strategies need
+// for params.
+func (p StrategyParams) lookback() int {
+	return max(p.indicatorWindow(), p.RiskWindow+1, momentumLookbacks[len(momentumLookbacks)-1]+p.MomentumSkip+1)
+}
+
+// warmupFor returns the calendar days of warm-up needed for params, allowing for
+// weekends and holidays when the lookback is longer than the default.
+func warmupFor(params StrategyParams) int {
+	days := params.lookback() * 3 / 2
+	return max(warmupDays, days+30)
+}
+
+// BacktestConfig describes a simulated contribution schedule.
+type BacktestConfig struct {
+	Tickers      []string
+	Start, End   time.Time
+	Contribution float64 // Amount added to the cash balance on every contribution date
+	Frequency    string  // "weekly" or "monthly"
+	Initial      float64 // Capital split equally across the tickers on the first date
+	Params       StrategyParams
+	Costs        CostModel // Applied to every simulated order, including the initial purchase
+	Rules        map[string]OrderRules
+	Targets      map[string]float64 // Target weights of the rebalance strategy
+	Strategies   []Strategy         // Strategies to simulate; nil means the built-in ones
+}
+
+// strategies returns the strategies the backtest simulates.
+func (cfg BacktestConfig) strategies() []Strategy {
+	if cfg.Strategies == nil {
+		return strategies
+	}
+	return cfg.Strategies
+}
+
+// contributionDates returns the schedule of contribution dates from Start to End.
+func (cfg BacktestConfig) contributionDates() []time.Time {
+	var dates []time.Time
+	for d := cfg.Start; !d.After(cfg.End); {
+		dates = append(dates, d)
+		if cfg.Frequency == "weekly" {
+			d = d.AddDate(0, 0, 7)
+		} else {
+			d = d.AddDate(0, 1, 0)
+		}
+	}
+	return dates
+}
+
+// BacktestPoint is the value of a simulated portfolio on a contribution date.
+type BacktestPoint struct {
+	Date        string  `json:"date"`
+	Value       float64 `json:"value"`       // Holdings plus uninvested cash
+	Contributed float64 `json:"contributed"` // Total contributed so far, including the initial capital
+	Cash        float64 `json:"cash"`
+}
+
+// BacktestBatch holds the trades a strategy made on one contribution date.
+type BacktestBatch struct {
+	Batch   int               `json:"batch"`
+	Date    string            `json:"date"`
+	Budget  float64           `json:"budget"`
+	Entries []AllocationEntry `json:"entries"`
+}
+
+// StrategyBacktest is the outcome of simulating one strategy.
+type StrategyBacktest struct {
+	Key         string             `json:"key"`
+	Strategy    string             `json:"strategy"`
+	FinalValue  float64            `json:"finalValue"`
+	Contributed float64            `json:"contributed"`
+	Cash        float64            `json:"cash"`
+	Fees        float64            `json:"fees"` // Total commission paid
+	Holdings    map[string]float64 `json:"holdings"`
+	EquityCurve []BacktestPoint    `json:"equityCurve"`
+	Batches     []BacktestBatch    `json:"batches"`
+}
+
+// BacktestResult is the outcome of a backtest across every strategy.
+type BacktestResult struct {
+	Start      string             `json:"start"`
+	End        string             `json:"end"`
+	Tickers    []string           `json:"tickers"`
+	Strategies []StrategyBacktest `json:"strategies"`
+}
+
+// analyzeAt computes what fetchAndAnalyzeStock would have returned on date d, using
+// the indicator periods in params. ok is false when there is no price on or before d.
+func analyzeAt(prices []HistoricalPrice, d time.Time, params StrategyParams) (stock Stock, ok bool) {
+	known := priceSeries(prices).upTo(d)
+	if len(known) == 0 {
+		return Stock{}, false
+	}
+	if window := params.lookback(); len(known) > window {
+		known = known[len(known)-window:]
+	}
+
+	// The indicator functions expect the newest price first, like the API returns them
+	window := make([]HistoricalPrice, len(known))
+	for i, p := range known {
+		window[len(known)-1-i] = p
+	}
+
+	stock.CurrentPrice = window[0].Close
+	stock.History = window
+	indicators := window[:min(len(window), params.indicatorWindow())]
+	if ma200, err := calculateSMA(indicators, params.MAPeriod, params.basis()); err == nil {
+		stock.MA200 = ma200
+		stock.IsBelowMA = stock.CurrentPrice < ma200
+	}
+	if emaTrend, err := calculateEMATrend(indicators, params.EMAPeriod, params.basis()); err == nil {
+		stock.EMATrend = emaTrend
+	}
+	return stock, true
+}
+
+// simulatedPortfolio is the state of one strategy during a backtest.
+type simulatedPortfolio struct {
+	strategy    Strategy
+	holdings    map[string]float64
+	cash        float64
+	contributed float64
+	fees        float64
+	result      StrategyBacktest
+}
+
+// value sums the holdings in ticker order, so the same inputs always give the same
+// floating-point result.
+func (sp *simulatedPortfolio) value(snapshot map[string]Stock) float64 {
+	value := sp.cash
+	for _, ticker := range slices.Sorted(maps.Keys(sp.holdings)) {
+		value += sp.holdings[ticker] * snapshot[ticker].CurrentPrice
+	}
+	return value
+}
+
+// view returns copies of stocks with the quantities this portfolio holds, as a strategy
+// would see them.
+func (sp *simulatedPortfolio) view(stocks []Stock) []*Stock {
+	current := make([]*Stock, len(stocks))
+	for i := range stocks {
+		stock := stocks[i]
+		stock.Quantity = sp.holdings[stock.Ticker]
+		current[i] = &stock
+	}
+	return current
+}
+
+// apply sizes the entries of a strategy with executeOrders and executes them, treating
+// negative quantities as sells. Sells are capped at the quantity held and their
+// proceeds, less fees, return to the cash balance.
+func (sp *simulatedPortfolio) apply(entries []AllocationEntry, stocks []*Stock, costs CostModel) []AllocationEntry {
+	var orders []AllocationEntry
+	for _, e := range entries {
+		if e.QuantityBought < 0 {
+			qty := math.Min(-e.QuantityBought, sp.holdings[e.Ticker])
+			if qty <= 0 {
+				continue
+			}
+			e.QuantityBought = -qty
+			e.InvestmentAmount = -qty * e.PricePerShare
+		}
+		if math.IsNaN(e.QuantityBought) || math.IsInf(e.QuantityBought, 0) {
+			continue
+		}
+		orders = append(orders, e)
+	}
+
+	executed := executeOrders(orders, stocks, costs)
+	for _, e := range executed {
+		sp.holdings[e.Ticker] += e.QuantityBought
+		sp.cash -= e.InvestmentAmount + e.Fees
+		sp.fees += e.Fees
+	}
+	return executed
+}
+
+// runBacktest simulates every registered strategy over prices, a map of ticker to
+// daily closes ordered oldest first. On each contribution date the contribution is
+// added to each strategy's cash, and the whole cash balance is offered to the
+// strategy as its budget. Cash a strategy does not spend rolls over.
+func runBacktest(cfg BacktestConfig, prices map[string][]HistoricalPrice) BacktestResult {
+	result := BacktestResult{
+		Start:   cfg.Start.Format("2006-01-02"),
+		End:     cfg.End.Format("2006-01-02"),
+		Tickers: cfg.Tickers,
+	}
+
+	portfolios := make([]*simulatedPortfolio, len(cfg.strategies()))
+	for i, s := range cfg.strategies() {
+		portfolios[i] = &simulatedPortfolio{
+			strategy: s,
+			holdings: make(map[string]float64),
+			result:   StrategyBacktest{Key: s.Key, Strategy: s.Name},
+		}
+	}
+
+	for batch, d := range cfg.contributionDates() {
+		snapshot := make(map[string]Stock)
+		var stocks []Stock
+		for _, ticker := range cfg.Tickers {
+			stock, ok := analyzeAt(prices[ticker], d, cfg.Params)
+			if !ok {
+				continue // Not trading yet
+			}
+			stock.Ticker, stock.Name = ticker, ticker
+			stock.WholeShares, stock.MinOrder = cfg.Rules[ticker].WholeShares, cfg.Rules[ticker].MinOrder
+			stock.TargetWeight = cfg.Targets[ticker]
+			snapshot[ticker] = stock
+			stocks = append(stocks, stock)
+		}
+		if len(stocks) == 0 {
+			continue
+		}
+
+		for _, sp := range portfolios {
+			if sp.contributed == 0 && cfg.Initial > 0 {
+				// Seed every strategy with the same equally weighted starting portfolio
+				var seed []AllocationEntry
+				for i := range stocks {
+					seed = append(seed, buyEntry(&stocks[i], cfg.Initial/float64(len(stocks))))
+				}
+				sp.cash += cfg.Initial
+				sp.contributed += cfg.Initial
+				sp.apply(seed, sp.view(stocks), cfg.Costs)
+			}
+			sp.cash += cfg.Contribution
+			sp.contributed += cfg.Contribution
+
+			current := sp.view(stocks)
+			budget := sp.cash
+			if executed := sp.apply(sp.strategy.Allocate(current, budget, cfg.Params), current, cfg.Costs); len(executed) > 0 {
+				sp.result.Batches = append(sp.result.Batches, BacktestBatch{
+					Batch:   batch + 1,
+					Date:    d.Format("2006-01-02"),
+					Budget:  budget,
+					Entries: executed,
+				})
+			}
+
+			sp.result.EquityCurve = append(sp.result.EquityCurve, BacktestPoint{
+				Date:        d.Format("2006-01-02"),
+				Value:       sp.value(snapshot),
+				Contributed: sp.contributed,
+				Cash:        sp.cash,
+			})
+		}
+	}
+
+	for _, sp := range portfolios {
+		if n := len(sp.result.EquityCurve); n > 0 {
+			sp.result.FinalValue = sp.result.EquityCurve[n-1].Value
+		}
+		sp.result.Contributed = sp.contributed
+		sp.result.Cash = sp.cash
+		sp.result.Fees = sp.fees
+		sp.result.Holdings = sp.holdings
+		result.Strategies = append(result.Strategies, sp.result)
+	}
+	return result
+}
+
+// fetchBacktestPrices loads the price history needed to backtest cfg, including the
+// warm-up period the indicators need.
+func fetchBacktestPrices(cfg BacktestConfig) (map[string][]HistoricalPrice, error) {
+	prices := make(map[string][]HistoricalPrice)
+	for _, ticker := range cfg.Tickers {
+		history, err := fetchPriceHistory(ticker, cfg.Start.AddDate(0, 0, -warmupFor(cfg.Params)), cfg.End)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch prices for %s: %w", ticker, err)
+		}
+		prices[ticker] = history
+	}
+	return prices, nil
+}
+
+// BacktestRequest is the body accepted by POST /api/v1/backtests.
+type BacktestRequest struct {
+	Tickers      []string        `json:"tickers"` // Defaults to the tickers in the portfolio
+	Start        string          `json:"start" openapi:"required,format=date"`
+	End          string          `json:"end" openapi:"format=date"` // Defaults to today
+	Contribution float64         `json:"contribution" openapi:"required,min=0"`
+	Frequency    string          `json:"frequency" openapi:"enum=weekly|monthly"` // Defaults to monthly
+	Initial      float64         `json:"initial" openapi:"min=0"`                 // Defaults to one contribution
+	Params       *StrategyParams `json:"params"`                                  // Defaults to the live parameters
+	Costs        *CostModel      `json:"costs"`                                   // Defaults to the live cost model
+	// TargetWeights of the rebalance strategy by ticker. Defaults to the targets of
+	// the holdings; with none at all, every ticker gets the same weight.
+	TargetWeights map[string]float64 `json:"targetWeights"`
+}
+
+// backtestConfig validates req and turns it into a BacktestConfig.
+func (req BacktestRequest) backtestConfig(ctx context.Context) (BacktestConfig, error) {
+	cfg := BacktestConfig{
+		Contribution: req.Contribution,
+		Frequency:    req.Frequency,
+		Initial:      req.Initial,
+		End:          time.Now().UTC().Truncate(24 * time.Hour),
+	}
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Params, cfg.Costs, cfg.Strategies = settings.liveParams(), settings.Costs, settings.allStrategies()
+	if cfg.Start, err = time.Parse("2006-01-02", req.Start); err != nil {
+		return cfg, fmt.Errorf("%w: start must be a date in YYYY-MM-DD format", errInvalidInput)
+	}
+	if req.End != "" {
+		if cfg.End, err = time.Parse("2006-01-02", req.End); err != nil {
+			return cfg, fmt.Errorf("%w: end must be a date in YYYY-MM-DD format", errInvalidInput)
+		}
+	}
+	if !cfg.Start.Before(cfg.End) {
+		return cfg, fmt.Errorf("%w: start must be before end", errInvalidInput)
+	}
+	if cfg.Frequency == "" {
+		cfg.Frequency = "monthly"
+	}
+	if cfg.Initial <= 0 {
+		cfg.Initial = cfg.Contribution
+	}
+	if req.Params != nil {
+		cfg.Params = req.Params.withDefaults()
+	}
+	if req.Costs != nil {
+		if err := req.Costs.validate(); err != nil {
+			return cfg, err
+		}
+		cfg.Costs = *req.Costs
+	}
+
+	// Holdings supply the default tickers, and the order rules and target weights of
+	// the tickers they cover
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Rules = make(map[string]OrderRules)
+	cfg.Targets = make(map[string]float64)
+	for _, s := range stocks {
+		cfg.Rules[strings.ToUpper(s.Ticker)] = OrderRules{WholeShares: s.WholeShares, MinOrder: s.MinOrder}
+		cfg.Targets[strings.ToUpper(s.Ticker)] = s.TargetWeight
+	}
+	if req.TargetWeights != nil {
+		cfg.Targets = make(map[string]float64)
+		for ticker, w := range req.TargetWeights {
+			if w < 0 || w > 1 {
+				return cfg, fmt.Errorf("%w: target weights must be between 0 and 1", errInvalidInput)
+			}
+			cfg.Targets[strings.ToUpper(strings.TrimSpace(ticker))] = w
+		}
+	}
+	for _, t := range req.Tickers {
+		if t = strings.ToUpper(strings.TrimSpace(t)); t != "" {
+			cfg.Tickers = append(cfg.Tickers, t)
+		}
+	}
+	if len(cfg.Tickers) == 0 {
+		for _, s := range stocks {
+			cfg.Tickers = append(cfg.Tickers, s.Ticker)
+		}
+	}
+	if len(cfg.Tickers) == 0 {
+		return cfg, fmt.Errorf("%w: no tickers to backtest", errInvalidInput)
+	}
+	return cfg, nil
+}
+
+func apiRunBacktest(c *gin.Context) {
+	var req BacktestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+	cfg, err := req.backtestConfig(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	prices, err := fetchBacktestPrices(cfg)
+	if err != nil {
+		respondError(c, fmt.Errorf("%w: %v", errUpstream, err))
+		return
+	}
+	c.JSON(http.StatusOK, runBacktest(cfg, prices))
+}
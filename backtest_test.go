@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// dailyPrices returns a close of price on every calendar day from start, oldest first.
+func dailyPrices(start string, days int, price float64) []HistoricalPrice {
+	prices := make([]HistoricalPrice, days)
+	for i := range prices {
+		prices[i] = HistoricalPrice{Date: day(start).AddDate(0, 0, i).Format(time.DateOnly), Close: price}
+	}
+	return prices
+}
+
+func dateStrings(dates []time.Time) []string {
+	s := make([]string, len(dates))
+	for i, d := range dates {
+		s[i] = d.Format(time.DateOnly)
+	}
+	return s
+}
+
+func TestContributionDates(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency string
+		start     string
+		end       string
+		want      []string
+	}{
+		{"weekly", "weekly", "2024-01-01", "2024-01-29", []string{"2024-01-01", "2024-01-08", "2024-01-15", "2024-01-22", "2024-01-29"}},
+		{"weekly ending mid-week", "weekly", "2024-01-01", "2024-01-20", []string{"2024-01-01", "2024-01-08", "2024-01-15"}},
+		{"monthly", "monthly", "2024-01-15", "2024-04-15", []string{"2024-01-15", "2024-02-15", "2024-03-15", "2024-04-15"}},
+		{"single date", "monthly", "2024-01-15", "2024-01-15", []string{"2024-01-15"}},
+		{"end before start", "weekly", "2024-01-15", "2024-01-01", []string{}},
+	}
+	for _, tt := range tests {
+		cfg := BacktestConfig{Start: day(tt.start), End: day(tt.end), Frequency: tt.frequency}
+		if got := dateStrings(cfg.contributionDates()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: contributionDates() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeAt(t *testing.T) {
+	// 210 days at 100, then a drop to 50 that the MA-200 has barely noticed
+	prices := append(dailyPrices("2023-01-01", 210, 100), dailyPrices("2023-07-30", 10, 50)...)
+
+	if _, ok := analyzeAt(prices, day("2022-12-31"), defaultStrategyParams); ok {
+		t.Error("analyzeAt before the first price: ok = true, want false")
+	}
+
+	early, ok := analyzeAt(prices, day("2023-01-31"), defaultStrategyParams)
+	if !ok || early.CurrentPrice != 100 || early.MA200 != 0 || early.IsBelowMA {
+		t.Errorf("analyzeAt with 31 prices = %+v, %v; want price 100 and no MA-200", early, ok)
+	}
+
+	late, ok := analyzeAt(prices, day("2023-08-08"), defaultStrategyParams)
+	if !ok || late.CurrentPrice != 50 {
+		t.Fatalf("analyzeAt after the drop = %+v, %v; want price 50", late, ok)
+	}
+	if want := (190*100.0 + 10*50) / 200; math.Abs(late.MA200-want) > 1e-9 || !late.IsBelowMA {
+		t.Errorf("MA200 = %v (below %v), want %v and below", late.MA200, late.IsBelowMA, want)
+	}
+	if late.EMATrend >= 0 {
+		t.Errorf("EMATrend = %v after the drop, want negative", late.EMATrend)
+	}
+}
+
+func TestSimulatedPortfolioApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		costs    CostModel
+		entries  []AllocationEntry
+		executed int
+		holdings float64
+		cash     float64
+	}{
+		{"buy", CostModel{}, []AllocationEntry{{Ticker: "A", InvestmentAmount: 50, PricePerShare: 10, QuantityBought: 5}}, 1, 7, 50},
+		{"buy with a fee", CostModel{FixedFee: 2}, []AllocationEntry{{Ticker: "A", InvestmentAmount: 52, PricePerShare: 10, QuantityBought: 5.2}}, 1, 7, 48},
+		{"sell within holdings", CostModel{}, []AllocationEntry{{Ticker: "A", InvestmentAmount: -10, PricePerShare: 10, QuantityBought: -1}}, 1, 1, 110},
+		{"sell capped at holdings", CostModel{}, []AllocationEntry{{Ticker: "A", InvestmentAmount: -50, PricePerShare: 10, QuantityBought: -5}}, 1, 0, 120},
+		{"sell with a fee", CostModel{FixedFee: 2}, []AllocationEntry{{Ticker: "A", InvestmentAmount: -20, PricePerShare: 10, QuantityBought: -2}}, 1, 0, 118},
+		{"invalid quantity", CostModel{}, []AllocationEntry{{Ticker: "A", InvestmentAmount: 50, PricePerShare: 0, QuantityBought: math.Inf(1)}}, 0, 2, 100},
+	}
+	for _, tt := range tests {
+		sp := &simulatedPortfolio{holdings: map[string]float64{"A": 2}, cash: 100}
+		executed := sp.apply(tt.entries, []*Stock{{Ticker: "A"}}, tt.costs)
+		if len(executed) != tt.executed || sp.holdings["A"] != tt.holdings || sp.cash != tt.cash {
+			t.Errorf("%s: executed %d, holds %v, cash %v; want %d, %v, %v",
+				tt.name, len(executed), sp.holdings["A"], sp.cash, tt.executed, tt.holdings, tt.cash)
+		}
+	}
+
+	sp := &simulatedPortfolio{holdings: map[string]float64{}}
+	if executed := sp.apply([]AllocationEntry{{Ticker: "B", InvestmentAmount: -10, PricePerShare: 10, QuantityBought: -1}}, nil, CostModel{}); len(executed) != 0 {
+		t.Errorf("selling a stock not held executed %+v, want nothing", executed)
+	}
+
+	// Whole-share holdings only buy what the amount covers
+	sp = &simulatedPortfolio{holdings: map[string]float64{}, cash: 100}
+	sp.apply([]AllocationEntry{buy("A", 55, 10)}, []*Stock{{Ticker: "A", WholeShares: true}}, CostModel{})
+	if sp.holdings["A"] != 5 || sp.cash != 50 {
+		t.Errorf("whole shares: holds %v with cash %v, want 5 and 50", sp.holdings["A"], sp.cash)
+	}
+}
+
+func TestRunBacktestFlatPrices(t *testing.T) {
+	prices := map[string][]HistoricalPrice{
+		"A": dailyPrices("2024-01-01", 31, 10),
+		"B": dailyPrices("2024-01-01", 31, 20),
+		"C": dailyPrices("2024-01-10", 22, 5), // Lists after the first contribution
+	}
+	cfg := BacktestConfig{
+		Tickers:      []string{"A", "B", "C"},
+		Start:        day("2024-01-01"),
+		End:          day("2024-01-22"),
+		Contribution: 100,
+		Frequency:    "weekly",
+		Initial:      1000,
+		Params:       defaultStrategyParams,
+	}
+	result := runBacktest(cfg, prices)
+
+	if len(result.Strategies) != len(strategies) {
+		t.Fatalf("got %d strategies, want %d", len(result.Strategies), len(strategies))
+	}
+	for _, s := range result.Strategies {
+		// Flat prices neither gain nor lose, whatever the strategy buys
+		if len(s.EquityCurve) != 4 || s.Contributed != 1400 || math.Abs(s.FinalValue-1400) > 1e-9 {
+			t.Errorf("%s: %d points, contributed %v, final value %v; want 4, 1400, 1400",
+				s.Key, len(s.EquityCurve), s.Contributed, s.FinalValue)
+		}
+	}
+
+	byKey := make(map[string]StrategyBacktest)
+	for _, s := range result.Strategies {
+		byKey[s.Key] = s
+	}
+	// Without 200 days of prices there is no MA-200, so the budget rolls over
+	// The initial capital is split across the tickers trading on the first date
+	if ma200 := byKey["ma200"]; ma200.Cash != 400 || len(ma200.Batches) != 0 || ma200.Holdings["A"] != 50 || ma200.Holdings["B"] != 25 {
+		t.Errorf("ma200: cash %v, %d batches, holdings %v; want 400, none, 50 A and 25 B", ma200.Cash, len(ma200.Batches), ma200.Holdings)
+	}
+	naive := byKey["naive"]
+	if math.Abs(naive.Cash) > 1e-9 || len(naive.Batches) != 4 {
+		t.Errorf("naive: cash %v and %d batches, want 0 and 4", naive.Cash, len(naive.Batches))
+	}
+	if naive.Holdings["C"] != 0 {
+		t.Errorf("naive bought %v of C, which it never held", naive.Holdings["C"])
+	}
+}
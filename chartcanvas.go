@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Charts are drawn once against a canvas, which either writes SVG elements or paints
+// the pixels of a PNG. Both only need the standard library, so the images can be made
+// without JavaScript or any network access.
+
+// point is a position on a canvas, in pixels from the top left.
+type point struct{ x, y float64 }
+
+// textAnchor is which end of a text its position refers to.
+type textAnchor int
+
+const (
+	anchorStart textAnchor = iota
+	anchorMiddle
+	anchorEnd
+)
+
+type canvas interface {
+	// polyline draws a line through points, dashed or solid.
+	polyline(points []point, c color.RGBA, width float64, dashed bool)
+	rect(x, y, w, h float64, c color.RGBA)
+	// wedge fills the slice of the circle around (cx, cy) between two angles, in
+	// radians clockwise from 12 o'clock.
+	wedge(cx, cy, r, from, to float64, c color.RGBA)
+	// text writes s vertically centred on y.
+	text(x, y float64, s string, size float64, anchor textAnchor, c color.RGBA)
+	textWidth(s string, size float64) float64
+}
+
+// svgCanvas writes the elements of an SVG document.
+type svgCanvas struct {
+	buf  bytes.Buffer
+	w, h int
+}
+
+func newSVGCanvas(w, h int) *svgCanvas {
+	cv := &svgCanvas{w: w, h: h}
+	fmt.Fprintf(&cv.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif">`+"\n", w, h, w, h)
+	cv.rect(0, 0, float64(w), float64(h), color.RGBA{255, 255, 255, 255})
+	return cv
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (cv *svgCanvas) polyline(points []point, c color.RGBA, width float64, dashed bool) {
+	coords := make([]string, len(points))
+	for i, p := range points {
+		coords[i] = fmt.Sprintf("%.1f,%.1f", p.x, p.y)
+	}
+	dash := ""
+	if dashed {
+		dash = ` stroke-dasharray="6 4"`
+	}
+	fmt.Fprintf(&cv.buf, `<polyline points="%s" fill="none" stroke="%s" stroke-width="%.1f" stroke-linejoin="round"%s/>`+"\n",
+		strings.Join(coords, " "), svgColor(c), width, dash)
+}
+
+func (cv *svgCanvas) rect(x, y, w, h float64, c color.RGBA) {
+	fmt.Fprintf(&cv.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n", x, y, w, h, svgColor(c))
+}
+
+func (cv *svgCanvas) wedge(cx, cy, r, from, to float64, c color.RGBA) {
+	if to-from >= 2*math.Pi-1e-9 {
+		fmt.Fprintf(&cv.buf, `<circle cx="%.1f" cy="%.1f" r="%.1f" fill="%s"/>`+"\n", cx, cy, r, svgColor(c))
+		return
+	}
+	large := 0
+	if to-from > math.Pi {
+		large = 1
+	}
+	fmt.Fprintf(&cv.buf, `<path d="M%.1f,%.1f L%.1f,%.1f A%.1f,%.1f 0 %d 1 %.1f,%.1f Z" fill="%s" stroke="#ffffff"/>`+"\n",
+		cx, cy, cx+r*math.Sin(from), cy-r*math.Cos(from), r, r, large, cx+r*math.Sin(to), cy-r*math.Cos(to), svgColor(c))
+}
+
+func (cv *svgCanvas) text(x, y float64, s string, size float64, anchor textAnchor, c color.RGBA) {
+	anchors := map[textAnchor]string{anchorStart: "start", anchorMiddle: "middle", anchorEnd: "end"}
+	fmt.Fprintf(&cv.buf, `<text x="%.1f" y="%.1f" font-size="%.0f" text-anchor="%s" dominant-baseline="middle" fill="%s">%s</text>`+"\n",
+		x, y, size, anchors[anchor], svgColor(c), html.EscapeString(s))
+}
+
+// textWidth estimates the width of s from the average width of a sans-serif glyph.
+func (cv *svgCanvas) textWidth(s string, size float64) float64 {
+	return float64(len([]rune(s))) * size * 0.55
+}
+
+func (cv *svgCanvas) bytes() []byte {
+	cv.buf.WriteString("</svg>\n")
+	return cv.buf.Bytes()
+}
+
+// rasterCanvas paints an image for PNG output. Lines are drawn by stamping squares
+// along them and text with the built-in bitmap font, without anti-aliasing.
+type rasterCanvas struct {
+	img *image.RGBA
+}
+
+func newRasterCanvas(w, h int) *rasterCanvas {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	return &rasterCanvas{img: img}
+}
+
+func (cv *rasterCanvas) fill(x0, y0, x1, y1 int, c color.RGBA) {
+	r := image.Rect(x0, y0, x1, y1).Intersect(cv.img.Bounds())
+	draw.Draw(cv.img, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+func (cv *rasterCanvas) polyline(points []point, c color.RGBA, width float64, dashed bool) {
+	half := max(width/2, 0.5)
+	var travelled float64
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		length := math.Hypot(b.x-a.x, b.y-a.y)
+		for step := 0.0; step <= length; step += 0.5 {
+			// Dashes of 6 pixels with gaps of 4, like the SVG
+			if dashed && math.Mod(travelled+step, 10) >= 6 {
+				continue
+			}
+			t := 0.0
+			if length > 0 {
+				t = step / length
+			}
+			x, y := a.x+(b.x-a.x)*t, a.y+(b.y-a.y)*t
+			cv.fill(int(math.Round(x-half)), int(math.Round(y-half)), int(math.Round(x+half)), int(math.Round(y+half)), c)
+		}
+		travelled += length
+	}
+}
+
+func (cv *rasterCanvas) rect(x, y, w, h float64, c color.RGBA) {
+	cv.fill(int(math.Round(x)), int(math.Round(y)), int(math.Round(x+w)), int(math.Round(y+h)), c)
+}
+
+func (cv *rasterCanvas) wedge(cx, cy, r, from, to float64, c color.RGBA) {
+	for py := int(cy - r); py <= int(cy+r); py++ {
+		for px := int(cx - r); px <= int(cx+r); px++ {
+			dx, dy := float64(px)+0.5-cx, float64(py)+0.5-cy
+			if dx*dx+dy*dy > r*r {
+				continue
+			}
+			angle := math.Atan2(dx, -dy) // Clockwise from 12 o'clock
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			if angle >= from && angle < to {
+				cv.img.SetRGBA(px, py, c)
+			}
+		}
+	}
+}
+
+// glyphScale is how many pixels each dot of the bitmap font takes at size.
+func glyphScale(size float64) int {
+	return max(1, int(size/6))
+}
+
+func (cv *rasterCanvas) text(x, y float64, s string, size float64, anchor textAnchor, c color.RGBA) {
+	scale := glyphScale(size)
+	switch anchor {
+	case anchorMiddle:
+		x -= cv.textWidth(s, size) / 2
+	case anchorEnd:
+		x -= cv.textWidth(s, size)
+	}
+	top := int(math.Round(y)) - 7*scale/2
+	left := int(math.Round(x))
+	for _, r := range s {
+		rows, ok := glyphs[unicode.ToUpper(r)]
+		if !ok {
+			rows = glyphs['?']
+		}
+		for row, bits := range rows {
+			for col := 0; col < 5; col++ {
+				if bits&(0x10>>col) != 0 {
+					px, py := left+col*scale, top+row*scale
+					cv.fill(px, py, px+scale, py+scale, c)
+				}
+			}
+		}
+		left += 6 * scale
+	}
+}
+
+func (cv *rasterCanvas) textWidth(s string, size float64) float64 {
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return float64((6*n - 1) * glyphScale(size))
+}
+
+func (cv *rasterCanvas) png() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, cv.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// glyphs is a 5x7 bitmap font: one byte per row, top to bottom, with the leftmost dot
+// in bit 4. Lower case letters are drawn as capitals, and anything else as '?'.
+var glyphs = map[rune][7]byte{
+	' ':  {},
+	'0':  {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1':  {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3':  {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4':  {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5':  {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6':  {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8':  {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9':  {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	'A':  {0x0E, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'B':  {0x1E, 0x11, 0x11, 0x1E, 0x11, 0x11, 0x1E},
+	'C':  {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'D':  {0x1C, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1C},
+	'E':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'F':  {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x10},
+	'G':  {0x0E, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0F},
+	'H':  {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'I':  {0x0E, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'J':  {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0C},
+	'K':  {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L':  {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'M':  {0x11, 0x1B, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N':  {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O':  {0x0E, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'P':  {0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10, 0x10},
+	'Q':  {0x0E, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0D},
+	'R':  {0x1E, 0x11, 0x11, 0x1E, 0x14, 0x12, 0x11},
+	'S':  {0x0F, 0x10, 0x10, 0x0E, 0x01, 0x01, 0x1E},
+	'T':  {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'V':  {0x11, 0x11, 0x11, 0x11, 0x11, 0x0A, 0x04},
+	'W':  {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0A},
+	'X':  {0x11, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x11},
+	'Y':  {0x11, 0x11, 0x11, 0x0A, 0x04, 0x04, 0x04},
+	'Z':  {0x1F, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1F},
+	'.':  {0, 0, 0, 0, 0, 0x0C, 0x0C},
+	',':  {0, 0, 0, 0, 0x0C, 0x04, 0x08},
+	'-':  {0, 0, 0, 0x1F, 0, 0, 0},
+	'+':  {0, 0x04, 0x04, 0x1F, 0x04, 0x04, 0},
+	'=':  {0, 0, 0x1F, 0, 0x1F, 0, 0},
+	'%':  {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'(':  {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')':  {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	':':  {0, 0x0C, 0x0C, 0, 0x0C, 0x0C, 0},
+	'/':  {0, 0x01, 0x02, 0x04, 0x08, 0x10, 0},
+	'&':  {0x0C, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0D},
+	'\'': {0x0C, 0x04, 0x08, 0, 0, 0, 0},
+	'_':  {0, 0, 0, 0, 0, 0, 0x1F},
+	'?':  {0x0E, 0x11, 0x01, 0x02, 0x04, 0, 0x04},
+	'€':  {0x07, 0x08, 0x1E, 0x08, 0x1E, 0x08, 0x07},
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+var testInk = color.RGBA{0x12, 0x34, 0x56, 255}
+
+func TestSVGCanvas(t *testing.T) {
+	tests := []struct {
+		name string
+		draw func(cv *svgCanvas)
+		want string
+	}{
+		{"solid line", func(cv *svgCanvas) { cv.polyline([]point{{1, 2}, {3.25, 4}}, testInk, 2, false) },
+			`<polyline points="1.0,2.0 3.2,4.0" fill="none" stroke="#123456" stroke-width="2.0" stroke-linejoin="round"/>`},
+		{"dashed line", func(cv *svgCanvas) { cv.polyline([]point{{0, 0}, {10, 0}}, testInk, 1, true) }, `stroke-dasharray="6 4"/>`},
+		{"rect", func(cv *svgCanvas) { cv.rect(1, 2, 3, 4, testInk) }, `<rect x="1.0" y="2.0" width="3.0" height="4.0" fill="#123456"/>`},
+		// A quarter from 12 to 3 o'clock, then three quarters from 3 o'clock back to 12
+		{"small wedge", func(cv *svgCanvas) { cv.wedge(10, 10, 5, 0, math.Pi/2, testInk) }, `d="M10.0,10.0 L10.0,5.0 A5.0,5.0 0 0 1 15.0,10.0 Z"`},
+		{"large wedge", func(cv *svgCanvas) { cv.wedge(10, 10, 5, math.Pi/2, 2*math.Pi, testInk) }, `d="M10.0,10.0 L15.0,10.0 A5.0,5.0 0 1 1 10.0,5.0 Z"`},
+		{"whole circle", func(cv *svgCanvas) { cv.wedge(10, 10, 5, 0, 2*math.Pi, testInk) }, `<circle cx="10.0" cy="10.0" r="5.0" fill="#123456"/>`},
+		{"escaped text", func(cv *svgCanvas) { cv.text(5, 6, "A & <B>", 12, anchorEnd, testInk) },
+			`<text x="5.0" y="6.0" font-size="12" text-anchor="end" dominant-baseline="middle" fill="#123456">A &amp; &lt;B&gt;</text>`},
+	}
+	for _, tt := range tests {
+		cv := newSVGCanvas(20, 20)
+		tt.draw(cv)
+		if got := string(cv.bytes()); !strings.Contains(got, tt.want) {
+			t.Errorf("%s: got\n%s\nwant it to contain %s", tt.name, got, tt.want)
+		}
+	}
+
+	if got := (&svgCanvas{}).textWidth("Naïve", 10); math.Abs(got-5*10*0.55) > 1e-9 {
+		t.Errorf("textWidth counts %v, want 5 characters", got)
+	}
+}
+
+func TestRasterTextWidth(t *testing.T) {
+	tests := []struct {
+		s          string
+		size       float64
+		wantScale  int
+		wantPixels float64
+	}{
+		{"", 12, 2, 0},
+		{"A", 6, 1, 5},
+		{"AB", 6, 1, 11}, // A dot of space between the glyphs
+		{"AB", 12, 2, 22},
+		{"ab", 16, 2, 22},
+		{"ab", 30, 5, 55},
+		{"A", 3, 1, 5}, // Never smaller than one pixel a dot
+	}
+	cv := &rasterCanvas{}
+	for _, tt := range tests {
+		if got := glyphScale(tt.size); got != tt.wantScale {
+			t.Errorf("glyphScale(%v) = %d, want %d", tt.size, got, tt.wantScale)
+		}
+		if got := cv.textWidth(tt.s, tt.size); got != tt.wantPixels {
+			t.Errorf("textWidth(%q, %v) = %v, want %v", tt.s, tt.size, got, tt.wantPixels)
+		}
+	}
+}
+
+func TestRasterCanvas(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	type pixel struct {
+		x, y  int
+		inked bool
+	}
+	tests := []struct {
+		name   string
+		draw   func(cv *rasterCanvas)
+		pixels []pixel
+	}{
+		{"solid line", func(cv *rasterCanvas) { cv.polyline([]point{{0, 5}, {19, 5}}, testInk, 1, false) },
+			[]pixel{{0, 5, true}, {8, 5, true}, {19, 5, true}, {8, 4, false}, {8, 6, false}}},
+		// Dashes of 6 pixels with gaps of 4
+		{"dashed line", func(cv *rasterCanvas) { cv.polyline([]point{{0, 5}, {19, 5}}, testInk, 1, true) },
+			[]pixel{{2, 5, true}, {8, 5, false}, {12, 5, true}, {18, 5, false}}},
+		{"rect", func(cv *rasterCanvas) { cv.rect(2, 3, 4, 5, testInk) },
+			[]pixel{{2, 3, true}, {5, 7, true}, {6, 7, false}, {5, 8, false}, {1, 3, false}}},
+		// From 12 to 3 o'clock is the top right quarter
+		{"wedge", func(cv *rasterCanvas) { cv.wedge(10, 10, 8, 0, math.Pi/2, testInk) },
+			[]pixel{{14, 6, true}, {6, 6, false}, {14, 14, false}, {6, 14, false}, {19, 0, false}}},
+		{"whole circle", func(cv *rasterCanvas) { cv.wedge(10, 10, 8, 0, 2*math.Pi, testInk) },
+			[]pixel{{14, 6, true}, {6, 6, true}, {14, 14, true}, {6, 14, true}, {10, 10, true}, {0, 0, false}}},
+		// "I" is a bar down the middle of the glyph, 3 dots wide at its ends. Anchored at
+		// its end, its 5 columns are the 5 pixels before x, and it is centred on y.
+		{"text", func(cv *rasterCanvas) { cv.text(15, 10, "I", 6, anchorEnd, testInk) },
+			[]pixel{{12, 10, true}, {11, 7, true}, {13, 13, true}, {10, 7, false}, {14, 7, false}, {11, 10, false}, {12, 6, false}, {12, 14, false}}},
+		// Lines running off the image are clipped
+		{"off the image", func(cv *rasterCanvas) { cv.polyline([]point{{-10, -10}, {30, 30}}, testInk, 1, false) },
+			[]pixel{{0, 0, true}, {19, 19, true}, {0, 19, false}}},
+	}
+	for _, tt := range tests {
+		cv := newRasterCanvas(20, 20)
+		tt.draw(cv)
+		for _, p := range tt.pixels {
+			want := white
+			if p.inked {
+				want = testInk
+			}
+			if got := cv.img.RGBAAt(p.x, p.y); got != want {
+				t.Errorf("%s: pixel (%d, %d) is %v, want %v", tt.name, p.x, p.y, got, want)
+			}
+		}
+	}
+}
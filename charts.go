@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"image/color"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Chart images are rendered on the server, as SVG or PNG, so they can be put in emails
+// and viewed without JavaScript or an internet connection.
+
+// chartPalette colours the series of a chart in turn.
+var chartPalette = []color.RGBA{
+	{0x4e, 0x79, 0xa7, 255}, {0xf2, 0x8e, 0x2c, 255}, {0x59, 0xa1, 0x4f, 255}, {0xed, 0xc9, 0x48, 255},
+	{0xb0, 0x7a, 0xa1, 255}, {0xff, 0x9d, 0xa7, 255}, {0x9c, 0x75, 0x5f, 255}, {0xba, 0xb0, 0xac, 255},
+	{0xaf, 0x7a, 0xa1, 255}, {0x86, 0xbc, 0xb6, 255},
+}
+
+var (
+	targetColor    = color.RGBA{0xe1, 0x57, 0x59, 255}
+	actualColor    = color.RGBA{0x76, 0xb7, 0xb2, 255}
+	benchmarkColor = color.RGBA{0x79, 0x70, 0x6e, 255}
+	inkColor       = color.RGBA{0x33, 0x33, 0x33, 255}
+	gridColor      = color.RGBA{0xe5, 0xe5, 0xe5, 255}
+)
+
+type chart interface {
+	size() (w, h int)
+	draw(cv canvas)
+}
+
+// chartSeries is one line of a lineChart.
+type chartSeries struct {
+	name   string
+	values []float64 // One per date, NaN leaves a gap
+	color  color.RGBA
+	dashed bool
+}
+
+// lineChart plots series over dates, like the value of the strategies over time.
+type lineChart struct {
+	title   string
+	dates   []time.Time
+	series  []chartSeries
+	percent bool // The values are fractions, shown as percentages
+}
+
+func (lc lineChart) size() (int, int) { return 900, 450 }
+
+// niceStep rounds a tick interval to 1, 2 or 5 times a power of ten.
+func niceStep(span float64, ticks int) float64 {
+	raw := span / float64(ticks)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// axisLabel formats a tick of the y axis, in thousands or millions when the ticks are.
+func (lc lineChart) axisLabel(v, step float64) string {
+	switch {
+	case lc.percent && step < 0.01:
+		return fmt.Sprintf("%.1f%%", v*100)
+	case lc.percent:
+		return fmt.Sprintf("%.0f%%", v*100)
+	case step >= 1e6:
+		return fmt.Sprintf("%.0fM", v/1e6)
+	case step >= 1e5:
+		return fmt.Sprintf("%.1fM", v/1e6)
+	case step >= 1e3:
+		return fmt.Sprintf("%.0fk", v/1e3)
+	case step >= 100:
+		return fmt.Sprintf("%.1fk", v/1e3)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+func (lc lineChart) draw(cv canvas) {
+	w, h := lc.size()
+	width, height := float64(w), float64(h)
+	cv.text(width/2, 20, lc.title, 16, anchorMiddle, inkColor)
+
+	// The legend goes underneath, wrapping onto as many rows as it needs
+	type legendItem struct {
+		series chartSeries
+		x, y   float64
+	}
+	var legend []legendItem
+	x, row := 20.0, 0
+	for _, s := range lc.series {
+		itemWidth := 20 + cv.textWidth(s.name, 12) + 20
+		if x+itemWidth > width-20 && x > 20 {
+			x, row = 20, row+1
+		}
+		legend = append(legend, legendItem{series: s, x: x, y: float64(row)})
+		x += itemWidth
+	}
+	legendTop := height - 10 - float64(row+1)*20
+	for _, item := range legend {
+		y := legendTop + item.y*20 + 10
+		cv.polyline([]point{{item.x, y}, {item.x + 14, y}}, item.series.color, 3, item.series.dashed)
+		cv.text(item.x+20, y, item.series.name, 12, anchorStart, inkColor)
+	}
+
+	left, right, top, bottom := 80.0, width-20, 45.0, legendTop-40
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range lc.series {
+		for _, v := range s.values {
+			if !math.IsNaN(v) {
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+			}
+		}
+	}
+	if len(lc.dates) == 0 || math.IsInf(lo, 1) {
+		cv.text(width/2, (top+bottom)/2, "Not enough history for a chart yet", 14, anchorMiddle, inkColor)
+		return
+	}
+	if hi-lo < 1e-9 {
+		pad := math.Max(math.Abs(hi)*0.1, 0.01)
+		lo, hi = lo-pad, hi+pad
+	}
+
+	// Horizontal grid lines at round values
+	step := niceStep(hi-lo, 5)
+	lo, hi = math.Floor(lo/step)*step, math.Ceil(hi/step)*step
+	yOf := func(v float64) float64 { return bottom - (v-lo)/(hi-lo)*(bottom-top) }
+	for v := lo; v <= hi+step/2; v += step {
+		if math.Abs(v) < step/1e6 {
+			v = 0 // Not -0%
+		}
+		y := yOf(v)
+		cv.polyline([]point{{left, y}, {right, y}}, gridColor, 1, false)
+		cv.text(left-8, y, lc.axisLabel(v, step), 12, anchorEnd, inkColor)
+	}
+
+	// Dates along the bottom
+	first, last := lc.dates[0], lc.dates[len(lc.dates)-1]
+	span := last.Sub(first)
+	xOf := func(d time.Time) float64 {
+		if span <= 0 {
+			return (left + right) / 2
+		}
+		return left + float64(d.Sub(first))/float64(span)*(right-left)
+	}
+	layout := "Jan 2006"
+	if span < 180*24*time.Hour {
+		layout = "Jan 02"
+	}
+	labels := min(6, len(lc.dates))
+	for i := 0; i < labels; i++ {
+		d := lc.dates[0]
+		if labels > 1 {
+			d = lc.dates[i*(len(lc.dates)-1)/(labels-1)]
+		}
+		x := xOf(d)
+		cv.polyline([]point{{x, bottom}, {x, bottom + 5}}, inkColor, 1, false)
+		// Keep the labels at the ends inside the image
+		label := d.Format(layout)
+		half := cv.textWidth(label, 12) / 2
+		cv.text(math.Min(math.Max(x, half+5), width-half-5), bottom+16, label, 12, anchorMiddle, inkColor)
+	}
+	cv.polyline([]point{{left, top}, {left, bottom}, {right, bottom}}, inkColor, 1, false)
+
+	// A line for each run of values without gaps
+	for _, s := range lc.series {
+		var run []point
+		for i, v := range s.values {
+			if math.IsNaN(v) {
+				if len(run) > 0 {
+					cv.polyline(run, s.color, 2, s.dashed)
+				}
+				run = nil
+				continue
+			}
+			run = append(run, point{xOf(lc.dates[i]), yOf(v)})
+		}
+		if len(run) > 0 {
+			cv.polyline(run, s.color, 2, s.dashed)
+		}
+	}
+}
+
+// pieSlice is one share of a pieChart.
+type pieSlice struct {
+	label string
+	value float64
+}
+
+// pieChart shows how a total splits, like the value of the holdings.
+type pieChart struct {
+	title  string
+	slices []pieSlice
+}
+
+func (pc pieChart) size() (int, int) { return 700, 400 }
+
+// maxPieSlices is how many slices a pie has at most; the smallest are grouped as Other.
+const maxPieSlices = 10
+
+func (pc pieChart) draw(cv canvas) {
+	w, h := pc.size()
+	cv.text(float64(w)/2, 20, pc.title, 16, anchorMiddle, inkColor)
+
+	slices := make([]pieSlice, 0, len(pc.slices))
+	var total float64
+	for _, s := range pc.slices {
+		if s.value > 0 {
+			slices = append(slices, s)
+			total += s.value
+		}
+	}
+	if total <= 0 {
+		cv.text(float64(w)/2, float64(h)/2, "Nothing held yet", 14, anchorMiddle, inkColor)
+		return
+	}
+	sort.SliceStable(slices, func(i, j int) bool { return slices[i].value > slices[j].value })
+	if len(slices) > maxPieSlices {
+		other := pieSlice{label: "Other"}
+		for _, s := range slices[maxPieSlices-1:] {
+			other.value += s.value
+		}
+		slices = append(slices[:maxPieSlices-1], other)
+	}
+
+	r := (float64(h) - 80) / 2
+	cx, cy := 40+r, 45+r
+	angle := 0.0
+	for i, s := range slices {
+		sweep := s.value / total * 2 * math.Pi
+		c := chartPalette[i%len(chartPalette)]
+		cv.wedge(cx, cy, r, angle, angle+sweep, c)
+		angle += sweep
+
+		y := 60 + float64(i)*24
+		cv.rect(cx+r+40, y-7, 14, 14, c)
+		cv.text(cx+r+62, y, fmt.Sprintf("%s  %.1f%%", s.label, s.value/total*100), 12, anchorStart, inkColor)
+	}
+}
+
+// renderSVG draws ch as an SVG document.
+func renderSVG(ch chart) []byte {
+	cv := newSVGCanvas(ch.size())
+	ch.draw(cv)
+	return cv.bytes()
+}
+
+// renderPNG draws ch as a PNG image.
+func renderPNG(ch chart) ([]byte, error) {
+	cv := newRasterCanvas(ch.size())
+	ch.draw(cv)
+	return cv.png()
+}
+
+// historyCharts plots the value of every strategy over the range, with the real
+// portfolio, the value averaging target and the benchmark bought with the primary
+// strategy's contributions, and how far each strategy and the real portfolio fell
+// from their highs.
+func historyCharts(ctx context.Context, r historyRange) (equity, drawdown lineChart, err error) {
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return equity, drawdown, err
+	}
+	h, err := buildPortfolioHistory(ctx, historyOptions{historyRange: r, strategies: settings.allStrategies(), actual: true, benchmark: settings.Benchmark})
+	if err != nil {
+		return equity, drawdown, err
+	}
+
+	equity = lineChart{title: "Portfolio Value by Strategy", dates: h.dates}
+	drawdown = lineChart{title: "Drawdown from the Previous High", dates: h.dates, percent: true}
+	values := func(series []valuation) []float64 {
+		out := make([]float64, len(series))
+		for i, v := range series {
+			out[i] = v.Value
+		}
+		return out
+	}
+	falls := func(series []valuation) []float64 {
+		out := make([]float64, len(series))
+		growth, peak := 1.0, 1.0
+		for i, r := range periodReturns(series) {
+			if math.IsNaN(r) {
+				out[i] = math.NaN()
+				continue
+			}
+			growth *= 1 + r
+			peak = math.Max(peak, growth)
+			out[i] = growth/peak - 1
+		}
+		return out
+	}
+	for i, s := range h.strategies {
+		c := chartPalette[i%len(chartPalette)]
+		equity.series = append(equity.series, chartSeries{name: s.Name, values: values(h.series[s.Key]), color: c})
+		drawdown.series = append(drawdown.series, chartSeries{name: s.Name, values: falls(h.series[s.Key]), color: c})
+	}
+	if va := settings.ValueAveraging; va.Enabled {
+		target := make([]float64, len(h.dates))
+		for i, d := range h.dates {
+			target[i] = math.NaN()
+			if v, ok := va.target(d); ok {
+				target[i] = v
+			}
+		}
+		equity.series = append(equity.series, chartSeries{name: "Value Averaging Target", values: target, color: targetColor, dashed: true})
+	}
+	if h.actual != nil {
+		equity.series = append(equity.series, chartSeries{name: "Actual Portfolio", values: values(h.actual), color: actualColor})
+		drawdown.series = append(drawdown.series, chartSeries{name: "Actual Portfolio", values: falls(h.actual), color: actualColor})
+	}
+	if bench := h.benchmarkFor(h.series[primaryStrategyKey], h.flows[primaryStrategyKey]); bench != nil {
+		equity.series = append(equity.series, chartSeries{name: "Benchmark " + h.benchmark, values: values(bench), color: benchmarkColor, dashed: true})
+	}
+	return equity, drawdown, nil
+}
+
+// allocationChart splits the value of the holdings at their last analysed prices by ticker.
+func allocationChart(ctx context.Context) (pieChart, error) {
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return pieChart{}, err
+	}
+	pc := pieChart{title: "Allocation by Holding"}
+	for _, s := range stocks {
+		pc.slices = append(pc.slices, pieSlice{label: s.Ticker, value: marketValue(s)})
+	}
+	return pc, nil
+}
+
+// handleChartImage serves a chart as an image, e.g. /charts/equity.svg or
+// /charts/allocation.png. The equity and drawdown charts take the range of the history.
+func handleChartImage(c *gin.Context) {
+	ctx := c.Request.Context()
+	file := c.Param("file")
+	format := strings.TrimPrefix(path.Ext(file), ".")
+	if format != "svg" && format != "png" {
+		c.String(http.StatusNotFound, "Charts are available as .svg or .png")
+		return
+	}
+
+	var ch chart
+	switch name := strings.TrimSuffix(file, path.Ext(file)); name {
+	case "equity", "drawdown":
+		r, err := parseHistoryRange(c.Query("start"), c.Query("end"), c.Query("resolution"))
+		if err != nil {
+			c.String(formErrorStatus(err), "Failed to draw the chart: %v", err)
+			return
+		}
+		equity, drawdown, err := historyCharts(ctx, r)
+		if err != nil {
+			log.Printf("Failed to build the %s chart: %v", name, err)
+			c.String(formErrorStatus(err), "Failed to draw the chart: %v", err)
+			return
+		}
+		ch = equity
+		if name == "drawdown" {
+			ch = drawdown
+		}
+	case "allocation":
+		pc, err := allocationChart(ctx)
+		if err != nil {
+			log.Printf("Failed to build the allocation chart: %v", err)
+			c.String(formErrorStatus(err), "Failed to draw the chart: %v", err)
+			return
+		}
+		ch = pc
+	default:
+		c.String(http.StatusNotFound, "Unknown chart %q", name)
+		return
+	}
+
+	if format == "svg" {
+		c.Data(http.StatusOK, "image/svg+xml", renderSVG(ch))
+		return
+	}
+	img, err := renderPNG(ch)
+	if err != nil {
+		log.Printf("Failed to encode the chart: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to draw the chart")
+		return
+	}
+	c.Data(http.StatusOK, "image/png", img)
+}
+
+// showChartPage renders the charts into the page as SVG, so it needs no scripts.
+func showChartPage(c *gin.Context) {
+	ctx := c.Request.Context()
+	r, err := parseHistoryRange(c.Query("start"), c.Query("end"), c.Query("resolution"))
+	if err != nil {
+		c.String(formErrorStatus(err), "Failed to draw the charts: %v", err)
+		return
+	}
+	equity, drawdown, err := historyCharts(ctx, r)
+	if err != nil {
+		log.Printf("Failed to build the history charts: %v", err)
+		c.String(formErrorStatus(err), "Failed to draw the charts: %v", err)
+		return
+	}
+	allocation, err := allocationChart(ctx)
+	if err != nil {
+		log.Printf("Failed to build the allocation chart: %v", err)
+		c.String(formErrorStatus(err), "Failed to draw the charts: %v", err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "chart.tmpl.html", gin.H{
+		"equity":     template.HTML(renderSVG(equity)),
+		"drawdown":   template.HTML(renderSVG(drawdown)),
+		"allocation": template.HTML(renderSVG(allocation)),
+		"start":      c.Query("start"),
+		"end":        c.Query("end"),
+		"resolution": r.resolution,
+	})
+}
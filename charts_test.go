@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingCanvas keeps what a chart draws instead of drawing it.
+type recordingCanvas struct {
+	lines  [][]point
+	rects  int
+	wedges [][2]float64 // From and to angles
+	texts  []string
+}
+
+func (cv *recordingCanvas) polyline(points []point, c color.RGBA, width float64, dashed bool) {
+	cv.lines = append(cv.lines, points)
+}
+func (cv *recordingCanvas) rect(x, y, w, h float64, c color.RGBA) { cv.rects++ }
+func (cv *recordingCanvas) wedge(cx, cy, r, from, to float64, c color.RGBA) {
+	cv.wedges = append(cv.wedges, [2]float64{from, to})
+}
+func (cv *recordingCanvas) text(x, y float64, s string, size float64, anchor textAnchor, c color.RGBA) {
+	cv.texts = append(cv.texts, s)
+}
+func (cv *recordingCanvas) textWidth(s string, size float64) float64 { return float64(len(s)) * 6 }
+
+func (cv *recordingCanvas) hasText(s string) bool {
+	for _, t := range cv.texts {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNiceStep(t *testing.T) {
+	tests := []struct {
+		span  float64
+		ticks int
+		want  float64
+	}{
+		{100, 5, 20},
+		{1, 5, 0.2},
+		{7, 5, 2},   // 1.4 rounds up to 2
+		{18, 5, 5},  // 3.6 rounds up to 5
+		{30, 5, 10}, // 6 rounds up to the next power of ten
+		{0.03, 5, 0.01},
+		{2.5e6, 5, 5e5},
+	}
+	for _, tt := range tests {
+		if got := niceStep(tt.span, tt.ticks); math.Abs(got-tt.want) > tt.want*1e-9 {
+			t.Errorf("niceStep(%v, %d) = %v, want %v", tt.span, tt.ticks, got, tt.want)
+		}
+	}
+}
+
+func TestAxisLabel(t *testing.T) {
+	tests := []struct {
+		percent bool
+		v, step float64
+		want    string
+	}{
+		{false, 40, 10, "40"},
+		{false, 1500, 500, "1.5k"},
+		{false, 20000, 5000, "20k"},
+		{false, 1.5e6, 5e5, "1.5M"},
+		{false, 4e6, 1e6, "4M"},
+		{true, 0.25, 0.05, "25%"},
+		{true, -0.025, 0.005, "-2.5%"},
+	}
+	for _, tt := range tests {
+		if got := (lineChart{percent: tt.percent}).axisLabel(tt.v, tt.step); got != tt.want {
+			t.Errorf("axisLabel(%v, %v), percent %v = %q, want %q", tt.v, tt.step, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestLineChartDraw(t *testing.T) {
+	dates := []time.Time{day("2024-01-05"), day("2024-01-12"), day("2024-01-19"), day("2024-01-26")}
+	nan := math.NaN()
+	tests := []struct {
+		name    string
+		chart   lineChart
+		runs    []int // Points in each line of the series, in order
+		message bool
+	}{
+		{"one series", lineChart{dates: dates, series: []chartSeries{{name: "A", values: []float64{1, 2, 3, 4}}}}, []int{4}, false},
+		{"a gap splits the line", lineChart{dates: dates, series: []chartSeries{{name: "A", values: []float64{1, nan, 3, 4}}}}, []int{1, 2}, false},
+		{"two series", lineChart{dates: dates, series: []chartSeries{
+			{name: "A", values: []float64{1, 2, 3, 4}},
+			{name: "B", values: []float64{nan, nan, 3, 4}},
+		}}, []int{4, 2}, false},
+		{"a flat series", lineChart{dates: dates, series: []chartSeries{{name: "A", values: []float64{5, 5, 5, 5}}}}, []int{4}, false},
+		{"no values", lineChart{dates: dates, series: []chartSeries{{name: "A", values: []float64{nan, nan, nan, nan}}}}, nil, true},
+		{"no dates", lineChart{series: []chartSeries{{name: "A"}}}, nil, true},
+	}
+	for _, tt := range tests {
+		cv := &recordingCanvas{}
+		tt.chart.draw(cv)
+		if got := cv.hasText("Not enough history for a chart yet"); got != tt.message {
+			t.Errorf("%s: shows the no history message: %v, want %v", tt.name, got, tt.message)
+		}
+		if !cv.hasText("A") {
+			t.Errorf("%s: no legend for the series", tt.name)
+		}
+		// The series are drawn last, after the legend, the grid, the ticks and the axes
+		lines := cv.lines[len(cv.lines)-len(tt.runs):]
+		if tt.runs == nil {
+			lines = nil
+		}
+		for i, want := range tt.runs {
+			if len(lines[i]) != want {
+				t.Errorf("%s: line %d has %d points, want %d", tt.name, i, len(lines[i]), want)
+			}
+		}
+	}
+
+	// The grid is at round values around the data, and the ends of the series at the
+	// edges of the plot
+	cv := &recordingCanvas{}
+	lineChart{dates: dates, series: []chartSeries{{name: "A", values: []float64{103, 110, 121, 137}}}}.draw(cv)
+	for _, label := range []string{"100", "110", "120", "130", "140"} {
+		if !cv.hasText(label) {
+			t.Errorf("no grid label %s in %v", label, cv.texts)
+		}
+	}
+	line := cv.lines[len(cv.lines)-1]
+	if line[0].x != 80 || line[3].x != 880 || line[0].y <= line[3].y {
+		t.Errorf("series drawn from %v to %v, want from the left to the right edge, rising", line[0], line[3])
+	}
+}
+
+func TestPieChartDraw(t *testing.T) {
+	var many []pieSlice
+	for i := range 12 {
+		many = append(many, pieSlice{label: string(rune('A' + i)), value: float64(12 - i)})
+	}
+	tests := []struct {
+		name   string
+		slices []pieSlice
+		labels []string // In the order drawn, empty for the nothing held message
+	}{
+		{"largest first", []pieSlice{{"X", 25}, {"Y", 75}}, []string{"Y  75.0%", "X  25.0%"}},
+		{"empty slices left out", []pieSlice{{"X", 50}, {"Y", 0}, {"Z", -5}}, []string{"X  100.0%"}},
+		// The 3 smallest of 12 (3, 2 and 1 out of 78) are grouped as Other
+		{"smallest grouped", many, []string{"A  15.4%", "B  14.1%", "C  12.8%", "D  11.5%", "E  10.3%", "F  9.0%", "G  7.7%", "H  6.4%", "I  5.1%", "Other  7.7%"}},
+		{"nothing held", []pieSlice{{"X", 0}}, nil},
+	}
+	for _, tt := range tests {
+		cv := &recordingCanvas{}
+		pieChart{title: "Allocation", slices: tt.slices}.draw(cv)
+		if tt.labels == nil {
+			if !cv.hasText("Nothing held yet") || len(cv.wedges) != 0 {
+				t.Errorf("%s: drew %d wedges, want the nothing held message", tt.name, len(cv.wedges))
+			}
+			continue
+		}
+		if got := cv.texts[1:]; strings.Join(got, "|") != strings.Join(tt.labels, "|") {
+			t.Errorf("%s: labels %q, want %q", tt.name, got, tt.labels)
+		}
+		if len(cv.wedges) != len(tt.labels) || cv.rects != len(tt.labels) {
+			t.Errorf("%s: %d wedges and %d legend boxes, want %d", tt.name, len(cv.wedges), cv.rects, len(tt.labels))
+			continue
+		}
+		// The wedges go round the whole circle without gaps
+		for i, w := range cv.wedges {
+			if i > 0 && w[0] != cv.wedges[i-1][1] {
+				t.Errorf("%s: wedge %d starts at %v, the last ended at %v", tt.name, i, w[0], cv.wedges[i-1][1])
+			}
+		}
+		if first, last := cv.wedges[0][0], cv.wedges[len(cv.wedges)-1][1]; first != 0 || math.Abs(last-2*math.Pi) > 1e-9 {
+			t.Errorf("%s: wedges from %v to %v, want the full circle", tt.name, first, last)
+		}
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	charts := []struct {
+		name  string
+		chart chart
+	}{
+		{"line", lineChart{title: "Equity", dates: []time.Time{day("2024-01-05"), day("2024-01-12")}, series: []chartSeries{{name: "A & B", values: []float64{1, 2}}}}},
+		{"pie", pieChart{title: "Allocation", slices: []pieSlice{{"X", 1}, {"Y", 2}}}},
+	}
+	for _, tt := range charts {
+		svg := string(renderSVG(tt.chart))
+		if !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>\n") {
+			t.Errorf("%s: SVG is not a single svg element: %.80q", tt.name, svg)
+		}
+
+		data, err := renderPNG(tt.chart)
+		if err != nil {
+			t.Fatalf("%s: renderPNG: %v", tt.name, err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: the PNG does not decode: %v", tt.name, err)
+		}
+		w, h := tt.chart.size()
+		if b := img.Bounds(); b.Dx() != w || b.Dy() != h {
+			t.Errorf("%s: PNG is %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), w, h)
+		}
+	}
+}
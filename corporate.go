@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Corporate actions are the splits and dividends of the holdings. They are fetched
+// from FMP or entered by hand and wait in the corporate_actions collection until they
+// are reviewed: applying a split multiplies the shares held and divides the average
+// price, applying a dividend reinvests it in the holding (with DRIP on) or adds it to
+// the budget of the next allocation. Applied dividends are the holding's dividend
+// records. Dismissed actions are kept so fetching again doesn't bring them back.
+//
+// Logs are never rewritten. They keep the shares and price of the day they were
+// written, and the applied splits give the factor that makes them comparable with
+// today's (split-adjusted) prices.
+
+// corporateActionsCollection holds one document per action, keyed by ticker, type and date.
+const corporateActionsCollection = "corporate_actions"
+
+// Types and review states of corporate actions.
+const (
+	actionSplit    = "split"
+	actionDividend = "dividend"
+
+	actionPending   = "pending"
+	actionApplied   = "applied"
+	actionDismissed = "dismissed"
+)
+
+// corporateActionLookback is how many days back the provider is asked for actions.
+// Older ones would have been found by an earlier fetch, or predate the holdings.
+const corporateActionLookback = 90
+
+// CorporateAction is a split or dividend of a holding.
+type CorporateAction struct {
+	ID        string    `firestore:"-" json:"id"`
+	Ticker    string    `firestore:"ticker" json:"ticker"`
+	Type      string    `firestore:"type" json:"type"`                           // "split" or "dividend"
+	Date      string    `firestore:"date" json:"date"`                           // Ex-date, YYYY-MM-DD
+	Ratio     float64   `firestore:"ratio,omitempty" json:"ratio,omitempty"`     // Splits: shares after per share before, 0.1 for a 1-for-10 reverse split
+	Amount    float64   `firestore:"amount,omitempty" json:"amount,omitempty"`   // Dividends: cash per share
+	PayDate   string    `firestore:"payDate,omitempty" json:"payDate,omitempty"` // Dividends: when the cash is paid, YYYY-MM-DD
+	Source    string    `firestore:"source" json:"source"`                       // "provider" or "manual"
+	Status    string    `firestore:"status" json:"status"`                       // "pending", "applied" or "dismissed"
+	Shares    float64   `firestore:"shares,omitempty" json:"shares,omitempty"`   // Shares held on the ex-date, which it applied to
+	TaxRate   float64   `firestore:"taxRate,omitempty" json:"taxRate,omitempty"` // Dividends: withholding tax of the holding when it was applied
+	Net       float64   `firestore:"net,omitempty" json:"net,omitempty"`         // Dividends: cash received after withholding
+	Bought    float64   `firestore:"bought,omitempty" json:"bought,omitempty"`   // Dividends: shares reinvested in under DRIP
+	Cash      float64   `firestore:"cash,omitempty" json:"cash,omitempty"`       // Dividends: cash added to the budget
+	Found     time.Time `firestore:"found" json:"found"`
+	AppliedAt time.Time `firestore:"appliedAt,omitempty" json:"appliedAt,omitempty"` // When it was applied or dismissed
+}
+
+// CorporateActionRequest is the body accepted by POST /api/v1/corporate-actions.
+type CorporateActionRequest struct {
+	Ticker string  `json:"ticker" openapi:"required,minLength=1"`
+	Type   string  `json:"type" openapi:"required,enum=split|dividend"`
+	Date   string  `json:"date" openapi:"required,format=date"` // Ex-date
+	Ratio  float64 `json:"ratio" openapi:"min=0"`               // Splits: 4 for a 4-for-1 split
+	Amount float64 `json:"amount" openapi:"min=0"`              // Dividends: cash per share
+	// Dividends: payment date, defaults to the ex-date
+	PayDate string `json:"payDate"`
+}
+
+// CorporateActionFetch summarises a fetch from the provider.
+type CorporateActionFetch struct {
+	Found  []CorporateAction `json:"found"` // Actions that were not known yet
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+func corporateActionDoc(id string) *firestore.DocumentRef {
+	return firestoreClient.Collection(corporateActionsCollection).Doc(id)
+}
+
+// key is the document ID of the action, so the same action found twice is stored once.
+func (a CorporateAction) key() string {
+	return a.Ticker + "_" + a.Type + "_" + a.Date
+}
+
+func (a CorporateAction) validate() error {
+	if strings.TrimSpace(a.Ticker) == "" {
+		return fmt.Errorf("%w: ticker is required", errInvalidInput)
+	}
+	if _, err := time.Parse("2006-01-02", a.Date); err != nil {
+		return fmt.Errorf("%w: date must be a date in YYYY-MM-DD format", errInvalidInput)
+	}
+	switch a.Type {
+	case actionSplit:
+		if !(a.Ratio > 0) || math.IsInf(a.Ratio, 0) {
+			return fmt.Errorf("%w: a split needs a positive ratio", errInvalidInput)
+		}
+	case actionDividend:
+		if !(a.Amount > 0) || math.IsInf(a.Amount, 0) {
+			return fmt.Errorf("%w: a dividend needs a positive amount per share", errInvalidInput)
+		}
+		if a.PayDate != "" {
+			if _, err := time.Parse("2006-01-02", a.PayDate); err != nil || a.PayDate < a.Date {
+				return fmt.Errorf("%w: the payment date must be a date in YYYY-MM-DD format, not before the ex-date", errInvalidInput)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: type must be split or dividend", errInvalidInput)
+	}
+	return nil
+}
+
+// CorporateActionFilter selects corporate actions. A zero Limit returns every match.
+type CorporateActionFilter struct {
+	Status string
+	Limit  int
+	Offset int
+}
+
+// CorporateActionPage is a single page of results from GET /api/v1/corporate-actions.
+type CorporateActionPage struct {
+	Total  int               `json:"total"`
+	Limit  int               `json:"limit"`
+	Offset int               `json:"offset"`
+	Items  []CorporateAction `json:"items"`
+}
+
+// listCorporateActions returns the actions in the given state, or all of them if it
+// is empty, newest first.
+func listCorporateActions(ctx context.Context, state string) ([]CorporateAction, error) {
+	actions, _, err := queryCorporateActions(ctx, CorporateActionFilter{Status: state})
+	return actions, err
+}
+
+// queryCorporateActions returns the actions matching filter, newest first, together
+// with the total number of matches before pagination. The status filter and the page
+// are evaluated by Firestore.
+func queryCorporateActions(ctx context.Context, filter CorporateActionFilter) ([]CorporateAction, int, error) {
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, 0, fmt.Errorf("%w: limit and offset must not be negative", errInvalidInput)
+	}
+	q := firestoreClient.Collection(corporateActionsCollection).Query
+	if filter.Status != "" {
+		q = q.Where("status", "==", filter.Status)
+	}
+	q = q.OrderBy("date", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Asc)
+
+	total := 0
+	if filter.Limit > 0 || filter.Offset > 0 {
+		count, err := countDocuments(ctx, q)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count corporate actions: %w", err)
+		}
+		total = count
+		q = q.Offset(filter.Offset)
+		if filter.Limit > 0 {
+			q = q.Limit(filter.Limit)
+		}
+	}
+
+	actions := []CorporateAction{}
+	iter := q.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to iterate corporate actions: %w", err)
+		}
+		var action CorporateAction
+		if err := doc.DataTo(&action); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode corporate action %s: %w", doc.Ref.ID, err)
+		}
+		action.ID = doc.Ref.ID
+		actions = append(actions, action)
+	}
+	if filter.Limit == 0 && filter.Offset == 0 {
+		total = len(actions)
+	}
+	return actions, total, nil
+}
+
+func getCorporateAction(ctx context.Context, id string) (CorporateAction, error) {
+	if id == "" {
+		return CorporateAction{}, fmt.Errorf("%w: action ID is required", errInvalidInput)
+	}
+	doc, err := corporateActionDoc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return CorporateAction{}, fmt.Errorf("corporate action %s: %w", id, errNotFound)
+	}
+	if err != nil {
+		return CorporateAction{}, fmt.Errorf("failed to get corporate action %s: %w", id, err)
+	}
+	var action CorporateAction
+	if err := doc.DataTo(&action); err != nil {
+		return CorporateAction{}, fmt.Errorf("failed to decode corporate action %s: %w", id, err)
+	}
+	action.ID = doc.Ref.ID
+	return action, nil
+}
+
+// addCorporateAction records an action entered by hand for review. It replaces a
+// pending or dismissed action of the same ticker, type and date.
+func addCorporateAction(ctx context.Context, req CorporateActionRequest) (action CorporateAction, err error) {
+	action = CorporateAction{
+		Ticker:  strings.ToUpper(strings.TrimSpace(req.Ticker)),
+		Type:    req.Type,
+		Date:    req.Date,
+		Ratio:   req.Ratio,
+		Amount:  req.Amount,
+		PayDate: req.PayDate,
+		Source:  "manual",
+		Status:  actionPending,
+		Found:   time.Now(),
+	}
+	action.ID = action.key()
+	var before *CorporateAction
+	defer func() { recordAudit(ctx, auditActionAdd, action.ID, before, action, err) }()
+
+	if err := action.validate(); err != nil {
+		return CorporateAction{}, err
+	}
+	if existing, err := getCorporateAction(ctx, action.ID); err == nil {
+		before = &existing
+		if existing.Status == actionApplied {
+			return CorporateAction{}, fmt.Errorf("%w: the %s of %s on %s has already been applied", errInvalidInput, action.Type, action.Ticker, action.Date)
+		}
+	}
+	if _, err := corporateActionDoc(action.ID).Set(ctx, action); err != nil {
+		return CorporateAction{}, fmt.Errorf("failed to save corporate action: %w", err)
+	}
+	return action, nil
+}
+
+// fetchCorporateActions asks the provider for the recent splits and dividends of every
+// holding and stores the ones not seen before as pending.
+func fetchCorporateActions(ctx context.Context) (CorporateActionFetch, error) {
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return CorporateActionFetch{}, err
+	}
+
+	result := CorporateActionFetch{Found: []CorporateAction{}, Failed: make(map[string]string)}
+	from := time.Now().AddDate(0, 0, -corporateActionLookback)
+	for _, stock := range stocks {
+		splits, err := fetchSplits(stock.Ticker, from)
+		if err != nil {
+			log.Printf("Could not fetch splits of %s: %v", stock.Ticker, err)
+			result.Failed[stock.Ticker] = err.Error()
+			continue
+		}
+		dividends, err := fetchDividends(stock.Ticker, from)
+		if err != nil {
+			log.Printf("Could not fetch dividends of %s: %v", stock.Ticker, err)
+			result.Failed[stock.Ticker] = err.Error()
+			continue
+		}
+
+		for _, action := range append(splits, dividends...) {
+			action.ID, action.Source, action.Status, action.Found = action.key(), "provider", actionPending, time.Now()
+			// Create fails for actions already stored, whatever their state
+			if _, err := corporateActionDoc(action.ID).Create(ctx, action); err != nil {
+				if status.Code(err) != codes.AlreadyExists {
+					log.Printf("Failed to store corporate action %s: %v", action.ID, err)
+					result.Failed[stock.Ticker] = err.Error()
+				}
+				continue
+			}
+			result.Found = append(result.Found, action)
+		}
+	}
+
+	recordAudit(ctx, auditActionFetch, "portfolio", nil, map[string]interface{}{
+		"found":  len(result.Found),
+		"failed": result.Failed,
+	}, nil)
+	return result, nil
+}
+
+// adjustForSplit scales the shares a holding had on a split's ex-date by its ratio.
+// Shares bought since were bought at split-adjusted prices and stay as they are; the
+// cost basis is spread over the new total. If the stored analysis predates the split
+// its prices are scaled too, so the holding's value stays right until the next
+// analysis fetches prices the provider has already adjusted.
+func adjustForSplit(stock *Stock, a CorporateAction) {
+	cost := stock.Quantity * stock.Price
+	stock.Quantity += a.Shares * (a.Ratio - 1)
+	if stock.Quantity > 0 {
+		stock.Price = cost / stock.Quantity
+	}
+	if len(stock.History) == 0 || stock.History[0].Date >= a.Date {
+		return
+	}
+	ratio := a.Ratio
+	stock.CurrentPrice /= ratio
+	stock.MA200 /= ratio
+	for i := range stock.History {
+		h := &stock.History[i]
+		h.Open, h.High, h.Low, h.Close, h.AdjClose = h.Open/ratio, h.High/ratio, h.Low/ratio, h.Close/ratio, h.AdjClose/ratio
+		h.Volume *= ratio
+	}
+}
+
+// sharesOnExDate returns how many shares of the action's ticker were held at the start
+// of its ex-date, replayed from the events read in tx, as only those are paid a
+// dividend or split. Holdings that predate the event stream have no earlier state, so
+// they count with the quantity held now.
+func sharesOnExDate(tx *firestore.Transaction, a CorporateAction, current float64) (float64, error) {
+	exDate, err := time.Parse("2006-01-02", a.Date)
+	if err != nil {
+		return 0, fmt.Errorf("%w: the ex-date must be a date in YYYY-MM-DD format", errInvalidInput)
+	}
+	events, err := readEvents(tx.Documents(eventsUntil(exDate)))
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		log.Printf("No events before the ex-date of %s, using the shares held now", a.ID)
+		return current, nil
+	}
+	p, err := replay(events)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range p.state(exDate, len(events)).Holdings {
+		if s.Ticker == a.Ticker {
+			return s.Quantity, nil
+		}
+	}
+	return 0, nil
+}
+
+// corporateActionPayload is the payload of an eventActionApplied event.
+type corporateActionPayload struct {
+	Action   CorporateAction `json:"action"`
+	Holding  *Stock          `json:"holding,omitempty"`  // The holding after a split
+	Settings *Settings       `json:"settings,omitempty"` // The settings after a dividend
+}
+
+// applyCorporateAction applies a pending action to the shares held on its ex-date: a
+// split to the holding, a dividend net of withholding to the holding under DRIP or else
+// to the dividend cash. The holding must still be in the portfolio. The action,
+// holding, settings and the events behind the shares on the ex-date are read and
+// written in one transaction, so an action is applied once even if it is reviewed
+// twice at the same time.
+func applyCorporateAction(ctx context.Context, id string) (action CorporateAction, err error) {
+	before, err := getCorporateAction(ctx, id)
+	if err != nil {
+		return CorporateAction{}, err
+	}
+	action = before
+	defer func() { recordAudit(ctx, auditActionApply, id, before, action, err) }()
+
+	if before.Status != actionPending {
+		return CorporateAction{}, fmt.Errorf("%w: the action has already been %s", errInvalidInput, before.Status)
+	}
+	// Makes sure the settings document exists before the transaction reads it
+	if _, err := getSettings(ctx); err != nil {
+		return CorporateAction{}, err
+	}
+
+	var payload corporateActionPayload
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// The transaction may be retried, so everything is worked out again from what it reads
+		payload = corporateActionPayload{}
+		actionSnap, err := tx.Get(corporateActionDoc(id))
+		if err != nil {
+			return fmt.Errorf("failed to get corporate action %s: %w", id, err)
+		}
+		if err := actionSnap.DataTo(&action); err != nil {
+			return fmt.Errorf("failed to decode corporate action %s: %w", id, err)
+		}
+		action.ID = id
+		if action.Status != actionPending {
+			return fmt.Errorf("%w: the action has already been %s", errInvalidInput, action.Status)
+		}
+		stockRef := firestoreClient.Collection("portfolio").Doc(action.Ticker)
+		stockSnap, err := tx.Get(stockRef)
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("stock %s: %w", action.Ticker, errNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get stock %s: %w", action.Ticker, err)
+		}
+		var stock Stock
+		if err := stockSnap.DataTo(&stock); err != nil {
+			return fmt.Errorf("failed to decode stock %s: %w", action.Ticker, err)
+		}
+		settingsSnap, err := tx.Get(settingsDoc())
+		if err != nil {
+			return fmt.Errorf("failed to get settings: %w", err)
+		}
+		var settings Settings
+		if err := settingsSnap.DataTo(&settings); err != nil {
+			return fmt.Errorf("failed to decode settings: %w", err)
+		}
+		exShares, err := sharesOnExDate(tx, action, stock.Quantity)
+		if err != nil {
+			return err
+		}
+		if exShares <= 0 {
+			return fmt.Errorf("%w: no shares of %s were held on %s, dismiss the action instead", errInvalidInput, action.Ticker, action.Date)
+		}
+
+		action.Status, action.Shares, action.AppliedAt = actionApplied, exShares, time.Now()
+		switch action.Type {
+		case actionSplit:
+			// Shares sold since the ex-date were sold after the split
+			action.Shares = math.Min(exShares, stock.Quantity)
+			adjustForSplit(&stock, action)
+			if err := tx.Set(stockRef, stock); err != nil {
+				return fmt.Errorf("failed to update stock %s: %w", stock.Ticker, err)
+			}
+			payload.Holding = &stock
+		case actionDividend:
+			action.TaxRate = stock.Withholding
+			action.Net = math.Round(action.Shares*action.Amount*(1-action.TaxRate)*100) / 100
+			action.Cash = action.Net
+			if stock.DRIP {
+				if action.Bought = reinvestDividend(&stock, action); action.Bought > 0 {
+					if err := tx.Set(stockRef, stock); err != nil {
+						return fmt.Errorf("failed to update stock %s: %w", stock.Ticker, err)
+					}
+					payload.Holding = &stock
+					action.Cash = math.Round((action.Net-action.Bought*dividendPrice(stock, action))*100) / 100
+				}
+			}
+			if action.Cash > 0 {
+				settings.DividendCash += action.Cash
+				if err := tx.Update(settingsDoc(), []firestore.Update{{Path: "dividendCash", Value: settings.DividendCash}}); err != nil {
+					return fmt.Errorf("failed to add dividend to the dividend cash: %w", err)
+				}
+				payload.Settings = &settings
+			}
+		}
+		if err := tx.Set(corporateActionDoc(id), action); err != nil {
+			return fmt.Errorf("failed to mark corporate action %s as applied: %w", id, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return CorporateAction{}, err
+	}
+
+	payload.Action = action
+	return action, appendEvent(ctx, eventActionApplied, payload)
+}
+
+// dismissCorporateAction marks a pending action as not applying to the portfolio.
+func dismissCorporateAction(ctx context.Context, id string) (action CorporateAction, err error) {
+	before, err := getCorporateAction(ctx, id)
+	if err != nil {
+		return CorporateAction{}, err
+	}
+	action = before
+	defer func() { recordAudit(ctx, auditActionDismiss, id, before, action, err) }()
+
+	if before.Status != actionPending {
+		return CorporateAction{}, fmt.Errorf("%w: the action has already been %s", errInvalidInput, before.Status)
+	}
+	action.Status, action.AppliedAt = actionDismissed, time.Now()
+	if _, err := corporateActionDoc(id).Set(ctx, action); err != nil {
+		return CorporateAction{}, fmt.Errorf("failed to dismiss corporate action: %w", err)
+	}
+	return action, nil
+}
+
+// splitHistory holds the applied splits by ticker.
+type splitHistory map[string][]CorporateAction
+
+// appliedSplits returns every split that has been applied.
+func appliedSplits(ctx context.Context) (splitHistory, error) {
+	actions, err := listCorporateActions(ctx, actionApplied)
+	if err != nil {
+		return nil, err
+	}
+	splits := make(splitHistory)
+	for _, a := range actions {
+		if a.Type == actionSplit {
+			splits[a.Ticker] = append(splits[a.Ticker], a)
+		}
+	}
+	return splits, nil
+}
+
+// factor is the number of shares one share of ticker held at t has become through the
+// splits after t. Split-adjusted prices are divided by the same factor.
+func (h splitHistory) factor(ticker string, t time.Time) float64 {
+	f, day := 1.0, t.Format("2006-01-02")
+	for _, split := range h[ticker] {
+		if split.Date > day {
+			f *= split.Ratio
+		}
+	}
+	return f
+}
+
+// factorExcept is the product of the ratios of the splits of ticker that are not in
+// applied, i.e. the splits a reconstructed holding has not been adjusted for yet.
+func (h splitHistory) factorExcept(ticker string, applied map[string]bool) float64 {
+	f := 1.0
+	for _, split := range h[ticker] {
+		if !applied[split.ID] {
+			f *= split.Ratio
+		}
+	}
+	return f
+}
+
+// adjustLogs sets the split factor of every log bought before an applied split.
+func (h splitHistory) adjustLogs(logs []InvestmentLog) {
+	for i := range logs {
+		if f := h.factor(logs[i].Ticker, logs[i].Timestamp); f != 1 {
+			logs[i].SplitFactor = f
+		}
+	}
+}
+
+func showCorporateActionsPage(c *gin.Context) {
+	actions, err := listCorporateActions(c.Request.Context(), "")
+	if err != nil {
+		log.Printf("Failed to load corporate actions: %v", err)
+	}
+	var pending, reviewed []CorporateAction
+	for _, a := range actions {
+		if a.Status == actionPending {
+			pending = append(pending, a)
+		} else {
+			reviewed = append(reviewed, a)
+		}
+	}
+
+	c.HTML(http.StatusOK, "actions.tmpl.html", gin.H{
+		"Pending":  pending,
+		"Reviewed": reviewed,
+		"Lookback": corporateActionLookback,
+	})
+}
+
+func handleFetchCorporateActions(c *gin.Context) {
+	if _, err := fetchCorporateActions(c.Request.Context()); err != nil {
+		log.Printf("Failed to fetch corporate actions: %v", err)
+	}
+
+	c.Redirect(http.StatusFound, "/corporate-actions")
+}
+
+func handleAddCorporateAction(c *gin.Context) {
+	req := CorporateActionRequest{
+		Ticker: c.PostForm("ticker"),
+		Type:   c.PostForm("type"),
+		Date:   c.PostForm("date"),
+	}
+	if req.Type == actionDividend {
+		req.PayDate = c.PostForm("payDate")
+	}
+	value, _ := strconv.ParseFloat(strings.Replace(c.PostForm("value"), ",", ".", -1), 64)
+	if req.Type == actionSplit {
+		req.Ratio = value
+	} else {
+		req.Amount = value
+	}
+	if _, err := addCorporateAction(c.Request.Context(), req); err != nil {
+		log.Printf("Failed to add corporate action: %v", err)
+		c.String(formErrorStatus(err), "Failed to add corporate action: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/corporate-actions")
+}
+
+func handleApplyCorporateAction(c *gin.Context) {
+	id := c.PostForm("id")
+	if _, err := applyCorporateAction(c.Request.Context(), id); err != nil {
+		log.Printf("Failed to apply corporate action %s: %v", id, err)
+		c.String(formErrorStatus(err), "Failed to apply corporate action: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/corporate-actions")
+}
+
+func handleDismissCorporateAction(c *gin.Context) {
+	id := c.PostForm("id")
+	if _, err := dismissCorporateAction(c.Request.Context(), id); err != nil {
+		log.Printf("Failed to dismiss corporate action %s: %v", id, err)
+		c.String(formErrorStatus(err), "Failed to dismiss corporate action: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/corporate-actions")
+}
+
+func apiListCorporateActions(c *gin.Context) {
+	filter := CorporateActionFilter{Status: c.Query("status"), Limit: defaultLogPageSize}
+	switch filter.Status {
+	case "", actionPending, actionApplied, actionDismissed:
+	default:
+		badRequest(c, "status must be pending, applied or dismissed")
+		return
+	}
+	var err error
+	if s := c.Query("limit"); s != "" {
+		if filter.Limit, err = strconv.Atoi(s); err != nil || filter.Limit < 1 || filter.Limit > maxLogPageSize {
+			badRequest(c, "limit must be between 1 and "+strconv.Itoa(maxLogPageSize))
+			return
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if filter.Offset, err = strconv.Atoi(s); err != nil || filter.Offset < 0 {
+			badRequest(c, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	actions, total, err := queryCorporateActions(c.Request.Context(), filter)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, CorporateActionPage{Total: total, Limit: filter.Limit, Offset: filter.Offset, Items: actions})
+}
+
+func apiAddCorporateAction(c *gin.Context) {
+	var req CorporateActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	action, err := addCorporateAction(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, action)
+}
+
+func apiFetchCorporateActions(c *gin.Context) {
+	result, err := fetchCorporateActions(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func apiApplyCorporateAction(c *gin.Context) {
+	action, err := applyCorporateAction(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, action)
+}
+
+func apiDismissCorporateAction(c *gin.Context) {
+	action, err := dismissCorporateAction(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, action)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAdjustForSplit(t *testing.T) {
+	tests := []struct {
+		name                string
+		quantity, price     float64
+		shares              float64 // Held on the ex-date
+		wantQuantity        float64
+		wantPrice           float64
+		wantCurrent, wantMA float64
+		historyDate, exDate string
+	}{
+		// 10 shares at 100 become 40 at 25
+		{"all shares held on the ex-date", 10, 100, 10, 40, 25, 50, 45, "2024-05-31", "2024-06-03"},
+		// 10 shares held then, 5 bought since at the adjusted price: 45 shares, cost 1500
+		{"shares bought since", 15, 100, 10, 45, 1500.0 / 45, 50, 45, "2024-05-31", "2024-06-03"},
+		// The analysis ran after the split, so its prices are already adjusted
+		{"analysis after the split", 10, 100, 10, 40, 25, 200, 180, "2024-06-03", "2024-06-03"},
+	}
+	for _, tt := range tests {
+		stock := Stock{
+			Quantity: tt.quantity, Price: tt.price, CurrentPrice: 200, MA200: 180,
+			History: []HistoricalPrice{{Date: tt.historyDate, Close: 200, Volume: 1000}},
+		}
+		adjustForSplit(&stock, CorporateAction{Type: actionSplit, Date: tt.exDate, Ratio: 4, Shares: tt.shares})
+		if stock.Quantity != tt.wantQuantity || math.Abs(stock.Price-tt.wantPrice) > 1e-9 {
+			t.Errorf("%s: %v shares at %v, want %v at %v", tt.name, stock.Quantity, stock.Price, tt.wantQuantity, tt.wantPrice)
+		}
+		if stock.CurrentPrice != tt.wantCurrent || stock.MA200 != tt.wantMA {
+			t.Errorf("%s: current price %v and MA-200 %v, want %v and %v", tt.name, stock.CurrentPrice, stock.MA200, tt.wantCurrent, tt.wantMA)
+		}
+		if h := stock.History[0]; h.Close != tt.wantCurrent || h.Volume != 1000*200/tt.wantCurrent {
+			t.Errorf("%s: history close %v and volume %v, want them scaled like the current price", tt.name, h.Close, h.Volume)
+		}
+	}
+}
+
+func TestSplitHistoryFactor(t *testing.T) {
+	h := splitHistory{"ABC": {
+		{ID: "ABC_split_2024-03-01", Date: "2024-03-01", Ratio: 2},
+		{ID: "ABC_split_2024-06-03", Date: "2024-06-03", Ratio: 3},
+	}}
+	tests := []struct {
+		ticker string
+		at     string
+		want   float64
+	}{
+		{"ABC", "2024-01-15", 6},
+		{"ABC", "2024-03-01", 3}, // Bought on the ex-date, at the adjusted price
+		{"ABC", "2024-06-03", 1},
+		{"XYZ", "2024-01-15", 1},
+	}
+	for _, tt := range tests {
+		if got := h.factor(tt.ticker, day(tt.at)); got != tt.want {
+			t.Errorf("factor(%s, %s) = %v, want %v", tt.ticker, tt.at, got, tt.want)
+		}
+	}
+
+	if got := h.factorExcept("ABC", map[string]bool{"ABC_split_2024-03-01": true}); got != 3 {
+		t.Errorf("factorExcept = %v, want 3", got)
+	}
+
+	logs := []InvestmentLog{
+		{Ticker: "ABC", Timestamp: time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{Ticker: "ABC", Timestamp: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	h.adjustLogs(logs)
+	if logs[0].SplitFactor != 6 || logs[1].SplitFactor != 0 {
+		t.Errorf("split factors %v and %v, want 6 and unset", logs[0].SplitFactor, logs[1].SplitFactor)
+	}
+}
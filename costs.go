@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// CostModel describes what it costs to execute an order. The zero value is free
+// execution at the mid price, which is how allocations worked before costs existed.
+type CostModel struct {
+	FixedFee   float64 `firestore:"fixedFee" json:"fixedFee" openapi:"min=0"`           // Per order, in €
+	PercentFee float64 `firestore:"percentFee" json:"percentFee" openapi:"min=0,max=1"` // Share of the order value, 0.001 = 0.1%
+	MinFee     float64 `firestore:"minFee" json:"minFee" openapi:"min=0"`               // Minimum fee per order, in €
+	Spread     float64 `firestore:"spread" json:"spread" openapi:"min=0,max=1"`         // Bid/ask spread as a share of the price; half of it is paid on each trade
+	Slippage   float64 `firestore:"slippage" json:"slippage" openapi:"min=0,max=1"`     // Adverse move between decision and fill, as a share of the price
+}
+
+// validate checks that the model only contains usable values.
+func (m CostModel) validate() error {
+	for _, v := range []float64{m.FixedFee, m.PercentFee, m.MinFee, m.Spread, m.Slippage} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%w: costs must be non-negative numbers", errInvalidInput)
+		}
+	}
+	if m.PercentFee >= 1 || m.Spread >= 1 || m.Slippage >= 1 {
+		return fmt.Errorf("%w: percentage fee, spread and slippage must be below 1 (100%%)", errInvalidInput)
+	}
+	return nil
+}
+
+// fee returns the commission on an order worth gross.
+func (m CostModel) fee(gross float64) float64 {
+	return math.Max(m.MinFee, m.FixedFee+m.PercentFee*gross)
+}
+
+// execute turns a strategy's decision into what would actually be filled. For a buy,
+// InvestmentAmount is the cash to spend: fees are taken out of it and the rest buys
+// shares at the ask plus slippage. For a sell, the shares are sold at the bid minus
+// slippage. The returned entry holds the net amount invested (or received), the fill
+// price and the fees; ok is false when the fees would eat the whole order.
+func (m CostModel) execute(e AllocationEntry) (filled AllocationEntry, ok bool) {
+	if e.QuantityBought < 0 {
+		price := e.PricePerShare * (1 - m.Spread/2 - m.Slippage)
+		gross := -e.QuantityBought * price
+		e.PricePerShare = price
+		e.InvestmentAmount = -gross
+		e.Fees = m.fee(gross)
+		return e, gross > e.Fees
+	}
+
+	cash := e.InvestmentAmount
+	gross := (cash - m.FixedFee) / (1 + m.PercentFee)
+	if m.FixedFee+m.PercentFee*gross < m.MinFee {
+		gross = cash - m.MinFee
+	}
+	if gross <= 0 {
+		return e, false
+	}
+	e.PricePerShare *= 1 + m.Spread/2 + m.Slippage
+	e.InvestmentAmount = gross
+	e.QuantityBought = gross / e.PricePerShare
+	e.Fees = cash - gross
+	return e, true
+}
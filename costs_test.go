@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// buy is a strategy's decision to put amount into ticker at price.
+func buy(ticker string, amount, price float64) AllocationEntry {
+	return AllocationEntry{Ticker: ticker, InvestmentAmount: amount, PricePerShare: price, QuantityBought: amount / price}
+}
+
+func TestCostModelExecute(t *testing.T) {
+	tests := []struct {
+		name   string
+		costs  CostModel
+		entry  AllocationEntry
+		ok     bool
+		amount float64 // Net of fees, negative for sells
+		price  float64
+		fees   float64
+	}{
+		{"free", CostModel{}, buy("A", 100, 10), true, 100, 10, 0},
+		{"fixed and percentage fee", CostModel{FixedFee: 1, PercentFee: 0.01}, buy("A", 102, 10), true, 100, 10, 2},
+		// 1 + 0.1% of about 99 is below the minimum, so the minimum is charged
+		{"minimum fee", CostModel{FixedFee: 1, PercentFee: 0.001, MinFee: 5}, buy("A", 100, 10), true, 95, 10, 5},
+		{"fee just below the minimum", CostModel{FixedFee: 4.99, MinFee: 5}, buy("A", 100, 10), true, 95, 10, 5},
+		{"fee above the minimum", CostModel{PercentFee: 0.1, MinFee: 5}, buy("A", 110, 10), true, 100, 10, 10},
+		{"minimum fee eats the order", CostModel{MinFee: 5}, buy("A", 5, 10), false, 0, 0, 0},
+		{"half the spread and the slippage", CostModel{Spread: 0.02, Slippage: 0.01}, buy("A", 102, 10), true, 102, 10.2, 0},
+		{"sell at the bid with the minimum fee", CostModel{Spread: 0.02, MinFee: 5},
+			AllocationEntry{Ticker: "A", QuantityBought: -10, PricePerShare: 10}, true, -99, 9.9, 5},
+		{"minimum fee eats the sell", CostModel{MinFee: 5},
+			AllocationEntry{Ticker: "A", QuantityBought: -1, PricePerShare: 3}, false, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		got, ok := tt.costs.execute(tt.entry)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if math.Abs(got.InvestmentAmount-tt.amount) > 1e-9 || math.Abs(got.PricePerShare-tt.price) > 1e-9 || math.Abs(got.Fees-tt.fees) > 1e-9 {
+			t.Errorf("%s: amount %v at %v with fees %v, want %v at %v with fees %v",
+				tt.name, got.InvestmentAmount, got.PricePerShare, got.Fees, tt.amount, tt.price, tt.fees)
+		}
+		if tt.entry.QuantityBought > 0 && math.Abs(got.QuantityBought*got.PricePerShare-got.InvestmentAmount) > 1e-9 {
+			t.Errorf("%s: %v shares at %v don't add up to %v", tt.name, got.QuantityBought, got.PricePerShare, got.InvestmentAmount)
+		}
+	}
+}
+
+func TestCostModelFee(t *testing.T) {
+	m := CostModel{FixedFee: 2, PercentFee: 0.005, MinFee: 4}
+	for _, tt := range []struct{ gross, fee float64 }{{0, 4}, {100, 4}, {400, 4}, {1000, 7}} {
+		if got := m.fee(tt.gross); math.Abs(got-tt.fee) > 1e-9 {
+			t.Errorf("fee(%v) = %v, want %v", tt.gross, got, tt.fee)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Dividends are recorded as corporate actions (see corporate.go). This file holds what
+// is specific to them: reinvesting under DRIP, the trailing dividends fetched by the
+// analysis, and the income and total return they add up to.
+
+// DividendOptions are the dividend settings of a holding.
+type DividendOptions struct {
+	DRIP        bool    `json:"drip"`                              // Reinvest dividends in the holding instead of adding them to the budget
+	Withholding float64 `json:"withholding" openapi:"min=0,max=1"` // Tax withheld from the dividends, 0.15 = 15%
+}
+
+// trailingDividends returns the dividends per share of ticker that went ex in the year
+// before now.
+func trailingDividends(ticker string, now time.Time) (float64, error) {
+	dividends, err := fetchDividends(ticker, now.AddDate(-1, 0, 0))
+	if err != nil {
+		return 0, err
+	}
+	var perShare float64
+	for _, d := range dividends {
+		perShare += d.Amount
+	}
+	return perShare, nil
+}
+
+// dividendPrice is the price a dividend is reinvested at: the last close the analysis
+// stored on or before the payment date, or the current price if there is none.
+func dividendPrice(stock Stock, a CorporateAction) float64 {
+	day := a.PayDate
+	if day == "" {
+		day = a.Date
+	}
+	for _, p := range stock.History {
+		if p.Date <= day && p.Close > 0 {
+			return p.Close
+		}
+	}
+	return stock.CurrentPrice
+}
+
+// reinvestDividend buys shares of the holding with the net dividend and returns how
+// many. The reinvested cash is added to the cost basis, as it is new money in the
+// holding. Holdings limited to whole shares buy as many as the dividend affords.
+func reinvestDividend(stock *Stock, a CorporateAction) float64 {
+	price := dividendPrice(*stock, a)
+	if price <= 0 || a.Net <= 0 {
+		return 0
+	}
+	shares := a.Net / price
+	if stock.WholeShares {
+		shares = math.Floor(shares)
+	}
+	if shares <= 0 {
+		return 0
+	}
+	stock.Price = (stock.Price*stock.Quantity + shares*price) / (stock.Quantity + shares)
+	stock.Quantity += shares
+	return shares
+}
+
+// DividendIncome is the dividend income and total return of a holding.
+type DividendIncome struct {
+	Ticker      string  `json:"ticker"`
+	Name        string  `json:"name"`
+	PerShare    float64 `json:"perShare"` // Dividends per share over the last year
+	Yield       float64 `json:"yield"`    // PerShare at the current price
+	Gross       float64 `json:"gross"`    // Expected over the next year if the dividends and quantity stay the same
+	Net         float64 `json:"net"`      // Gross after withholding
+	DRIP        bool    `json:"drip"`
+	Received    float64 `json:"received"`    // Net dividends applied so far, reinvested or not
+	PriceReturn float64 `json:"priceReturn"` // Value against the cost basis
+	TotalReturn float64 `json:"totalReturn"` // Value and dividends paid out against the cost basis without the reinvested dividends
+}
+
+// IncomeProjection is the projected dividend income of the portfolio.
+type IncomeProjection struct {
+	Holdings    []DividendIncome `json:"holdings"`
+	Gross       float64          `json:"gross"`
+	Net         float64          `json:"net"`
+	Received    float64          `json:"received"`
+	PriceReturn float64          `json:"priceReturn"`
+	TotalReturn float64          `json:"totalReturn"`
+}
+
+// projectIncome projects the dividend income of the holdings from the dividends they
+// paid over the last year, and works out their returns with and without the dividends
+// in records. Under DRIP the reinvested dividends are already in the value, through the
+// shares they bought, and in the cost basis. The total return takes them back out of the
+// cost basis and adds only the dividends paid out as cash to the value, so every
+// dividend counts once and none of them as money put in.
+func projectIncome(stocks []Stock, records []CorporateAction) IncomeProjection {
+	received := make(map[string]float64)
+	reinvested := make(map[string]float64)
+	for _, r := range records {
+		if r.Type == actionDividend && r.Status == actionApplied {
+			received[r.Ticker] += r.Net
+			reinvested[r.Ticker] += r.Net - r.Cash
+		}
+	}
+
+	projection := IncomeProjection{Holdings: []DividendIncome{}}
+	var value, cost, totalValue, totalCost float64
+	for _, s := range stocks {
+		price := s.CurrentPrice
+		if price <= 0 {
+			price = s.Price
+		}
+		income := DividendIncome{
+			Ticker:   s.Ticker,
+			Name:     s.Name,
+			PerShare: s.DividendTTM,
+			Gross:    s.Quantity * s.DividendTTM,
+			DRIP:     s.DRIP,
+			Received: received[s.Ticker],
+		}
+		income.Net = income.Gross * (1 - s.Withholding)
+		if price > 0 {
+			income.Yield = s.DividendTTM / price
+		}
+		paidOut := received[s.Ticker] - reinvested[s.Ticker]
+		if basis := s.Quantity * s.Price; basis > 0 {
+			income.PriceReturn = s.Quantity*price/basis - 1
+		}
+		if basis := s.Quantity*s.Price - reinvested[s.Ticker]; basis > 0 {
+			income.TotalReturn = (s.Quantity*price+paidOut)/basis - 1
+		}
+		projection.Holdings = append(projection.Holdings, income)
+		projection.Gross += income.Gross
+		projection.Net += income.Net
+		projection.Received += income.Received
+		value += s.Quantity * price
+		cost += s.Quantity * s.Price
+		totalValue += s.Quantity*price + paidOut
+		totalCost += s.Quantity*s.Price - reinvested[s.Ticker]
+	}
+	if cost > 0 {
+		projection.PriceReturn = value/cost - 1
+	}
+	if totalCost > 0 {
+		projection.TotalReturn = totalValue/totalCost - 1
+	}
+	return projection
+}
+
+// dividendIncome projects the income of the current holdings.
+func dividendIncome(ctx context.Context) (IncomeProjection, error) {
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return IncomeProjection{}, err
+	}
+	records, err := listCorporateActions(ctx, actionApplied)
+	if err != nil {
+		return IncomeProjection{}, err
+	}
+	return projectIncome(stocks, records), nil
+}
+
+// dividendRecords returns the dividends of a holding, applied or still to review,
+// newest first.
+func dividendRecords(ctx context.Context, ticker string) ([]CorporateAction, error) {
+	actions, err := listCorporateActions(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	records := []CorporateAction{}
+	for _, a := range actions {
+		if a.Type == actionDividend && a.Status != actionDismissed && strings.EqualFold(a.Ticker, ticker) {
+			records = append(records, a)
+		}
+	}
+	return records, nil
+}
+
+// dividendPayout is the cash a dividend paid to the holdings of a strategy.
+type dividendPayout struct {
+	date     string // Payment date, YYYY-MM-DD
+	strategy string
+	cash     float64
+}
+
+// strategyDividends works out the dividends the logged purchases were paid, for the
+// history chart. Shares count if they were bought before the ex-date and their log
+// had not been deleted by then; the split factors turn the logged shares into the
+// shares held on the ex-date. The payouts are returned in payment order.
+func strategyDividends(entries []loggedEntry, dividends map[string][]CorporateAction, splits splitHistory) []dividendPayout {
+	var payouts []dividendPayout
+	for _, entry := range entries {
+		l := entry.Log
+		bought := l.Timestamp.Format("2006-01-02")
+		for _, d := range dividends[l.Ticker] {
+			if d.Date <= bought || !entry.Deleted.IsZero() && entry.Deleted.Format("2006-01-02") < d.Date {
+				continue
+			}
+			exDate, _ := time.Parse("2006-01-02", d.Date)
+			shares := l.QuantityBought * splits.factor(l.Ticker, l.Timestamp) / splits.factor(l.Ticker, exDate)
+			paid := d.PayDate
+			if paid == "" {
+				paid = d.Date
+			}
+			payouts = append(payouts, dividendPayout{date: paid, strategy: l.StrategyKey, cash: shares * d.Amount})
+		}
+	}
+	sort.SliceStable(payouts, func(i, j int) bool { return payouts[i].date < payouts[j].date })
+	return payouts
+}
+
+func apiDividendIncome(c *gin.Context) {
+	projection, err := dividendIncome(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, projection)
+}
+
+func apiListDividends(c *gin.Context) {
+	records, err := dividendRecords(c.Request.Context(), c.Param("ticker"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReinvestDividend(t *testing.T) {
+	history := []HistoricalPrice{{Date: "2024-06-20", Close: 25}, {Date: "2024-06-14", Close: 20}, {Date: "2024-06-03", Close: 16}}
+	tests := []struct {
+		name         string
+		wholeShares  bool
+		payDate      string
+		net          float64
+		wantBought   float64
+		wantQuantity float64
+		wantPrice    float64
+	}{
+		// The last close on or before the pay date is 20
+		{"fractional", false, "2024-06-15", 50, 2.5, 12.5, (10*10 + 50) / 12.5},
+		{"whole shares", true, "2024-06-15", 50, 2, 12, (10*10 + 40) / 12.0},
+		{"less than a whole share", true, "2024-06-15", 15, 0, 10, 10},
+		// Without a pay date the ex-date is used
+		{"ex-date", false, "", 32, 2, 12, (10*10 + 32) / 12.0},
+	}
+	for _, tt := range tests {
+		stock := Stock{Quantity: 10, Price: 10, CurrentPrice: 25, WholeShares: tt.wholeShares, History: history}
+		bought := reinvestDividend(&stock, CorporateAction{Type: actionDividend, Date: "2024-06-05", PayDate: tt.payDate, Net: tt.net})
+		if bought != tt.wantBought || stock.Quantity != tt.wantQuantity || math.Abs(stock.Price-tt.wantPrice) > 1e-9 {
+			t.Errorf("%s: bought %v, now %v shares at %v; want %v, %v at %v", tt.name, bought, stock.Quantity, stock.Price, tt.wantBought, tt.wantQuantity, tt.wantPrice)
+		}
+	}
+}
+
+func TestProjectIncome(t *testing.T) {
+	// Both holdings had 100 shares bought at 10 and were paid a dividend of 1 per share
+	// while the price was 10. CASH took the 100 as cash, DRIP reinvested it in 10 shares.
+	cash := Stock{Ticker: "CASH", Quantity: 100, Price: 10, CurrentPrice: 12, DividendTTM: 2, Withholding: 0.15}
+	drip := Stock{Ticker: "DRIP", Quantity: 100, Price: 10, CurrentPrice: 12, DividendTTM: 2, DRIP: true, History: []HistoricalPrice{{Date: "2024-06-03", Close: 10}}}
+	dividend := CorporateAction{Ticker: "DRIP", Type: actionDividend, Date: "2024-06-03", Status: actionApplied, Net: 100}
+	dividend.Bought = reinvestDividend(&drip, dividend)
+	records := []CorporateAction{
+		{Ticker: "CASH", Type: actionDividend, Date: "2024-06-03", Status: actionApplied, Net: 100, Cash: 100},
+		dividend,
+		// Neither pending dividends nor splits count
+		{Ticker: "CASH", Type: actionDividend, Date: "2024-09-03", Status: actionPending, Amount: 1},
+		{Ticker: "DRIP", Type: actionSplit, Date: "2024-07-01", Status: actionApplied, Ratio: 2},
+	}
+
+	p := projectIncome([]Stock{cash, drip}, records)
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"CASH gross", p.Holdings[0].Gross, 200},
+		{"CASH net", p.Holdings[0].Net, 170},
+		{"CASH yield", p.Holdings[0].Yield, 2.0 / 12},
+		{"CASH received", p.Holdings[0].Received, 100},
+		{"CASH price return", p.Holdings[0].PriceReturn, 0.2},
+		// 1200 of shares and 100 of cash against the 1000 paid
+		{"CASH total return", p.Holdings[0].TotalReturn, 0.3},
+		{"DRIP gross", p.Holdings[1].Gross, 220},
+		{"DRIP received", p.Holdings[1].Received, 100},
+		// 110 shares against a cost basis of 1100 including the reinvested dividend
+		{"DRIP price return", p.Holdings[1].PriceReturn, 0.2},
+		// 1320 of shares against the 1000 paid: the dividend counts once, and the
+		// reinvested shares gained 20 more than the cash
+		{"DRIP total return", p.Holdings[1].TotalReturn, 0.32},
+		{"portfolio received", p.Received, 200},
+		{"portfolio price return", p.PriceReturn, 2520.0/2100 - 1},
+		{"portfolio total return", p.TotalReturn, 2620.0/2000 - 1},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.got-tt.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+// Every state change is appended to the events collection as an immutable event.
+// The portfolio, settings and log collections are projections of that stream: they
+// are updated alongside each event for fast reads, and can be rebuilt (or
+// reconstructed as of any point in time) by replaying the events in order.
+
+// eventsCollection holds the append-only event stream.
+const eventsCollection = "events"
+
+// eventGapsCollection holds the events of changes that were made but could not be
+// appended to the stream.
+const eventGapsCollection = "event_gaps"
+
+// Event types and the payload each one carries.
+const (
+	eventSnapshot        = "snapshot"          // snapshotPayload, written once when the stream is started
+	eventStockAdded      = "stock.added"       // Stock
+	eventStockUpdated    = "stock.updated"     // Stock
+	eventStockAnalyzed   = "stock.analyzed"    // Stock
+	eventStockDeleted    = "stock.deleted"     // stockDeletedPayload
+	eventBudgetChanged   = "settings.budget"   // Settings
+	eventCostsChanged    = "settings.costs"    // Settings
+	eventDriftChanged    = "settings.drift"    // Settings
+	eventValueAvgChanged = "settings.valueavg" // Settings
+	eventWatchlist       = "watchlist.changed" // Settings
+	eventRulesChanged    = "rules.changed"     // Settings
+	eventIndicators      = "settings.columns"  // Settings
+	eventPriceBasis      = "settings.prices"   // Settings
+	eventBenchmark       = "settings.bench"    // Settings
+	eventExposureLimits  = "settings.exposure" // Settings
+	eventAllocation      = "allocation.commit" // allocationPayload
+	eventActionApplied   = "action.applied"    // corporateActionPayload
+	eventLogDeleted      = "log.deleted"       // logDeletedPayload
+	eventLogBatchDeleted = "log.batch_deleted" // logDeletedPayload
+)
+
+// Event is a document in the events collection.
+type Event struct {
+	ID        string                 `firestore:"-" json:"id"`
+	Type      string                 `firestore:"type" json:"type"`
+	Actor     string                 `firestore:"actor" json:"actor"`
+	Data      map[string]interface{} `firestore:"data" json:"data"`
+	Timestamp time.Time              `firestore:"timestamp" json:"timestamp"`
+}
+
+type snapshotPayload struct {
+	Holdings []Stock         `json:"holdings"`
+	Settings Settings        `json:"settings"`
+	Logs     []InvestmentLog `json:"logs"`
+}
+
+type stockDeletedPayload struct {
+	Ticker string `json:"ticker"`
+}
+
+type allocationPayload struct {
+	Settings Settings        `json:"settings"` // Settings after the allocation
+	Holdings []Stock         `json:"holdings"` // Holdings changed by the allocation, as written
+	Logs     []InvestmentLog `json:"logs"`
+}
+
+type logDeletedPayload struct {
+	Strategy string   `json:"strategy"`
+	IDs      []string `json:"ids"`
+}
+
+// appendEvent records a state change that has just been written to the projections
+// and returns an error if the event could not be appended. The event is then added
+// to eventGapsCollection instead, as the stream no longer describes the projections
+// and rebuildProjections refuses to replay it until the gap has been resolved.
+func appendEvent(ctx context.Context, eventType string, payload any) error {
+	ctx = context.WithoutCancel(ctx)
+	event := Event{
+		Type:      eventType,
+		Actor:     actorFrom(ctx).User,
+		Data:      jsonObject(payload),
+		Timestamp: time.Now(),
+	}
+	_, _, err := firestoreClient.Collection(eventsCollection).Add(ctx, event)
+	if err == nil {
+		return nil
+	}
+	if _, _, gapErr := firestoreClient.Collection(eventGapsCollection).Add(ctx, event); gapErr != nil {
+		log.Printf("Failed to record the missing %s event: %v", eventType, gapErr)
+	}
+	return fmt.Errorf("failed to append %s event: %w", eventType, err)
+}
+
+// decode unmarshals the event payload into v.
+func (e Event) decode(v any) error {
+	b, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// loadEvents returns every event up to and including until, oldest first.
+func loadEvents(ctx context.Context, until time.Time) ([]Event, error) {
+	return readEvents(eventsUntil(until).Documents(ctx))
+}
+
+// eventsUntil selects every event up to and including until, oldest first.
+func eventsUntil(until time.Time) firestore.Query {
+	return firestoreClient.Collection(eventsCollection).Where("timestamp", "<=", until).OrderBy("timestamp", firestore.Asc)
+}
+
+// readEvents returns the events iter yields, so they can be read inside a transaction
+// too.
+func readEvents(iter *firestore.DocumentIterator) ([]Event, error) {
+	defer iter.Stop()
+	var events []Event
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate events: %w", err)
+		}
+		var event Event
+		if err := doc.DataTo(&event); err != nil {
+			return nil, fmt.Errorf("failed to decode event %s: %w", doc.Ref.ID, err)
+		}
+		event.ID = doc.Ref.ID
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// ensureEventBaseline starts the event stream with a snapshot of the current
+// collections if no events have been recorded yet, so replays begin from the state
+// that existed before event sourcing was introduced.
+func ensureEventBaseline(ctx context.Context) error {
+	iter := firestoreClient.Collection(eventsCollection).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	if _, err := iter.Next(); err != iterator.Done {
+		return err // Either an error, or the stream already exists
+	}
+
+	holdings, err := listStocks(ctx)
+	if err != nil {
+		return err
+	}
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return err
+	}
+	logs, _, err := queryLogs(ctx, LogFilter{})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Starting event stream with a snapshot of %d holdings and %d logs", len(holdings), len(logs))
+	return appendEvent(withActor(ctx, Actor{User: "system"}), eventSnapshot, snapshotPayload{Holdings: holdings, Settings: settings, Logs: logs})
+}
+
+// loggedEntry is a log together with the time it was deleted, if it has been.
+type loggedEntry struct {
+	Log     InvestmentLog
+	Deleted time.Time
+}
+
+// projection is the state derived by replaying events.
+type projection struct {
+	holdings map[string]Stock
+	settings Settings
+	logs     map[string]*loggedEntry // Keyed by strategy key and log ID
+	order    []string                // Log keys in the order they were recorded
+	applied  map[string]bool         // IDs of the corporate actions applied so far
+}
+
+func newProjection() *projection {
+	return &projection{
+		holdings: make(map[string]Stock),
+		settings: Settings{Amount: defaultBudget, NextBatchNumber: 1},
+		logs:     make(map[string]*loggedEntry),
+		applied:  make(map[string]bool),
+	}
+}
+
+func logKey(strategyKey, id string) string {
+	return strategyKey + "/" + id
+}
+
+func (p *projection) addLogs(logs []InvestmentLog) {
+	for _, l := range logs {
+		key := logKey(l.StrategyKey, l.ID)
+		if _, ok := p.logs[key]; !ok {
+			p.order = append(p.order, key)
+		}
+		p.logs[key] = &loggedEntry{Log: l}
+	}
+}
+
+// apply folds a single event into the projection.
+func (p *projection) apply(e Event) error {
+	switch e.Type {
+	case eventSnapshot:
+		var payload snapshotPayload
+		if err := e.decode(&payload); err != nil {
+			return err
+		}
+		p.holdings = make(map[string]Stock)
+		for _, s := range payload.Holdings {
+			p.holdings[s.Ticker] = s
+		}
+		p.settings = payload.Settings
+		p.addLogs(payload.Logs)
+	case eventStockAdded, eventStockUpdated, eventStockAnalyzed:
+		var stock Stock
+		if err := e.decode(&stock); err != nil {
+			return err
+		}
+		p.holdings[stock.Ticker] = stock
+	case eventStockDeleted:
+		var payload stockDeletedPayload
+		if err := e.decode(&payload); err != nil {
+			return err
+		}
+		delete(p.holdings, payload.Ticker)
+	case eventBudgetChanged, eventCostsChanged, eventDriftChanged, eventValueAvgChanged, eventWatchlist, eventRulesChanged, eventIndicators,
+		eventPriceBasis, eventBenchmark, eventExposureLimits:
+		if err := e.decode(&p.settings); err != nil {
+			return err
+		}
+	case eventAllocation:
+		var payload allocationPayload
+		if err := e.decode(&payload); err != nil {
+			return err
+		}
+		for _, s := range payload.Holdings {
+			p.holdings[s.Ticker] = s
+		}
+		p.settings = payload.Settings
+		p.addLogs(payload.Logs)
+	case eventActionApplied:
+		var payload corporateActionPayload
+		if err := e.decode(&payload); err != nil {
+			return err
+		}
+		if payload.Holding != nil {
+			p.holdings[payload.Holding.Ticker] = *payload.Holding
+		}
+		if payload.Settings != nil {
+			p.settings = *payload.Settings
+		}
+		p.applied[payload.Action.ID] = true
+	case eventLogDeleted, eventLogBatchDeleted:
+		var payload logDeletedPayload
+		if err := e.decode(&payload); err != nil {
+			return err
+		}
+		for _, id := range payload.IDs {
+			if entry, ok := p.logs[logKey(payload.Strategy, id)]; ok && entry.Deleted.IsZero() {
+				entry.Deleted = e.Timestamp
+			}
+		}
+	default:
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+	return nil
+}
+
+// replay builds a projection from events, which must be ordered oldest first.
+func replay(events []Event) (*projection, error) {
+	p := newProjection()
+	for _, e := range events {
+		if err := p.apply(e); err != nil {
+			return nil, fmt.Errorf("failed to apply event %s (%s): %w", e.ID, e.Type, err)
+		}
+	}
+	return p, nil
+}
+
+// liveLogs returns the logs that have not been deleted, newest batch first.
+func (p *projection) liveLogs() []InvestmentLog {
+	var logs []InvestmentLog
+	for _, key := range p.order {
+		if entry := p.logs[key]; entry.Deleted.IsZero() {
+			logs = append(logs, entry.Log)
+		}
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		if logs[i].Batch != logs[j].Batch {
+			return logs[i].Batch > logs[j].Batch
+		}
+		return logs[i].Timestamp.After(logs[j].Timestamp)
+	})
+	return logs
+}
+
+// logHistory replays every event and returns all logs ever recorded, including
+// deleted ones, ordered by their timestamp.
+func logHistory(ctx context.Context) ([]loggedEntry, error) {
+	events, err := loadEvents(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	p, err := replay(events)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]loggedEntry, 0, len(p.order))
+	for _, key := range p.order {
+		entries = append(entries, *p.logs[key])
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Log.Timestamp.Before(entries[j].Log.Timestamp) })
+	return entries, nil
+}
+
+// PortfolioState is the portfolio reconstructed as of a point in time.
+type PortfolioState struct {
+	AsOf     time.Time       `json:"asOf"`
+	Events   int             `json:"events"` // Number of events replayed
+	Holdings []Stock         `json:"holdings"`
+	Settings Settings        `json:"settings"`
+	Logs     []InvestmentLog `json:"logs"`
+}
+
+func (p *projection) state(asOf time.Time, events int) PortfolioState {
+	state := PortfolioState{AsOf: asOf, Events: events, Holdings: []Stock{}, Settings: p.settings, Logs: p.liveLogs()}
+	for _, s := range p.holdings {
+		state.Holdings = append(state.Holdings, s)
+	}
+	sort.Slice(state.Holdings, func(i, j int) bool { return state.Holdings[i].Ticker < state.Holdings[j].Ticker })
+	if state.Logs == nil {
+		state.Logs = []InvestmentLog{}
+	}
+	return state
+}
+
+// portfolioAsOf rebuilds the portfolio, settings and logs as they were at asOf.
+func portfolioAsOf(ctx context.Context, asOf time.Time) (PortfolioState, error) {
+	events, err := loadEvents(ctx, asOf)
+	if err != nil {
+		return PortfolioState{}, err
+	}
+	if len(events) == 0 {
+		return PortfolioState{}, fmt.Errorf("%w: no events recorded on or before %s", errNotFound, asOf.Format(time.RFC3339))
+	}
+	p, err := replay(events)
+	if err != nil {
+		return PortfolioState{}, err
+	}
+	return p.state(asOf, len(events)), nil
+}
+
+// replayer folds events into a projection step by step, for walking through time.
+type replayer struct {
+	events []Event
+	next   int
+	p      *projection
+}
+
+func newReplayer(events []Event) *replayer {
+	return &replayer{events: events, p: newProjection()}
+}
+
+// advance applies the events recorded up to t and returns the projection as of t.
+// Calls must move forward in time.
+func (r *replayer) advance(t time.Time) (*projection, error) {
+	for ; r.next < len(r.events) && !r.events[r.next].Timestamp.After(t); r.next++ {
+		e := r.events[r.next]
+		if err := r.p.apply(e); err != nil {
+			return nil, fmt.Errorf("failed to apply event %s (%s): %w", e.ID, e.Type, err)
+		}
+	}
+	return r.p, nil
+}
+
+// rebuildProjections replays every event and overwrites the portfolio, settings and
+// log collections with the result. Only the fields carried by events are written, so
+// data kept with a projection but left out of its events survives. The rebuild is
+// refused while eventGapsCollection holds changes that are missing from the stream.
+func rebuildProjections(ctx context.Context) (PortfolioState, error) {
+	gaps, err := countDocuments(ctx, firestoreClient.Collection(eventGapsCollection).Query)
+	if err != nil {
+		return PortfolioState{}, fmt.Errorf("failed to check the event stream for gaps: %w", err)
+	}
+	if gaps > 0 {
+		return PortfolioState{}, fmt.Errorf("%w: %d changes are missing from the event stream (see %s), so it cannot be replayed", errInvalidInput, gaps, eventGapsCollection)
+	}
+
+	now := time.Now()
+	state, err := portfolioAsOf(ctx, now)
+	if err != nil {
+		return state, err
+	}
+
+	current, err := listStocks(ctx)
+	if err != nil {
+		return state, err
+	}
+	projected := make(map[string]bool)
+	for _, s := range state.Holdings {
+		projected[s.Ticker] = true
+		if _, err := firestoreClient.Collection("portfolio").Doc(s.Ticker).Set(ctx, s, firestore.Merge(eventFields(s)...)); err != nil {
+			return state, fmt.Errorf("failed to write stock %s: %w", s.Ticker, err)
+		}
+	}
+	for _, s := range current {
+		if !projected[s.Ticker] {
+			if _, err := firestoreClient.Collection("portfolio").Doc(s.Ticker).Delete(ctx); err != nil {
+				return state, fmt.Errorf("failed to delete stock %s: %w", s.Ticker, err)
+			}
+		}
+	}
+
+	if _, err := settingsDoc().Set(ctx, state.Settings, firestore.Merge(eventFields(state.Settings)...)); err != nil {
+		return state, fmt.Errorf("failed to write settings: %w", err)
+	}
+
+	projectedLogs := make(map[string]bool)
+	for _, l := range state.Logs {
+		strategy, ok := state.Settings.findStrategy(l.StrategyKey)
+		if !ok {
+			continue
+		}
+		projectedLogs[logKey(l.StrategyKey, l.ID)] = true
+		if _, err := firestoreClient.Collection(strategy.Collection).Doc(l.ID).Set(ctx, l, firestore.Merge(eventFields(l)...)); err != nil {
+			return state, fmt.Errorf("failed to write log %s: %w", l.ID, err)
+		}
+	}
+	for _, strategy := range state.Settings.allStrategies() {
+		existing, err := loadLogs(ctx, strategy)
+		if err != nil {
+			return state, err
+		}
+		for _, l := range existing {
+			if !projectedLogs[logKey(strategy.Key, l.ID)] {
+				if _, err := firestoreClient.Collection(strategy.Collection).Doc(l.ID).Delete(ctx); err != nil {
+					return state, fmt.Errorf("failed to delete log %s: %w", l.ID, err)
+				}
+			}
+		}
+	}
+	return state, nil
+}
+
+// eventFields returns the Firestore paths of the fields of struct v that its events
+// carry, which are all but those left out of the JSON encoding.
+func eventFields(v any) []firestore.FieldPath {
+	t := reflect.TypeOf(v)
+	var paths []firestore.FieldPath
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("firestore"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		paths = append(paths, firestore.FieldPath{name})
+	}
+	return paths
+}
+
+// parseAsOf accepts either a date (meaning the end of that day, UTC) or an RFC 3339 timestamp.
+func parseAsOf(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		return d.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: at must be a date (YYYY-MM-DD) or an RFC 3339 timestamp", errInvalidInput)
+}
+
+func apiPortfolioAsOf(c *gin.Context) {
+	asOf := time.Now()
+	if s := c.Query("at"); s != "" {
+		var err error
+		if asOf, err = parseAsOf(s); err != nil {
+			respondError(c, err)
+			return
+		}
+	}
+
+	state, err := portfolioAsOf(c.Request.Context(), asOf)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// EventPage is a single page of results from GET /api/v1/events.
+type EventPage struct {
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+	Items  []Event `json:"items"`
+}
+
+func apiListEvents(c *gin.Context) {
+	page := EventPage{Limit: defaultLogPageSize, Items: []Event{}}
+	var err error
+	if s := c.Query("limit"); s != "" {
+		if page.Limit, err = strconv.Atoi(s); err != nil || page.Limit < 1 || page.Limit > maxLogPageSize {
+			badRequest(c, "limit must be between 1 and "+strconv.Itoa(maxLogPageSize))
+			return
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		if page.Offset, err = strconv.Atoi(s); err != nil || page.Offset < 0 {
+			badRequest(c, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	q := firestoreClient.Collection(eventsCollection).OrderBy("timestamp", firestore.Asc)
+	if page.Total, err = countDocuments(ctx, q); err != nil {
+		respondError(c, fmt.Errorf("failed to count events: %w", err))
+		return
+	}
+	events, err := readEvents(q.Offset(page.Offset).Limit(page.Limit).Documents(ctx))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if events != nil {
+		page.Items = events
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+func apiRebuildProjections(c *gin.Context) {
+	state, err := rebuildProjections(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Asset types of a CompanyProfile.
+const (
+	assetStock = "Stock"
+	assetETF   = "ETF"
+	assetFund  = "Fund"
+	assetADR   = "ADR"
+)
+
+// profileMaxAge is how old a profile gets before the analysis fetches it again.
+const profileMaxAge = 30 * 24 * time.Hour
+
+// CompanyProfile describes what a holding is, for the exposure breakdown.
+type CompanyProfile struct {
+	Sector    string    `json:"sector,omitempty"`
+	Industry  string    `json:"industry,omitempty"`
+	Country   string    `json:"country,omitempty"`   // ISO code, e.g. US
+	Currency  string    `json:"currency,omitempty"`  // Currency the stock trades in
+	AssetType string    `json:"assetType,omitempty"` // Stock, ETF, Fund or ADR
+	Updated   time.Time `json:"updated,omitempty"`   // Zero until the first analysis fetches it
+}
+
+// ExposureLimits are the shares of the portfolio value above which an exposure is
+// flagged, 0.25 = 25%. 0 means no limit.
+type ExposureLimits struct {
+	Holding    float64 `firestore:"holding" json:"holding" openapi:"min=0,max=1"`
+	Sector     float64 `firestore:"sector" json:"sector" openapi:"min=0,max=1"`
+	Country    float64 `firestore:"country" json:"country" openapi:"min=0,max=1"`
+	Currency   float64 `firestore:"currency" json:"currency" openapi:"min=0,max=1"`
+	AssetClass float64 `firestore:"assetClass" json:"assetClass" openapi:"min=0,max=1"`
+}
+
+// validate checks that every limit is a share between 0 and 1.
+func (l ExposureLimits) validate() error {
+	for _, v := range []float64{l.Holding, l.Sector, l.Country, l.Currency, l.AssetClass} {
+		if v < 0 || v > 1 || math.IsNaN(v) {
+			return fmt.Errorf("%w: exposure limits must be between 0 and 1", errInvalidInput)
+		}
+	}
+	return nil
+}
+
+// Exposure is the part of the portfolio value in one sector, country, etc.
+type Exposure struct {
+	Name      string   `json:"name"`
+	Value     float64  `json:"value"`
+	Weight    float64  `json:"weight"`    // Share of the portfolio value
+	Tickers   []string `json:"tickers"`   // The holdings that make it up
+	OverLimit bool     `json:"overLimit"` // The weight is above the limit of its breakdown
+}
+
+// ExposureBreakdown splits the portfolio value along one dimension.
+type ExposureBreakdown struct {
+	Dimension string  `json:"dimension"` // holding, sector, country, currency or assetClass
+	Title     string  `json:"title"`
+	Limit     float64 `json:"limit"` // 0 means no limit
+	// Herfindahl index of the weights: 1 when everything is in one exposure, 1/n when
+	// it is spread evenly over n
+	HHI       float64    `json:"hhi"`
+	Exposures []Exposure `json:"exposures"` // Largest first
+}
+
+// ExposureFlag is an exposure above its limit.
+type ExposureFlag struct {
+	Dimension string  `json:"dimension"`
+	Name      string  `json:"name"`
+	Weight    float64 `json:"weight"`
+	Limit     float64 `json:"limit"`
+}
+
+// ExposureReport breaks the value of the holdings down by holding, sector, country,
+// currency and asset class.
+type ExposureReport struct {
+	Value float64 `json:"value"` // Of the holdings at their last analysed prices
+	// Herfindahl index of the holdings' weights, and the number of equal holdings that
+	// would be as concentrated (1/HHI)
+	HHI               float64             `json:"hhi"`
+	EffectiveHoldings float64             `json:"effectiveHoldings"`
+	Limits            ExposureLimits      `json:"limits"`
+	Breakdowns        []ExposureBreakdown `json:"breakdowns"`
+	Flags             []ExposureFlag      `json:"flags"`
+	Unprofiled        []string            `json:"unprofiled"` // Holdings without a profile yet, shown as Unknown
+}
+
+// unknownExposure is the name of the exposure of holdings whose profile lacks a field.
+const unknownExposure = "Unknown"
+
+// exposureDimension is one of the ways the portfolio is broken down.
+type exposureDimension struct {
+	key, title string
+	limit      func(ExposureLimits) float64
+	name       func(Stock) string
+}
+
+var exposureDimensions = []exposureDimension{
+	{"holding", "Holding", func(l ExposureLimits) float64 { return l.Holding }, func(s Stock) string { return s.Ticker }},
+	{"sector", "Sector", func(l ExposureLimits) float64 { return l.Sector }, func(s Stock) string { return s.Profile.Sector }},
+	{"country", "Country", func(l ExposureLimits) float64 { return l.Country }, func(s Stock) string { return s.Profile.Country }},
+	{"currency", "Currency", func(l ExposureLimits) float64 { return l.Currency }, func(s Stock) string { return s.Profile.Currency }},
+	{"assetClass", "Asset Class", func(l ExposureLimits) float64 { return l.AssetClass }, func(s Stock) string { return s.Profile.AssetType }},
+}
+
+// measureExposure breaks the value of stocks down along every dimension and flags the
+// exposures above limits.
+func measureExposure(stocks []Stock, limits ExposureLimits) ExposureReport {
+	report := ExposureReport{Value: holdingsValue(stocks), Limits: limits, Breakdowns: []ExposureBreakdown{}, Flags: []ExposureFlag{}, Unprofiled: []string{}}
+	for _, s := range stocks {
+		if s.Profile.Updated.IsZero() && marketValue(s) > 0 {
+			report.Unprofiled = append(report.Unprofiled, s.Ticker)
+		}
+	}
+	sort.Strings(report.Unprofiled)
+
+	for _, dim := range exposureDimensions {
+		b := ExposureBreakdown{Dimension: dim.key, Title: dim.title, Limit: dim.limit(limits), Exposures: []Exposure{}}
+		byName := make(map[string]*Exposure)
+		for _, s := range stocks {
+			value := marketValue(s)
+			if value <= 0 {
+				continue
+			}
+			name := strings.TrimSpace(dim.name(s))
+			if name == "" {
+				name = unknownExposure
+			}
+			e, ok := byName[name]
+			if !ok {
+				e = &Exposure{Name: name}
+				byName[name] = e
+			}
+			e.Value += value
+			e.Tickers = append(e.Tickers, s.Ticker)
+		}
+		for _, e := range byName {
+			if report.Value > 0 {
+				e.Weight = e.Value / report.Value
+			}
+			b.HHI += e.Weight * e.Weight
+			e.OverLimit = b.Limit > 0 && e.Weight > b.Limit
+			sort.Strings(e.Tickers)
+			b.Exposures = append(b.Exposures, *e)
+		}
+		sort.Slice(b.Exposures, func(i, j int) bool {
+			if b.Exposures[i].Value != b.Exposures[j].Value {
+				return b.Exposures[i].Value > b.Exposures[j].Value
+			}
+			return b.Exposures[i].Name < b.Exposures[j].Name
+		})
+		for _, e := range b.Exposures {
+			if e.OverLimit {
+				report.Flags = append(report.Flags, ExposureFlag{Dimension: dim.key, Name: e.Name, Weight: e.Weight, Limit: b.Limit})
+			}
+		}
+		report.Breakdowns = append(report.Breakdowns, b)
+	}
+
+	report.HHI = report.Breakdowns[0].HHI
+	if report.HHI > 0 {
+		report.EffectiveHoldings = 1 / report.HHI
+	}
+	return report
+}
+
+// exposureReport measures the exposure of the current holdings against the limits in
+// the settings.
+func exposureReport(ctx context.Context) (ExposureReport, error) {
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return ExposureReport{}, err
+	}
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return ExposureReport{}, err
+	}
+	return measureExposure(stocks, settings.ExposureLimits), nil
+}
+
+func apiExposure(c *gin.Context) {
+	report, err := exposureReport(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func showExposurePage(c *gin.Context) {
+	report, err := exposureReport(c.Request.Context())
+	if err != nil {
+		log.Printf("Failed to measure exposure: %v", err)
+		c.String(formErrorStatus(err), "Failed to measure exposure: %v", err)
+		return
+	}
+	c.HTML(http.StatusOK, "exposure.tmpl.html", gin.H{"report": report})
+}
+
+// handleUpdateExposureLimits saves the limits entered on the exposure page, as
+// percentages.
+func handleUpdateExposureLimits(c *gin.Context) {
+	limit := func(field string) float64 {
+		v, _ := strconv.ParseFloat(strings.Replace(c.PostForm(field), ",", ".", -1), 64)
+		return v / 100
+	}
+	limits := ExposureLimits{
+		Holding:    limit("holding"),
+		Sector:     limit("sector"),
+		Country:    limit("country"),
+		Currency:   limit("currency"),
+		AssetClass: limit("assetClass"),
+	}
+	if _, err := updateExposureLimits(c.Request.Context(), limits); err != nil {
+		log.Printf("Failed to update exposure limits: %v", err)
+		c.String(formErrorStatus(err), "Failed to update exposure limits: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/exposure")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// holding is a profiled stock worth value, priced at 1.
+func holding(ticker string, value float64, sector, country string) Stock {
+	return Stock{Ticker: ticker, Quantity: value, CurrentPrice: 1, Profile: CompanyProfile{
+		Sector: sector, Country: country, Currency: "USD", AssetType: assetStock, Updated: time.Now(),
+	}}
+}
+
+func TestMeasureExposureHHI(t *testing.T) {
+	equal := func(n int) []Stock {
+		stocks := make([]Stock, n)
+		for i := range stocks {
+			stocks[i] = holding(fmt.Sprintf("T%d", i), 100, fmt.Sprintf("Sector %d", i%2), "US")
+		}
+		return stocks
+	}
+	tests := []struct {
+		name                string
+		stocks              []Stock
+		wantHHI, wantSector float64
+	}{
+		{"one holding", equal(1), 1, 1},
+		{"2 equal holdings", equal(2), 1.0 / 2, 1.0 / 2},
+		{"4 equal holdings", equal(4), 1.0 / 4, 1.0 / 2},
+		{"10 equal holdings", equal(10), 1.0 / 10, 1.0 / 2},
+		// Weights of 0.75 and 0.25
+		{"3 to 1", []Stock{holding("A", 300, "Tech", "US"), holding("B", 100, "Tech", "US")}, 0.75*0.75 + 0.25*0.25, 1},
+		{"holdings sold out don't count", append(equal(2), holding("C", 0, "Energy", "US")), 1.0 / 2, 1.0 / 2},
+		{"nothing held", nil, 0, 0},
+	}
+	for _, tt := range tests {
+		r := measureExposure(tt.stocks, ExposureLimits{})
+		if math.Abs(r.HHI-tt.wantHHI) > 1e-9 {
+			t.Errorf("%s: HHI = %v, want %v", tt.name, r.HHI, tt.wantHHI)
+		}
+		if tt.wantHHI > 0 && math.Abs(r.EffectiveHoldings-1/tt.wantHHI) > 1e-9 {
+			t.Errorf("%s: effective holdings = %v, want %v", tt.name, r.EffectiveHoldings, 1/tt.wantHHI)
+		}
+		if got := r.Breakdowns[1].HHI; r.Breakdowns[1].Dimension != "sector" || math.Abs(got-tt.wantSector) > 1e-9 {
+			t.Errorf("%s: %s HHI = %v, want sector HHI %v", tt.name, r.Breakdowns[1].Dimension, got, tt.wantSector)
+		}
+		if len(r.Flags) != 0 {
+			t.Errorf("%s: flags %v without limits", tt.name, r.Flags)
+		}
+	}
+}
+
+func TestMeasureExposureFlags(t *testing.T) {
+	stocks := []Stock{
+		holding("AAA", 500, "Tech", "US"),
+		holding("BBB", 300, "Tech", "DE"),
+		holding("CCC", 200, "Energy", "US"),
+		// Valued at its purchase price until it is analysed, and not profiled yet
+		{Ticker: "DDD", Quantity: 10, Price: 10},
+	}
+	tests := []struct {
+		name   string
+		limits ExposureLimits
+		want   []ExposureFlag
+	}{
+		{"no limits", ExposureLimits{}, []ExposureFlag{}},
+		{"holding limit", ExposureLimits{Holding: 0.25}, []ExposureFlag{
+			{Dimension: "holding", Name: "AAA", Weight: 500.0 / 1100, Limit: 0.25},
+			{Dimension: "holding", Name: "BBB", Weight: 300.0 / 1100, Limit: 0.25},
+		}},
+		// Exactly at the limit isn't over it
+		{"at the limit", ExposureLimits{Country: 700.0 / 1100}, []ExposureFlag{}},
+		{"several breakdowns", ExposureLimits{Sector: 0.5, Country: 0.5, Currency: 1, AssetClass: 0.05}, []ExposureFlag{
+			{Dimension: "sector", Name: "Tech", Weight: 800.0 / 1100, Limit: 0.5},
+			{Dimension: "country", Name: "US", Weight: 700.0 / 1100, Limit: 0.5},
+			{Dimension: "assetClass", Name: assetStock, Weight: 1000.0 / 1100, Limit: 0.05},
+			{Dimension: "assetClass", Name: unknownExposure, Weight: 100.0 / 1100, Limit: 0.05},
+		}},
+	}
+	for _, tt := range tests {
+		r := measureExposure(stocks, tt.limits)
+		if !reflect.DeepEqual(r.Flags, tt.want) {
+			t.Errorf("%s: flags %+v, want %+v", tt.name, r.Flags, tt.want)
+		}
+		for _, b := range r.Breakdowns {
+			for _, e := range b.Exposures {
+				if e.OverLimit != (b.Limit > 0 && e.Weight > b.Limit) {
+					t.Errorf("%s: %s %s over limit %v at weight %v and limit %v", tt.name, b.Dimension, e.Name, e.OverLimit, e.Weight, b.Limit)
+				}
+			}
+		}
+	}
+
+	r := measureExposure(stocks, ExposureLimits{})
+	if !reflect.DeepEqual(r.Unprofiled, []string{"DDD"}) {
+		t.Errorf("unprofiled = %v, want [DDD]", r.Unprofiled)
+	}
+	sectors := r.Breakdowns[1].Exposures
+	if len(sectors) != 3 || sectors[0].Name != "Tech" || !reflect.DeepEqual(sectors[0].Tickers, []string{"AAA", "BBB"}) || sectors[2].Name != unknownExposure {
+		t.Errorf("sectors = %+v, want Tech (AAA, BBB), Energy and Unknown, largest first", sectors)
+	}
+}
+
+func TestExposureLimitsValidate(t *testing.T) {
+	tests := []struct {
+		limits ExposureLimits
+		valid  bool
+	}{
+		{ExposureLimits{}, true},
+		{ExposureLimits{Holding: 0.1, Sector: 0.3, Country: 0.6, Currency: 0.8, AssetClass: 1}, true},
+		{ExposureLimits{Holding: -0.1}, false},
+		{ExposureLimits{Sector: 1.5}, false},
+		{ExposureLimits{Currency: math.NaN()}, false},
+	}
+	for _, tt := range tests {
+		err := tt.limits.validate()
+		if valid := err == nil; valid != tt.valid || (err != nil && !errors.Is(err, errInvalidInput)) {
+			t.Errorf("validate(%+v) = %v, want valid %v", tt.limits, err, tt.valid)
+		}
+	}
+}
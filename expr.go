@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A small expression language for user-defined strategy rules, e.g.
+//
+//	max(0, (ma200 - price) / ma200) * (ema_trend > 0 ? 1.5 : 1)
+//
+// An expression is parsed once into a tree and then evaluated for every stock. It can
+// only read the variables it was compiled with and call the pure math functions in
+// exprFuncs: there are no assignments, loops or access to anything else, and the size
+// limits bound the work a single evaluation can do.
+//
+// Every value is a float64. Comparisons and logical operators return 1 for true and
+// 0 for false, and any value other than 0 or NaN counts as true. Operators, from the
+// lowest precedence to the highest: ?:, ||, &&, == !=, < <= > >=, + -, * / %, and the
+// unary - and !.
+
+const (
+	maxExprLength = 1000 // Characters
+	maxExprDepth  = 50   // Nesting of operators, calls and parentheses
+)
+
+// exprFunc is a function callable from expressions.
+type exprFunc struct {
+	minArgs, maxArgs int // maxArgs < 0 means no limit
+	call             func(args []float64) float64
+}
+
+var exprFuncs = map[string]exprFunc{
+	"min": {1, -1, func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	}},
+	"max": {1, -1, func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	}},
+	"abs":   {1, 1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, 1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"log":   {1, 1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"exp":   {1, 1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"pow":   {2, 2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"clamp": {3, 3, func(a []float64) float64 { return math.Max(a[1], math.Min(a[2], a[0])) }},
+}
+
+// expr is a compiled expression. Variables are resolved to positions when compiling,
+// so evaluating only indexes into the values passed to eval.
+type expr interface {
+	eval(vars []float64) float64
+}
+
+type numberExpr float64
+
+type varExpr int
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op   string
+	x, y expr
+}
+
+type condExpr struct {
+	cond, then, otherwise expr
+}
+
+type callExpr struct {
+	fn   exprFunc
+	args []expr
+}
+
+func truthy(v float64) bool {
+	return v != 0 && !math.IsNaN(v)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e numberExpr) eval([]float64) float64 { return float64(e) }
+
+func (e varExpr) eval(vars []float64) float64 { return vars[e] }
+
+func (e unaryExpr) eval(vars []float64) float64 {
+	if e.op == "!" {
+		return boolValue(!truthy(e.x.eval(vars)))
+	}
+	return -e.x.eval(vars)
+}
+
+func (e binaryExpr) eval(vars []float64) float64 {
+	x := e.x.eval(vars)
+	// The logical operators only evaluate their right side when it matters
+	switch e.op {
+	case "&&":
+		return boolValue(truthy(x) && truthy(e.y.eval(vars)))
+	case "||":
+		return boolValue(truthy(x) || truthy(e.y.eval(vars)))
+	}
+	y := e.y.eval(vars)
+	switch e.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		return x / y
+	case "%":
+		return math.Mod(x, y)
+	case "<":
+		return boolValue(x < y)
+	case "<=":
+		return boolValue(x <= y)
+	case ">":
+		return boolValue(x > y)
+	case ">=":
+		return boolValue(x >= y)
+	case "==":
+		return boolValue(x == y)
+	case "!=":
+		return boolValue(x != y)
+	}
+	return math.NaN()
+}
+
+func (e condExpr) eval(vars []float64) float64 {
+	if truthy(e.cond.eval(vars)) {
+		return e.then.eval(vars)
+	}
+	return e.otherwise.eval(vars)
+}
+
+func (e callExpr) eval(vars []float64) float64 {
+	args := make([]float64, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.eval(vars)
+	}
+	return e.fn.call(args)
+}
+
+// token is a lexical token of an expression.
+type token struct {
+	kind  byte // 'n' number, 'i' identifier, 'o' operator or punctuation, 0 end
+	text  string
+	value float64
+	pos   int
+}
+
+// twoCharOps are the operators made of two characters; they are matched before the
+// single-character ones.
+var twoCharOps = []string{"<=", ">=", "==", "!=", "&&", "||"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				i++
+				if i < len(src) && (src[i] == '+' || src[i] == '-') {
+					i++
+				}
+				for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+					i++
+				}
+			}
+			v, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", src[start:i], start+1)
+			}
+			tokens = append(tokens, token{kind: 'n', text: src[start:i], value: v, pos: start})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, token{kind: 'i', text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, two := range twoCharOps {
+				if strings.HasPrefix(src[i:], two) {
+					op = two
+				}
+			}
+			if op == "" && strings.IndexByte("+-*/%<>!?:(),", c) >= 0 {
+				op = string(c)
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+			}
+			tokens = append(tokens, token{kind: 'o', text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{pos: len(src)}), nil
+}
+
+// exprParser is a recursive descent parser over the tokens of one expression.
+type exprParser struct {
+	tokens []token
+	next   int
+	vars   map[string]int
+	depth  int
+}
+
+// binaryLevels are the binary operators by increasing precedence.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// compileExpr parses src into an expression that may use the variables in names. The
+// values passed to eval must be in the same order as names.
+func compileExpr(src string, names []string) (expr, error) {
+	if len(src) > maxExprLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExprLength)
+	}
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, vars: make(map[string]int, len(names))}
+	for i, name := range names {
+		p.vars[name] = i
+	}
+	e, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != 0 {
+		return nil, p.unexpected(t)
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == 'o' && t.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) unexpected(t token) error {
+	if t.kind == 0 {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %d", t.text, t.pos+1)
+}
+
+// enter guards against expressions nested deeply enough to exhaust the stack.
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.depth > maxExprDepth {
+		return fmt.Errorf("expression is nested more than %d levels deep", maxExprDepth)
+	}
+	return nil
+}
+
+func (p *exprParser) parseCond() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	cond, err := p.parseBinary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	then, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(":") {
+		return nil, p.unexpected(p.peek())
+	}
+	otherwise, err := p.parseCond()
+	if err != nil {
+		return nil, err
+	}
+	return condExpr{cond, then, otherwise}, nil
+}
+
+func (p *exprParser) parseBinary(level int) (expr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != 'o' || !slices.Contains(binaryLevels[level], t.text) {
+			return x, nil
+		}
+		p.next++
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{t.text, x, y}
+	}
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	for _, op := range []string{"-", "!", "+"} {
+		if p.accept(op) {
+			x, err := p.parseUnary()
+			if err != nil || op == "+" {
+				return x, err
+			}
+			return unaryExpr{op, x}, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == 'n':
+		p.next++
+		return numberExpr(t.value), nil
+	case t.kind == 'i':
+		p.next++
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		switch t.text {
+		case "true":
+			return numberExpr(1), nil
+		case "false":
+			return numberExpr(0), nil
+		}
+		i, ok := p.vars[t.text]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %q at position %d", t.text, t.pos+1)
+		}
+		return varExpr(i), nil
+	case p.accept("("):
+		e, err := p.parseCond()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, p.unexpected(p.peek())
+		}
+		return e, nil
+	}
+	return nil, p.unexpected(t)
+}
+
+// parseCall parses the arguments of a call to the function named by t, whose opening
+// parenthesis has been consumed.
+func (p *exprParser) parseCall(t token) (expr, error) {
+	fn, ok := exprFuncs[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", t.text, t.pos+1)
+	}
+	var args []expr
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseCond()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, p.unexpected(p.peek())
+			}
+		}
+	}
+	if len(args) < fn.minArgs || fn.maxArgs >= 0 && len(args) > fn.maxArgs {
+		return nil, fmt.Errorf("wrong number of arguments to %s at position %d", t.text, t.pos+1)
+	}
+	return callExpr{fn, args}, nil
+}
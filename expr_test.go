@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCompileExprPrecedence(t *testing.T) {
+	names := []string{"price", "ma200"}
+	vars := []float64{90, 100}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"2 * 3 % 4", 2},
+		{"-2 * 3", -6},
+		{"!0 + 1", 2},
+		{"1 + 2 > 2", 1},
+		{"1 < 2 == 1", 1},
+		{"1 || 0 && 0", 1},
+		{"0 && 1 || 1", 1},
+		{"0 ? 1 : 0 ? 2 : 3", 3},
+		{"1 ? 0 ? 4 : 5 : 6", 5},
+		{"price < ma200 ? (ma200 - price) / ma200 : 0", 0.1},
+		{"max(0, min(1, 2), clamp(5, 0, 3)) + pow(2, 3)", 11},
+		{"1.5e1 + .5", 15.5},
+		{"true + false", 1},
+	}
+	for _, tt := range tests {
+		e, err := compileExpr(tt.src, names)
+		if err != nil {
+			t.Errorf("compileExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if got := e.eval(vars); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"1 2", `unexpected "2" at position 3`},
+		{"(1", "unexpected end of expression"},
+		{"1)", `unexpected ")" at position 2`},
+		{"1 ? 2", "unexpected end of expression"},
+		{"1 ? 2 , 3", `unexpected "," at position 7`},
+		{"1 # 2", `unexpected character '#' at position 3`},
+		{"1..2", `invalid number "1..2" at position 1`},
+		{"quantity", `unknown variable "quantity" at position 1`},
+		{"median(1)", `unknown function "median" at position 1`},
+		{"pow(1)", "wrong number of arguments to pow at position 1"},
+		{"min()", "wrong number of arguments to min at position 1"},
+		{"max(1,)", `unexpected ")" at position 7`},
+		{strings.Repeat("(", 30) + "1" + strings.Repeat(")", 30), "nested more than 50 levels deep"},
+		{strings.Repeat("-", 60) + "1", "nested more than 50 levels deep"},
+		{strings.Repeat("1+", maxExprLength/2) + "1", "longer than 1000 characters"},
+	}
+	for _, tt := range tests {
+		_, err := compileExpr(tt.src, []string{"price"})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("compileExpr(%.20q) error = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestCompileExprLimits(t *testing.T) {
+	// Just inside the limits
+	nested := strings.Repeat("(", 20) + "1" + strings.Repeat(")", 20)
+	long := strings.Repeat("1+", maxExprLength/2-1) + "1"
+	for _, src := range []string{nested, long} {
+		if _, err := compileExpr(src, nil); err != nil {
+			t.Errorf("compileExpr(%.20q): %v", src, err)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens, err := tokenize("a<=b&&!c1 ? 1.5e-3:-x_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, tok := range tokens {
+		texts = append(texts, tok.text)
+	}
+	want := []string{"a", "<=", "b", "&&", "!", "c1", "?", "1.5e-3", ":", "-", "x_2", ""}
+	if strings.Join(texts, " ") != strings.Join(want, " ") {
+		t.Errorf("tokenize = %q, want %q", texts, want)
+	}
+	if last := tokens[len(tokens)-1]; last.kind != 0 || last.pos != 23 {
+		t.Errorf("last token = %+v, want the end at position 23", last)
+	}
+	if tokens[7].value != 0.0015 {
+		t.Errorf("number = %v, want 0.0015", tokens[7].value)
+	}
+}
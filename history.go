@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// valuation is what a strategy, or the real portfolio, was worth on a date and the
+// money put into it up to then.
+type valuation struct {
+	Date time.Time
+	// Holdings at the last close, plus the proceeds of the sales and the dividends
+	// received, kept as cash
+	Value    float64
+	Invested float64 // Net contributions
+}
+
+// contribution is money put into (or, when negative, taken out of) a strategy or the
+// real portfolio at a moment.
+type contribution struct {
+	Time   time.Time
+	Amount float64
+}
+
+// History resolutions.
+const (
+	resolutionDaily   = "daily"
+	resolutionWeekly  = "weekly"
+	resolutionMonthly = "monthly"
+)
+
+// priceLeadDays is how many days of prices are fetched before the start of a history,
+// so its first dates have a last close even after a long weekend or holiday.
+const priceLeadDays = 10
+
+// historyRange is the period and resolution of a history.
+type historyRange struct {
+	start, end time.Time // Zero for the first log and today
+	resolution string    // Defaults to weekly
+}
+
+// parseHistoryRange reads a range from the start and end dates (YYYY-MM-DD, optional)
+// and resolution given to the history and performance endpoints.
+func parseHistoryRange(start, end, resolution string) (historyRange, error) {
+	r := historyRange{resolution: resolution}
+	var err error
+	if start != "" {
+		if r.start, err = time.Parse("2006-01-02", start); err != nil {
+			return r, fmt.Errorf("%w: start must be a date in YYYY-MM-DD format", errInvalidInput)
+		}
+	}
+	if end != "" {
+		if r.end, err = time.Parse("2006-01-02", end); err != nil {
+			return r, fmt.Errorf("%w: end must be a date in YYYY-MM-DD format", errInvalidInput)
+		}
+	}
+	switch r.resolution {
+	case "":
+		r.resolution = resolutionWeekly
+	case resolutionDaily, resolutionWeekly, resolutionMonthly:
+	default:
+		return r, fmt.Errorf("%w: resolution must be daily, weekly or monthly", errInvalidInput)
+	}
+	if !r.start.IsZero() && !r.end.IsZero() && r.end.Before(r.start) {
+		return r, fmt.Errorf("%w: end must not be before start", errInvalidInput)
+	}
+	return r, nil
+}
+
+// endOfDay is the last instant of t's day in UTC, so a valuation on that day counts
+// everything logged during it.
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, time.UTC)
+}
+
+// dates returns the valuation dates of the range, each at the end of its day: every
+// weekday, every Friday or the last weekday of every month.
+func (r historyRange) dates() []time.Time {
+	weekend := func(d time.Time) bool { return d.Weekday() == time.Saturday || d.Weekday() == time.Sunday }
+	var dates []time.Time
+	for d := endOfDay(r.start); !d.After(r.end); d = d.AddDate(0, 0, 1) {
+		if weekend(d) {
+			continue
+		}
+		switch r.resolution {
+		case resolutionWeekly:
+			if d.Weekday() != time.Friday {
+				continue
+			}
+		case resolutionMonthly:
+			next := d.AddDate(0, 0, 1)
+			for weekend(next) {
+				next = next.AddDate(0, 0, 1)
+			}
+			if next.Month() == d.Month() {
+				continue
+			}
+		}
+		dates = append(dates, d)
+	}
+	return dates
+}
+
+// historyOptions choose what buildPortfolioHistory rebuilds.
+type historyOptions struct {
+	historyRange
+	strategies []Strategy // One series per strategy, from its logs
+	actual     bool       // Also replay the real portfolio from the event stream
+	benchmark  string     // Ticker to compare the series with, if any
+}
+
+// portfolioHistory is the value of each strategy over time, rebuilt from its logs, and
+// optionally of the real portfolio, rebuilt from the event stream.
+type portfolioHistory struct {
+	dates      []time.Time
+	strategies []Strategy
+	series     map[string][]valuation // By strategy key, one per date
+	// The real holdings at their cost basis. Only set when asked for and the event
+	// stream could be replayed.
+	actual []valuation
+	// The contributions behind each series, oldest first: by strategy key from the
+	// logged purchases, and for the real portfolio from the changes of its cost basis
+	flows       map[string][]contribution
+	actualFlows []contribution
+	// The benchmark ticker and its prices, if there is one
+	benchmark       string
+	benchmarkPrices priceSeries
+}
+
+// buildPortfolioHistory values the logs of each strategy on the dates of the range, by
+// default every Friday from the first of them to today. Logs count from their timestamp
+// until they were deleted. Purchases add shares and are the contributions; sales take
+// away at most the shares held and keep their proceeds as cash, like the dividends the
+// shares were paid. Each date uses the last close on or before it, so holidays take the
+// previous close.
+func buildPortfolioHistory(ctx context.Context, opts historyOptions) (portfolioHistory, error) {
+	history := portfolioHistory{strategies: opts.strategies, series: make(map[string][]valuation), flows: make(map[string][]contribution), benchmark: opts.benchmark}
+	keys := make(map[string]bool)
+	for _, s := range opts.strategies {
+		keys[s.Key] = true
+	}
+
+	// 1. Replay the event stream to find every log of the strategies, including logs
+	// that were deleted later (they still count until their deletion)
+	entries, err := logHistory(ctx)
+	if err != nil {
+		return history, err
+	}
+	var allLogs []loggedEntry
+	for _, entry := range entries {
+		if keys[entry.Log.StrategyKey] {
+			allLogs = append(allLogs, entry)
+		}
+	}
+	if len(allLogs) == 0 {
+		return history, nil
+	}
+	if opts.start.IsZero() {
+		opts.start = allLogs[0].Log.Timestamp
+	}
+	if now := time.Now(); opts.end.IsZero() || opts.end.After(now) {
+		opts.end = now
+	}
+	opts.end = endOfDay(opts.end)
+
+	// A purchase puts its cost in when it is logged and takes it out again if it is
+	// deleted. Sales leave their proceeds in the strategy, so they are not contributions.
+	for _, entry := range allLogs {
+		if entry.Log.QuantityBought < 0 {
+			continue
+		}
+		key, amount := entry.Log.StrategyKey, entry.Log.InvestmentAmount+entry.Log.Fees
+		history.flows[key] = append(history.flows[key], contribution{Time: entry.Log.Timestamp, Amount: amount})
+		if !entry.Deleted.IsZero() {
+			history.flows[key] = append(history.flows[key], contribution{Time: entry.Deleted, Amount: -amount})
+		}
+	}
+	for _, flows := range history.flows {
+		sort.SliceStable(flows, func(i, j int) bool { return flows[i].Time.Before(flows[j].Time) })
+	}
+
+	// 2. Get all unique tickers
+	tickers := make(map[string]bool)
+	for _, entry := range allLogs {
+		tickers[entry.Log.Ticker] = true
+	}
+
+	// 3. Fetch the daily prices covering the range
+	priceHistory := make(map[string]priceSeries)
+	pricesFor := func(ticker string) priceSeries {
+		if _, ok := priceHistory[ticker]; !ok {
+			prices, err := fetchPriceHistory(ticker, opts.start.AddDate(0, 0, -priceLeadDays), opts.end)
+			if err != nil {
+				log.Printf("Could not fetch the prices of %s: %v", ticker, err)
+			}
+			priceHistory[ticker] = newPriceSeries(prices)
+		}
+		return priceHistory[ticker]
+	}
+	for ticker := range tickers {
+		pricesFor(ticker)
+	}
+	if opts.benchmark != "" {
+		history.benchmarkPrices = pricesFor(opts.benchmark)
+		if len(history.benchmarkPrices) == 0 {
+			log.Printf("No prices for the benchmark %s", opts.benchmark)
+		}
+	}
+
+	// The provider's prices are adjusted for every split, so the shares bought before
+	// a split are scaled up to match them
+	splits, err := appliedSplits(ctx)
+	if err != nil {
+		log.Printf("Failed to load splits: %v", err)
+	}
+
+	// Dividends paid to the logged shares are added to the strategies' values as cash
+	dividends := make(map[string][]CorporateAction)
+	for ticker := range tickers {
+		paid, err := fetchDividends(ticker, allLogs[0].Log.Timestamp)
+		if err != nil {
+			log.Printf("Could not fetch the dividends of %s: %v", ticker, err)
+			continue
+		}
+		dividends[ticker] = paid
+	}
+	payouts := strategyDividends(allLogs, dividends, splits)
+
+	var actual *replayer
+	if opts.actual {
+		events, err := loadEvents(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to load events for the real portfolio: %v", err)
+		} else {
+			actual = newReplayer(events)
+			if history.actualFlows, err = costBasisChanges(events); err != nil {
+				log.Printf("Failed to replay holdings: %v", err)
+				actual = nil
+			}
+		}
+	}
+
+	// 4. Reconstruct the values over time
+	history.dates = opts.dates()
+	history.series = valueStrategies(opts.strategies, history.dates, logChanges(allLogs), payouts, priceHistory, splits)
+	if actual == nil {
+		return history, nil
+	}
+	for _, d := range history.dates {
+		p, err := actual.advance(d)
+		if err != nil {
+			log.Printf("Failed to replay holdings: %v", err)
+			history.actual = nil
+			break
+		}
+		// What went into the real holdings is their cost basis, which includes any
+		// dividends reinvested under DRIP
+		v := valuation{Date: d}
+		for _, s := range p.holdings {
+			v.Value += s.Quantity * splits.factorExcept(s.Ticker, p.applied) * pricesFor(s.Ticker).closeOn(d)
+			v.Invested += s.Quantity * s.Price
+		}
+		history.actual = append(history.actual, v)
+	}
+	return history, nil
+}
+
+// logChange is a log being recorded (sign 1) or deleted again (sign -1). entry tells
+// the changes of one log apart.
+type logChange struct {
+	at    time.Time
+	log   InvestmentLog
+	sign  float64
+	entry int
+}
+
+// logChanges returns the recording and deletion of each log, oldest first. A log counts
+// from its timestamp until it is deleted: it adds its shares and cash when it is
+// recorded and takes them away again on deletion. Logs deleted as soon as they were
+// recorded never count.
+func logChanges(entries []loggedEntry) []logChange {
+	var changes []logChange
+	for i, entry := range entries {
+		if !entry.Deleted.IsZero() && !entry.Deleted.After(entry.Log.Timestamp) {
+			continue
+		}
+		changes = append(changes, logChange{at: entry.Log.Timestamp, log: entry.Log, sign: 1, entry: i})
+		if !entry.Deleted.IsZero() {
+			changes = append(changes, logChange{at: entry.Deleted, log: entry.Log, sign: -1, entry: i})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].at.Before(changes[j].at) })
+	return changes
+}
+
+// valueStrategies values each of strategies on each of dates (oldest
+// first), at the last close on or before the date, plus the proceeds of their sales and
+// the dividends paid out by then as cash. Walking through changes in order carries the
+// holdings from one date to the next, so each log is applied once however many dates
+// there are. It only reads its arguments.
+func valueStrategies(strategies []Strategy, dates []time.Time, changes []logChange, payouts []dividendPayout, prices map[string]priceSeries, splits splitHistory) map[string][]valuation {
+	series := make(map[string][]valuation, len(strategies))
+	holdings := make(map[string]map[string]float64)
+	invested := make(map[string]float64)
+	cash := make(map[string]float64)
+	// A sell can't take more than the strategy holds, so the share of it that was
+	// executed is kept for its deletion to give back
+	executed := make(map[int]float64)
+	for _, d := range dates {
+		// Apply the logs recorded and deleted up to this day, per strategy, with the cash
+		// they took. Sells are logged with negative quantities and amounts, and bring in
+		// their amount less the fees. Like in the backtest, a sell is capped at the
+		// quantity held and its proceeds are scaled down with it.
+		for ; len(changes) > 0 && !changes[0].at.After(d); changes = changes[1:] {
+			c := changes[0]
+			l := c.log
+			if holdings[l.StrategyKey] == nil {
+				holdings[l.StrategyKey] = make(map[string]float64)
+			}
+			qty := l.QuantityBought * splits.factor(l.Ticker, l.Timestamp)
+			if qty >= 0 {
+				holdings[l.StrategyKey][l.Ticker] += c.sign * qty
+				invested[l.StrategyKey] += c.sign * (l.InvestmentAmount + l.Fees)
+				continue
+			}
+			if c.sign > 0 {
+				executed[c.entry] = math.Min(-qty, math.Max(holdings[l.StrategyKey][l.Ticker], 0)) / -qty
+			}
+			holdings[l.StrategyKey][l.Ticker] += c.sign * executed[c.entry] * qty
+			cash[l.StrategyKey] -= c.sign * executed[c.entry] * (l.InvestmentAmount + l.Fees)
+		}
+
+		for ; len(payouts) > 0 && payouts[0].date <= d.Format("2006-01-02"); payouts = payouts[1:] {
+			cash[payouts[0].strategy] += payouts[0].cash
+		}
+
+		for _, s := range strategies {
+			v := valuation{Date: d, Value: cash[s.Key], Invested: invested[s.Key]}
+			for ticker, qty := range holdings[s.Key] {
+				v.Value += qty * prices[ticker].closeOn(d)
+			}
+			series[s.Key] = append(series[s.Key], v)
+		}
+	}
+	return series
+}
+
+// costBasisChanges replays events and returns the change of the holdings' cost basis
+// at every event that moved it: what went into the real portfolio, and when.
+func costBasisChanges(events []Event) ([]contribution, error) {
+	r := newReplayer(events)
+	var flows []contribution
+	var basis float64
+	for _, e := range events {
+		p, err := r.advance(e.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		var now float64
+		for _, s := range p.holdings {
+			now += s.Quantity * s.Price
+		}
+		if math.Abs(now-basis) > 1e-9 {
+			flows = append(flows, contribution{Time: e.Timestamp, Amount: now - basis})
+		}
+		basis = now
+	}
+	return flows, nil
+}
+
+// benchmarkFor simulates putting the contributions behind series, flows, into the
+// benchmark instead. The value of the first valuation, like everything invested before
+// the range, buys the benchmark at its close that day; each later contribution buys (or
+// sells) it at the last close on or before its own day. The simulation is valued at the dates of series, at the benchmark's adjusted closes,
+// so its dividends are reinvested. Money that arrives before the first benchmark price
+// waits as cash. Returns nil without a benchmark.
+func (h portfolioHistory) benchmarkFor(series []valuation, flows []contribution) []valuation {
+	if len(h.benchmarkPrices) == 0 {
+		return nil
+	}
+	price := func(d time.Time) float64 {
+		p, _ := h.benchmarkPrices.at(d)
+		return p.value(adjustedClose)
+	}
+
+	simulated := make([]valuation, len(series))
+	var shares, cash float64
+	if len(series) > 0 {
+		cash = series[0].Value
+		for len(flows) > 0 && !flows[0].Time.After(series[0].Date) {
+			flows = flows[1:]
+		}
+	}
+	for i, v := range series {
+		for ; len(flows) > 0 && !flows[0].Time.After(v.Date); flows = flows[1:] {
+			cash += flows[0].Amount
+			if p := price(flows[0].Time); p > 0 {
+				shares += cash / p
+				cash = 0
+			}
+		}
+		p := price(v.Date)
+		if p > 0 && cash != 0 {
+			shares += cash / p
+			cash = 0
+		}
+		simulated[i] = valuation{Date: v.Date, Value: shares*p + cash, Invested: v.Invested}
+	}
+	return simulated
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBenchmarkFor(t *testing.T) {
+	h := portfolioHistory{benchmarkPrices: []HistoricalPrice{
+		{Date: "2024-01-02", Close: 10},
+		{Date: "2024-01-04", Close: 20},
+		{Date: "2024-01-05", Close: 25},
+		{Date: "2024-01-10", Close: 16},
+		{Date: "2024-01-12", Close: 30, AdjClose: 32},
+	}}
+	at := func(s string, hour int) time.Time { return day(s).Add(time.Duration(hour) * time.Hour) }
+	flows := []contribution{
+		{Time: at("2023-12-28", 12), Amount: 100}, // Before the range: in its opening value
+		{Time: at("2024-01-02", 15), Amount: 100}, // With the opening 150, buys 25 shares at 10
+		{Time: at("2024-01-04", 10), Amount: 100}, // 5 shares at 20, not at Friday's 25
+		{Time: at("2024-01-10", 9), Amount: -80},  // Sells 5 shares at 16
+		{Time: at("2024-01-13", 9), Amount: 50},   // After the last valuation
+	}
+	series := []valuation{
+		// Opens the range with 150, before the first price: waits as cash
+		{Date: day("2023-12-29"), Value: 150, Invested: 100},
+		{Date: day("2024-01-05"), Invested: 300},
+		{Date: day("2024-01-12"), Invested: 220},
+	}
+
+	got := h.benchmarkFor(series, flows)
+	tests := []struct {
+		date            string
+		value, invested float64
+	}{
+		{"2023-12-29", 150, 100},
+		{"2024-01-05", 30 * 25, 300},
+		// Valued at the adjusted close
+		{"2024-01-12", 25 * 32, 220},
+	}
+	if len(got) != len(tests) {
+		t.Fatalf("got %d valuations, want %d", len(got), len(tests))
+	}
+	for i, tt := range tests {
+		if v := got[i]; v.Date.Format(time.DateOnly) != tt.date || math.Abs(v.Value-tt.value) > 1e-9 || v.Invested != tt.invested {
+			t.Errorf("valuation %d = %s %v (invested %v), want %s %v (invested %v)", i, v.Date.Format(time.DateOnly), v.Value, v.Invested, tt.date, tt.value, tt.invested)
+		}
+	}
+
+	if got := (portfolioHistory{}).benchmarkFor(series, flows); got != nil {
+		t.Errorf("without a benchmark got %v, want nil", got)
+	}
+}
+
+func TestHistoryRangeDates(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		resolution string
+		want       []string
+	}{
+		{"weekly across a month end", "2024-05-27", "2024-06-14", resolutionWeekly, []string{"2024-05-31", "2024-06-07", "2024-06-14"}},
+		{"weekly across a year end", "2024-12-23", "2025-01-09", resolutionWeekly, []string{"2024-12-27", "2025-01-03"}},
+		// Months ending on a weekend end on the Friday before, even when it is a holiday
+		// (Good Friday 2024-03-29)
+		{"monthly", "2024-01-15", "2024-07-10", resolutionMonthly, []string{"2024-01-31", "2024-02-29", "2024-03-29", "2024-04-30", "2024-05-31", "2024-06-28"}},
+		{"monthly starting on a month end", "2024-08-30", "2024-09-30", resolutionMonthly, []string{"2024-08-30", "2024-09-30"}},
+		// Independence Day is valued like any other weekday
+		{"daily across a holiday and a weekend", "2024-07-03", "2024-07-08", resolutionDaily, []string{"2024-07-03", "2024-07-04", "2024-07-05", "2024-07-08"}},
+		{"ending before the first date", "2024-06-03", "2024-06-06", resolutionWeekly, []string{}},
+	}
+	for _, tt := range tests {
+		r := historyRange{start: day(tt.start).Add(15 * time.Hour), end: endOfDay(day(tt.end)), resolution: tt.resolution}
+		if got := dateStrings(r.dates()); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A holiday takes the last close before it
+	prices := []HistoricalPrice{{Date: "2024-07-03", Close: 10}, {Date: "2024-07-05", Close: 12}}
+	if got := newPriceSeries(prices).closeOn(endOfDay(day("2024-07-04"))); got != 10 {
+		t.Errorf("price on a holiday = %v, want 10", got)
+	}
+}
+
+func TestParseHistoryRange(t *testing.T) {
+	tests := []struct {
+		start, end, resolution string
+		want                   string // Resolution, or empty for an error
+	}{
+		{"", "", "", resolutionWeekly},
+		{"2024-01-01", "2024-06-30", resolutionMonthly, resolutionMonthly},
+		{"2024-01-01", "2024-01-01", resolutionDaily, resolutionDaily},
+		{"2024-06-30", "2024-01-01", "", ""},
+		{"2024-13-01", "", "", ""},
+		{"", "June", "", ""},
+		{"", "", "hourly", ""},
+	}
+	for _, tt := range tests {
+		r, err := parseHistoryRange(tt.start, tt.end, tt.resolution)
+		if tt.want == "" {
+			if !errors.Is(err, errInvalidInput) {
+				t.Errorf("parseHistoryRange(%q, %q, %q) error = %v, want invalid input", tt.start, tt.end, tt.resolution, err)
+			}
+			continue
+		}
+		if err != nil || r.resolution != tt.want {
+			t.Errorf("parseHistoryRange(%q, %q, %q) = %v, %v; want resolution %s", tt.start, tt.end, tt.resolution, r.resolution, err, tt.want)
+		}
+	}
+}
+
+func TestValueStrategies(t *testing.T) {
+	prices := map[string]priceSeries{"X": newPriceSeries(append(dailyPrices("2024-01-01", 10, 10), dailyPrices("2024-01-11", 5, 20)...))}
+	at := func(s string) time.Time { return day(s).Add(9 * time.Hour) }
+	entry := func(key string, ts string, qty, amount, fees float64, deleted string) loggedEntry {
+		e := loggedEntry{Log: InvestmentLog{StrategyKey: key, Ticker: "X", Timestamp: at(ts), QuantityBought: qty, InvestmentAmount: amount, Fees: fees}}
+		if deleted != "" {
+			e.Deleted = at(deleted)
+		}
+		return e
+	}
+	entries := []loggedEntry{
+		entry("a", "2024-01-02", 10, 100, 1, ""),
+		entry("c", "2024-01-02", 4, 40, 0, ""),
+		entry("b", "2024-01-03", 5, 50, 0, "2024-01-12"),
+		// Only 4 of the 6 shares are held, so 2/3 of the proceeds come in until the
+		// sell is deleted and the shares come back
+		entry("c", "2024-01-04", -6, -60, 0, "2024-01-09"),
+		// Only 10 of the 15 shares are held
+		entry("a", "2024-01-11", -15, -300, 2, ""),
+		// Deleted as soon as it was logged
+		entry("b", "2024-01-04", 100, 1000, 0, "2024-01-04"),
+	}
+	payouts := []dividendPayout{{date: "2024-01-05", strategy: "a", cash: 3}}
+	dates := []time.Time{endOfDay(day("2024-01-05")), endOfDay(day("2024-01-12"))}
+	strategies := []Strategy{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+
+	got := valueStrategies(strategies, dates, logChanges(entries), payouts, prices, nil)
+	tests := []struct {
+		key             string
+		i               int
+		value, invested float64
+	}{
+		{"a", 0, 10*10 + 3, 101},
+		{"a", 1, 3 + 298*10.0/15, 101},
+		{"b", 0, 5 * 10, 50},
+		{"b", 1, 0, 0},
+		{"c", 0, 60 * 4.0 / 6, 40},
+		{"c", 1, 4 * 20, 40},
+	}
+	for _, tt := range tests {
+		v := got[tt.key][tt.i]
+		if !v.Date.Equal(dates[tt.i]) || math.Abs(v.Value-tt.value) > 1e-9 || v.Invested != tt.invested {
+			t.Errorf("%s on %s = %v (invested %v), want %v (invested %v)", tt.key, v.Date.Format(time.DateOnly), v.Value, v.Invested, tt.value, tt.invested)
+		}
+	}
+}
+
+// BenchmarkValueStrategies values three strategies buying 50 tickers every four weeks
+// on every weekday of 10 years, as a daily portfolio history does.
+func BenchmarkValueStrategies(b *testing.B) {
+	const tickers = 50
+	start := day("2015-01-01")
+	r := historyRange{start: start, end: endOfDay(start.AddDate(10, 0, 0)), resolution: resolutionDaily}
+	dates := r.dates()
+	strategies := []Strategy{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+
+	prices := make(map[string]priceSeries, tickers)
+	var entries []loggedEntry
+	for i := range tickers {
+		ticker := fmt.Sprintf("T%02d", i)
+		days := make([]HistoricalPrice, len(dates))
+		for j, d := range dates {
+			days[j] = HistoricalPrice{Date: d.Format(time.DateOnly), Close: float64(100 + i + j%20)}
+		}
+		prices[ticker] = newPriceSeries(days)
+		for j := 0; j < len(dates); j += 20 {
+			s := strategies[(i+j)%len(strategies)]
+			entries = append(entries, loggedEntry{Log: InvestmentLog{StrategyKey: s.Key, Ticker: ticker, Timestamp: dates[j].Add(-time.Hour), QuantityBought: 1, InvestmentAmount: 100}})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Log.Timestamp.Before(entries[j].Log.Timestamp) })
+	changes := logChanges(entries)
+	b.Logf("%d tickers, %d dates, %d logs", tickers, len(dates), len(entries))
+
+	b.ResetTimer()
+	for range b.N {
+		series := valueStrategies(strategies, dates, changes, nil, prices, nil)
+		if last := series["a"][len(dates)-1]; last.Value == 0 {
+			b.Fatal("the strategy has no value")
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+// The indicator library. Every indicator takes the price history together with the
+// order it is in, works on the closes oldest first and returns its value on the most
+// recent date; ok is false when the history is too short for the requested periods.
+
+// seriesOrder tells an indicator how a price history is ordered.
+type seriesOrder int
+
+const (
+	newestFirst seriesOrder = iota // As returned by the FMP API and stored with each stock
+	oldestFirst                    // As used by backtests and the history chart
+)
+
+// tradingDaysPerYear annualises daily volatility.
+const tradingDaysPerYear = 252
+
+// closesOf returns the raw closes of prices ordered oldest first.
+func closesOf(prices []HistoricalPrice, order seriesOrder) []float64 {
+	return closesIn(prices, order, rawClose)
+}
+
+// closesIn returns the closes of prices in basis, ordered oldest first.
+func closesIn(prices []HistoricalPrice, order seriesOrder, basis priceBasis) []float64 {
+	closes := make([]float64, len(prices))
+	for i, p := range prices {
+		if order == newestFirst {
+			closes[len(prices)-1-i] = p.value(basis)
+		} else {
+			closes[i] = p.value(basis)
+		}
+	}
+	return closes
+}
+
+// meanStd returns the mean and population standard deviation of values.
+func meanStd(values []float64) (m, sd float64) {
+	m = mean(values)
+	for _, v := range values {
+		sd += (v - m) * (v - m)
+	}
+	return m, math.Sqrt(sd / float64(len(values)))
+}
+
+// emaSeries returns the exponential moving average of values with smoothing
+// 2/(period+1), seeded with the simple average of the first period values. Element i
+// is the average at values[period-1+i].
+func emaSeries(values []float64, period int) []float64 {
+	if period <= 0 || len(values) < period {
+		return nil
+	}
+	alpha := 2 / float64(period+1)
+	ema := make([]float64, 0, len(values)-period+1)
+	ema = append(ema, mean(values[:period]))
+	for _, v := range values[period:] {
+		ema = append(ema, alpha*v+(1-alpha)*ema[len(ema)-1])
+	}
+	return ema
+}
+
+// smaIndicator is the simple moving average of the last period closes.
+func smaIndicator(prices []HistoricalPrice, order seriesOrder, period int) (float64, bool) {
+	closes := closesOf(prices, order)
+	if period <= 0 || len(closes) < period {
+		return 0, false
+	}
+	return mean(closes[len(closes)-period:]), true
+}
+
+// emaIndicator is the exponential moving average of the closes.
+func emaIndicator(prices []HistoricalPrice, order seriesOrder, period int) (float64, bool) {
+	ema := emaSeries(closesOf(prices, order), period)
+	if len(ema) == 0 {
+		return 0, false
+	}
+	return ema[len(ema)-1], true
+}
+
+// rsi is Wilder's relative strength index, between 0 and 100.
+func rsi(prices []HistoricalPrice, order seriesOrder, period int) (float64, bool) {
+	closes := closesOf(prices, order)
+	if period <= 0 || len(closes) <= period {
+		return 0, false
+	}
+	var gain, loss float64
+	for i := 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		up, down := math.Max(change, 0), math.Max(-change, 0)
+		if i <= period {
+			gain += up / float64(period)
+			loss += down / float64(period)
+			continue
+		}
+		gain = (gain*float64(period-1) + up) / float64(period)
+		loss = (loss*float64(period-1) + down) / float64(period)
+	}
+	if loss == 0 {
+		return 100, true
+	}
+	return 100 - 100/(1+gain/loss), true
+}
+
+// macd returns the MACD line (fast EMA minus slow EMA of the closes), its signal line
+// (an EMA of the MACD line) and the histogram, their difference.
+func macd(prices []HistoricalPrice, order seriesOrder, fast, slow, signal int) (line, signalLine, histogram float64, ok bool) {
+	closes := closesOf(prices, order)
+	if fast <= 0 || fast >= slow || signal <= 0 || len(closes) < slow+signal-1 {
+		return 0, 0, 0, false
+	}
+	fastEMA, slowEMA := emaSeries(closes, fast), emaSeries(closes, slow)
+	// Both series end on the last close; the fast one starts slow-fast closes earlier
+	lines := make([]float64, len(slowEMA))
+	for i := range slowEMA {
+		lines[i] = fastEMA[i+slow-fast] - slowEMA[i]
+	}
+	signals := emaSeries(lines, signal)
+	line, signalLine = lines[len(lines)-1], signals[len(signals)-1]
+	return line, signalLine, line - signalLine, true
+}
+
+// bollinger returns the middle band (the period SMA) and the bands width standard
+// deviations above and below it.
+func bollinger(prices []HistoricalPrice, order seriesOrder, period int, width float64) (middle, upper, lower float64, ok bool) {
+	closes := closesOf(prices, order)
+	if period <= 0 || len(closes) < period {
+		return 0, 0, 0, false
+	}
+	middle, sd := meanStd(closes[len(closes)-period:])
+	return middle, middle + width*sd, middle - width*sd, true
+}
+
+// trueRange is the range of a day including the gap from the previous close. Days
+// without a high and low fall back to the absolute change between the two closes.
+func trueRange(day, previous HistoricalPrice) float64 {
+	if day.High <= 0 || day.Low <= 0 {
+		return math.Abs(day.Close - previous.Close)
+	}
+	return max(day.High-day.Low, math.Abs(day.High-previous.Close), math.Abs(day.Low-previous.Close))
+}
+
+// atr is Wilder's average true range.
+func atr(prices []HistoricalPrice, order seriesOrder, period int) (float64, bool) {
+	if period <= 0 || len(prices) <= period {
+		return 0, false
+	}
+	days := prices
+	if order == newestFirst {
+		days = make([]HistoricalPrice, len(prices))
+		for i, p := range prices {
+			days[len(prices)-1-i] = p
+		}
+	}
+	var avg float64
+	for i := 1; i < len(days); i++ {
+		tr := trueRange(days[i], days[i-1])
+		if i <= period {
+			avg += tr / float64(period)
+			continue
+		}
+		avg = (avg*float64(period-1) + tr) / float64(period)
+	}
+	return avg, true
+}
+
+// rollingVolatility is the annualised standard deviation of the last window daily
+// returns.
+func rollingVolatility(prices []HistoricalPrice, order seriesOrder, window int) (float64, bool) {
+	return returnVolatility(closesOf(prices, order), window)
+}
+
+// returnVolatility is rollingVolatility of closes ordered oldest first, for the
+// strategies that choose their price basis.
+func returnVolatility(closes []float64, window int) (float64, bool) {
+	if window < 2 || len(closes) <= window {
+		return 0, false
+	}
+	returns := make([]float64, 0, window)
+	for i := len(closes) - window; i < len(closes); i++ {
+		if closes[i-1] <= 0 {
+			return 0, false
+		}
+		returns = append(returns, closes[i]/closes[i-1]-1)
+	}
+	m := mean(returns)
+	var sum float64
+	for _, r := range returns {
+		sum += (r - m) * (r - m)
+	}
+	return math.Sqrt(sum/float64(len(returns)-1)) * math.Sqrt(tradingDaysPerYear), true
+}
+
+// drawdown is how far the last close is below the highest close of the last window
+// days, as a negative fraction (0 at a new high).
+func drawdown(prices []HistoricalPrice, order seriesOrder, window int) (float64, bool) {
+	closes := closesOf(prices, order)
+	if window <= 0 || len(closes) < window {
+		return 0, false
+	}
+	high := 0.0
+	for _, c := range closes[len(closes)-window:] {
+		high = math.Max(high, c)
+	}
+	if high <= 0 {
+		return 0, false
+	}
+	return closes[len(closes)-1]/high - 1, true
+}
+
+// zScore is how many standard deviations the last close is from its period SMA.
+func zScore(prices []HistoricalPrice, order seriesOrder, period int) (float64, bool) {
+	closes := closesOf(prices, order)
+	if period < 2 || len(closes) < period {
+		return 0, false
+	}
+	m, sd := meanStd(closes[len(closes)-period:])
+	if sd == 0 {
+		return 0, false
+	}
+	return (closes[len(closes)-1] - m) / sd, true
+}
+
+// indicatorDef describes an indicator that can be shown as a dashboard column.
+type indicatorDef struct {
+	Key     string
+	Name    string
+	Periods []string // What each period means, e.g. "fast"
+	Default []int
+	Format  string // "price", "percent" or "number"
+	compute func(prices []HistoricalPrice, order seriesOrder, periods []int) (float64, bool)
+}
+
+// indicatorDefs lists the indicators available as columns, in the order they are offered.
+var indicatorDefs = []indicatorDef{
+	{Key: "sma", Name: "SMA", Periods: []string{"days"}, Default: []int{50}, Format: "price",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) { return smaIndicator(p, o, n[0]) }},
+	{Key: "ema", Name: "EMA", Periods: []string{"days"}, Default: []int{20}, Format: "price",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) { return emaIndicator(p, o, n[0]) }},
+	{Key: "rsi", Name: "RSI", Periods: []string{"days"}, Default: []int{14}, Format: "number",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) { return rsi(p, o, n[0]) }},
+	{Key: "macd", Name: "MACD Histogram", Periods: []string{"fast", "slow", "signal"}, Default: []int{12, 26, 9}, Format: "number",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) {
+			_, _, histogram, ok := macd(p, o, n[0], n[1], n[2])
+			return histogram, ok
+		}},
+	// %B places the price within the bands: 0 on the lower band, 1 on the upper one
+	{Key: "bollinger", Name: "Bollinger %B", Periods: []string{"days", "standard deviations"}, Default: []int{20, 2}, Format: "number",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) {
+			_, upper, lower, ok := bollinger(p, o, n[0], float64(n[1]))
+			if !ok || upper == lower {
+				return 0, false
+			}
+			closes := closesOf(p, o)
+			return (closes[len(closes)-1] - lower) / (upper - lower), true
+		}},
+	{Key: "atr", Name: "ATR", Periods: []string{"days"}, Default: []int{14}, Format: "price",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) { return atr(p, o, n[0]) }},
+	{Key: "volatility", Name: "Volatility", Periods: []string{"days"}, Default: []int{20}, Format: "percent",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) {
+			return rollingVolatility(p, o, n[0])
+		}},
+	{Key: "drawdown", Name: "Drawdown", Periods: []string{"days"}, Default: []int{250}, Format: "percent",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) { return drawdown(p, o, n[0]) }},
+	{Key: "zscore", Name: "Z-Score", Periods: []string{"days"}, Default: []int{200}, Format: "number",
+		compute: func(p []HistoricalPrice, o seriesOrder, n []int) (float64, bool) { return zScore(p, o, n[0]) }},
+}
+
+func findIndicator(key string) (indicatorDef, bool) {
+	for _, d := range indicatorDefs {
+		if d.Key == key {
+			return d, true
+		}
+	}
+	return indicatorDef{}, false
+}
+
+// IndicatorColumn is an indicator shown as a column of the holdings table.
+type IndicatorColumn struct {
+	Key     string `firestore:"key" json:"key" openapi:"required,enum=sma|ema|rsi|macd|bollinger|atr|volatility|drawdown|zscore"`
+	Periods []int  `firestore:"periods" json:"periods"` // Empty means the indicator's defaults
+}
+
+// maxIndicatorColumns keeps the holdings table readable.
+const maxIndicatorColumns = 12
+
+// validate checks the column and fills in the default periods.
+func (col *IndicatorColumn) validate() error {
+	def, ok := findIndicator(col.Key)
+	if !ok {
+		return fmt.Errorf("%w: unknown indicator %q", errInvalidInput, col.Key)
+	}
+	if len(col.Periods) == 0 {
+		col.Periods = append([]int(nil), def.Default...)
+	}
+	if len(col.Periods) != len(def.Default) {
+		return fmt.Errorf("%w: %s takes %d period(s): %s", errInvalidInput, def.Name, len(def.Default), strings.Join(def.Periods, ", "))
+	}
+	for _, n := range col.Periods {
+		if n < 1 || n > historyWindow-1 {
+			return fmt.Errorf("%w: %s periods must be between 1 and %d days", errInvalidInput, def.Name, historyWindow-1)
+		}
+	}
+	if col.Key == "macd" && col.Periods[0] >= col.Periods[1] {
+		return fmt.Errorf("%w: the fast MACD period must be shorter than the slow one", errInvalidInput)
+	}
+	return nil
+}
+
+// Label is the column header, e.g. "RSI-14".
+func (col IndicatorColumn) Label() string {
+	def, _ := findIndicator(col.Key)
+	periods := make([]string, len(col.Periods))
+	for i, n := range col.Periods {
+		periods[i] = strconv.Itoa(n)
+	}
+	return def.Name + "-" + strings.Join(periods, "/")
+}
+
+// format computes the column for a history and renders it for the table. It is
+// empty when the history is too short.
+func (col IndicatorColumn) format(prices []HistoricalPrice, order seriesOrder) string {
+	def, ok := findIndicator(col.Key)
+	if !ok || len(col.Periods) != len(def.Default) {
+		return ""
+	}
+	v, ok := def.compute(prices, order, col.Periods)
+	if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+		return ""
+	}
+	switch def.Format {
+	case "price":
+		return fmt.Sprintf("€%.2f", v)
+	case "percent":
+		return strconv.FormatFloat(v*100, 'f', 2, 64) + "%"
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// updateIndicatorColumns sets the indicator columns shown on the dashboard.
+func updateIndicatorColumns(ctx context.Context, columns []IndicatorColumn) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.IndicatorColumns = columns
+	defer func() { recordAudit(ctx, auditIndicators, "settings", before, after, err) }()
+
+	if len(columns) > maxIndicatorColumns {
+		return Settings{}, fmt.Errorf("%w: at most %d indicator columns can be shown", errInvalidInput, maxIndicatorColumns)
+	}
+	for i := range columns {
+		if err := columns[i].validate(); err != nil {
+			return Settings{}, err
+		}
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "indicatorColumns", Value: columns}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update indicator columns: %w", err)
+	}
+	return after, appendEvent(ctx, eventIndicators, after)
+}
+
+// indicatorOption is an indicator as offered by the column form on the dashboard.
+type indicatorOption struct {
+	Key      string
+	Name     string
+	Help     string // What the periods mean
+	Periods  string // Comma-separated, as currently chosen or the defaults
+	Selected bool
+}
+
+// indicatorOptions returns the column form for the chosen columns.
+func indicatorOptions(columns []IndicatorColumn) []indicatorOption {
+	options := make([]indicatorOption, len(indicatorDefs))
+	for i, def := range indicatorDefs {
+		periods := def.Default
+		for _, col := range columns {
+			if col.Key == def.Key {
+				periods = col.Periods
+				options[i].Selected = true
+				break
+			}
+		}
+		text := make([]string, len(periods))
+		for j, n := range periods {
+			text[j] = strconv.Itoa(n)
+		}
+		options[i].Key, options[i].Name, options[i].Help = def.Key, def.Name, strings.Join(def.Periods, ", ")
+		options[i].Periods = strings.Join(text, ", ")
+	}
+	return options
+}
+
+// indicatorValues computes the chosen columns for every stock from its stored history,
+// keyed by ticker.
+func indicatorValues(stocks []Stock, columns []IndicatorColumn) map[string][]string {
+	values := make(map[string][]string, len(stocks))
+	for _, s := range stocks {
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = col.format(s.History, newestFirst)
+		}
+		values[s.Ticker] = row
+	}
+	return values
+}
+
+// handleUpdateIndicators saves the indicator columns chosen on the dashboard. Each
+// indicator has a checkbox and a comma-separated list of periods.
+func handleUpdateIndicators(c *gin.Context) {
+	var columns []IndicatorColumn
+	for _, def := range indicatorDefs {
+		if c.PostForm("col_"+def.Key) != "true" {
+			continue
+		}
+		col := IndicatorColumn{Key: def.Key}
+		for _, field := range strings.Split(c.PostForm("periods_"+def.Key), ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				c.String(http.StatusBadRequest, "Invalid %s period %q", def.Name, field)
+				return
+			}
+			col.Periods = append(col.Periods, n)
+		}
+		columns = append(columns, col)
+	}
+	if _, err := updateIndicatorColumns(c.Request.Context(), columns); err != nil {
+		log.Printf("Failed to update indicator columns: %v", err)
+		c.String(formErrorStatus(err), "Failed to update indicator columns: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+// IndicatorColumnsRequest is the body accepted by PUT /api/v1/settings/indicators.
+type IndicatorColumnsRequest struct {
+	Columns []IndicatorColumn `json:"columns"`
+}
+
+func apiUpdateIndicators(c *gin.Context) {
+	var req IndicatorColumnsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := updateIndicatorColumns(c.Request.Context(), req.Columns)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// referenceCloses are 20 daily closes, oldest first, from Wilder's RSI example. The
+// expected values below were worked out independently of the library.
+var referenceCloses = []float64{
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08,
+	45.89, 46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64,
+}
+
+// referencePrices returns the closes as days with a high 0.50 above and a low 0.40
+// below the close, oldest first.
+func referencePrices(closes []float64) []HistoricalPrice {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	prices := make([]HistoricalPrice, len(closes))
+	for i, c := range closes {
+		prices[i] = HistoricalPrice{Date: start.AddDate(0, 0, i).Format(time.DateOnly), Close: c, High: c + 0.5, Low: c - 0.4}
+	}
+	return prices
+}
+
+// inOrder returns prices, given oldest first, in order.
+func inOrder(prices []HistoricalPrice, order seriesOrder) []HistoricalPrice {
+	if order == oldestFirst {
+		return prices
+	}
+	reversed := slices.Clone(prices)
+	slices.Reverse(reversed)
+	return reversed
+}
+
+func TestIndicators(t *testing.T) {
+	tests := []struct {
+		name    string
+		compute func([]HistoricalPrice, seriesOrder) (float64, bool)
+		want    float64
+	}{
+		{"SMA-5", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return smaIndicator(p, o, 5) }, 46.06},
+		{"EMA-5", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return emaIndicator(p, o, 5) }, 45.9960536194},
+		{"RSI-14", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return rsi(p, o, 14) }, 57.9150206701},
+		{"MACD line", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			line, _, _, ok := macd(p, o, 3, 6, 3)
+			return line, ok
+		}, -0.0635485212},
+		{"MACD signal", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			_, signal, _, ok := macd(p, o, 3, 6, 3)
+			return signal, ok
+		}, 0.0196053766},
+		{"MACD histogram", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			_, _, histogram, ok := macd(p, o, 3, 6, 3)
+			return histogram, ok
+		}, -0.0831538979},
+		{"Bollinger middle", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			middle, _, _, ok := bollinger(p, o, 5, 2)
+			return middle, ok
+		}, 46.06},
+		{"Bollinger upper", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			_, upper, _, ok := bollinger(p, o, 5, 2)
+			return upper, ok
+		}, 46.5730302135},
+		{"Bollinger lower", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			_, _, lower, ok := bollinger(p, o, 5, 2)
+			return lower, ok
+		}, 45.5469697865},
+		{"ATR-5", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return atr(p, o, 5) }, 0.9345455762},
+		{"Volatility-5", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return rollingVolatility(p, o, 5) }, 0.1233231739},
+		{"Drawdown-10", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return drawdown(p, o, 10) }, -0.0165912519},
+		{"Z-Score-5", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return zScore(p, o, 5) }, -1.6373304687},
+	}
+	prices := referencePrices(referenceCloses)
+	for _, tt := range tests {
+		for _, order := range []seriesOrder{oldestFirst, newestFirst} {
+			got, ok := tt.compute(inOrder(prices, order), order)
+			if !ok {
+				t.Errorf("%s (order %d): ok = false, want true", tt.name, order)
+				continue
+			}
+			if math.Abs(got-tt.want) > 1e-8 {
+				t.Errorf("%s (order %d) = %.10f, want %.10f", tt.name, order, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestIndicatorsTooShort(t *testing.T) {
+	prices := referencePrices(referenceCloses[:5])
+	tests := []struct {
+		name    string
+		compute func([]HistoricalPrice, seriesOrder) (float64, bool)
+	}{
+		{"SMA longer than the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return smaIndicator(p, o, 6) }},
+		{"SMA of 0 days", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return smaIndicator(p, o, 0) }},
+		{"EMA longer than the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return emaIndicator(p, o, 6) }},
+		// RSI and ATR need a change more than their period
+		{"RSI as long as the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return rsi(p, o, 5) }},
+		{"ATR as long as the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return atr(p, o, 5) }},
+		{"MACD without room for the signal", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			_, _, h, ok := macd(p, o, 2, 4, 3)
+			return h, ok
+		}},
+		{"MACD with fast not below slow", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			_, _, h, ok := macd(p, o, 3, 3, 1)
+			return h, ok
+		}},
+		{"Bollinger longer than the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			m, _, _, ok := bollinger(p, o, 6, 2)
+			return m, ok
+		}},
+		{"Volatility as long as the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return rollingVolatility(p, o, 5) }},
+		{"Volatility of one return", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return rollingVolatility(p, o, 1) }},
+		{"Drawdown longer than the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return drawdown(p, o, 6) }},
+		{"Z-score longer than the history", func(p []HistoricalPrice, o seriesOrder) (float64, bool) { return zScore(p, o, 6) }},
+		{"Z-score of flat prices", func(p []HistoricalPrice, o seriesOrder) (float64, bool) {
+			return zScore(referencePrices([]float64{10, 10, 10}), o, 3)
+		}},
+	}
+	for _, tt := range tests {
+		for _, order := range []seriesOrder{oldestFirst, newestFirst} {
+			if v, ok := tt.compute(inOrder(prices, order), order); ok {
+				t.Errorf("%s (order %d) = %v, want ok = false", tt.name, order, v)
+			}
+		}
+	}
+}
+
+func TestRSIWithoutLosses(t *testing.T) {
+	prices := referencePrices([]float64{1, 2, 3, 4, 5, 6})
+	if v, ok := rsi(prices, oldestFirst, 3); !ok || v != 100 {
+		t.Errorf("rsi of rising prices = %v, %v, want 100, true", v, ok)
+	}
+}
+
+func TestTrueRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		day, previous HistoricalPrice
+		want          float64
+	}{
+		{"inside the previous close", HistoricalPrice{Close: 10, High: 11, Low: 9}, HistoricalPrice{Close: 10}, 2},
+		{"gap up", HistoricalPrice{Close: 14, High: 15, Low: 13}, HistoricalPrice{Close: 10}, 5},
+		{"gap down", HistoricalPrice{Close: 6, High: 7, Low: 5}, HistoricalPrice{Close: 10}, 5},
+		{"close only", HistoricalPrice{Close: 7}, HistoricalPrice{Close: 10}, 3},
+	}
+	for _, tt := range tests {
+		if got := trueRange(tt.day, tt.previous); got != tt.want {
+			t.Errorf("%s: trueRange = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClosesIn(t *testing.T) {
+	prices := []HistoricalPrice{{Close: 12, AdjClose: 6}, {Close: 10}, {Close: 8, AdjClose: 4}}
+	if got, want := closesIn(prices, newestFirst, adjustedClose), []float64{4, 10, 6}; !slices.Equal(got, want) {
+		t.Errorf("adjusted, newest first: closesIn = %v, want %v", got, want)
+	}
+	if got, want := closesIn(prices, oldestFirst, rawClose), []float64{12, 10, 8}; !slices.Equal(got, want) {
+		t.Errorf("raw, oldest first: closesIn = %v, want %v", got, want)
+	}
+}
@@ -2,8 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
 	"html/template"
 	"log"
 	"math"
@@ -17,13 +16,29 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
-	"google.golang.org/api/iterator"
 )
 
 // The new Settings struct
 type Settings struct {
-	Amount          float64 `firestore:"amount"`
-	NextBatchNumber int     `firestore:"nextBatchNumber"`
+	Amount          float64         `firestore:"amount" json:"amount"`
+	NextBatchNumber int             `firestore:"nextBatchNumber" json:"nextBatchNumber"`
+	Costs           CostModel       `firestore:"costs" json:"costs"`         // Applied to every allocation
+	DriftBand       float64         `firestore:"driftBand" json:"driftBand"` // Drift at which the rebalance strategy sells, 0 = never
+	Watchlist       []WatchlistItem `firestore:"watchlist" json:"watchlist"` // Candidates for the strategies that look beyond the holdings
+	Rules           []RuleStrategy  `firestore:"rules" json:"rules"`         // User-defined strategies, run after the built-in ones
+	// Extra indicator columns of the holdings table on the dashboard
+	IndicatorColumns []IndicatorColumn `firestore:"indicatorColumns" json:"indicatorColumns"`
+	// When enabled, replaces Amount with the gap to a target value path
+	ValueAveraging ValueAveraging `firestore:"valueAveraging" json:"valueAveraging"`
+	// Compute the MA-200 and EMA trend from split- and dividend-adjusted closes
+	AdjustedPrices bool `firestore:"adjustedPrices" json:"adjustedPrices"`
+	// Ticker of the index or ETF the portfolio is compared with, empty for none
+	Benchmark string `firestore:"benchmark" json:"benchmark"`
+	// Shares of the portfolio above which the exposure page flags a holding, sector etc.
+	ExposureLimits ExposureLimits `firestore:"exposureLimits" json:"exposureLimits"`
+	// Dividends paid out as cash since the last allocation, which the next one invests
+	// on top of the budget in either mode
+	DividendCash float64 `firestore:"dividendCash" json:"dividendCash"`
 }
 
 // Stock represents data about a stock.
@@ -37,26 +52,79 @@ type Stock struct {
 	IsBelowMA      bool    `json:"is_below_ma"`
 	Recommendation string  `json:"recommendation"`
 	EMATrend       float64 `json:"ema_trend"`
+	WholeShares    bool    `json:"whole_shares" form:"whole_shares"` // The broker only buys whole shares of this holding
+	MinOrder       float64 `json:"min_order" form:"min_order"`       // Smallest order the broker accepts, in €
+	TargetWeight   float64 `json:"target_weight" form:"-"`           // Share of the portfolio the rebalance strategy aims for, 0.25 = 25%
+	DRIP           bool    `json:"drip" form:"drip"`                 // Reinvest the holding's dividends in it instead of adding them to the budget
+	Withholding    float64 `json:"withholding" form:"-"`             // Tax withheld from its dividends, 0.15 = 15%
+	DividendTTM    float64 `json:"dividend_ttm"`                     // Dividends per share that went ex over the last year, as of the last analysis
+	// Sector, country, currency and asset type from the market data provider, refreshed
+	// by the analysis
+	Profile CompanyProfile `json:"profile" form:"-"`
+	// History holds the daily closes of the last analysis, newest first. It is stored
+	// with the holding for the risk-based strategies but left out of the API and events.
+	History []HistoricalPrice `json:"-" form:"-"`
 }
 
 // InvestmentLog matches the structure of a document in the 'investment_logs' collection.
 type InvestmentLog struct {
-	ID               string    `firestore:"-"`
-	Batch            int       `firestore:"batch"`
-	Ticker           string    `firestore:"ticker"`
-	Name             string    `firestore:"name"`
-	InvestmentAmount float64   `firestore:"investmentAmount"`
-	PricePerShare    float64   `firestore:"pricePerShare"`
-	QuantityBought   float64   `firestore:"quantityBought"`
-	Strategy         string    `firestore:"strategy"`
-	Timestamp        time.Time `firestore:"timestamp"`
-}
-
-// PortfolioHistoryPoint represents the value of both strategies at a single point in time.
+	ID               string    `firestore:"-" json:"id"`
+	StrategyKey      string    `firestore:"-" json:"strategyKey"` // Key of the strategy whose collection holds this log
+	Batch            int       `firestore:"batch" json:"batch"`
+	Ticker           string    `firestore:"ticker" json:"ticker"`
+	Name             string    `firestore:"name" json:"name"`
+	InvestmentAmount float64   `firestore:"investmentAmount" json:"investmentAmount"`
+	PricePerShare    float64   `firestore:"pricePerShare" json:"pricePerShare"`
+	QuantityBought   float64   `firestore:"quantityBought" json:"quantityBought"`
+	Fees             float64   `firestore:"fees" json:"fees"`
+	Rank             int       `firestore:"rank,omitempty" json:"rank,omitempty"`   // Only set by strategies that rank stocks
+	Score            float64   `firestore:"score,omitempty" json:"score,omitempty"` // The value the rank is based on
+	Strategy         string    `firestore:"strategy" json:"strategy"`
+	Timestamp        time.Time `firestore:"timestamp" json:"timestamp"`
+	SplitFactor      float64   `firestore:"-" json:"splitFactor,omitempty"` // Shares each share bought has become through later splits, unset if none
+}
+
+// AdjustedQuantity is the number of shares the purchase amounts to after later splits.
+func (l InvestmentLog) AdjustedQuantity() float64 {
+	if l.SplitFactor == 0 {
+		return l.QuantityBought
+	}
+	return l.QuantityBought * l.SplitFactor
+}
+
+// AdjustedPrice is the price per share in terms of today's shares, comparable with
+// current prices.
+func (l InvestmentLog) AdjustedPrice() float64 {
+	if l.SplitFactor == 0 {
+		return l.PricePerShare
+	}
+	return l.PricePerShare / l.SplitFactor
+}
+
+// PortfolioHistory is the value of every strategy over time.
+type PortfolioHistory struct {
+	Strategies []HistorySeries         `json:"strategies"` // In the order strategies run
+	Points     []PortfolioHistoryPoint `json:"points"`
+}
+
+// HistorySeries names a series of the history.
+type HistorySeries struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// PortfolioHistoryPoint represents the value of the strategies at a single point in time.
 type PortfolioHistoryPoint struct {
-	Date       int64   `json:"date"`
-	MAValue    float64 `json:"maValue"`
-	NaiveValue float64 `json:"naiveValue"`
+	Date int64 `json:"date"`
+	// By strategy key. The values are total returns: they include the proceeds of the
+	// sales and the dividends paid so far.
+	Values map[string]float64 `json:"values"`
+	// Only set while value averaging is enabled: the target path and the value of
+	// the real holdings it is compared with.
+	TargetValue float64 `json:"targetValue,omitempty"`
+	ActualValue float64 `json:"actualValue,omitempty"`
+	// Only set with a benchmark: the MA-200 strategy's contributions invested in it
+	BenchmarkValue float64 `json:"benchmarkValue,omitempty"`
 }
 
 var (
@@ -71,7 +139,9 @@ var (
 	fmpApiKey       string
 )
 
-func init() {
+// loadConfig reads the settings of the server from the environment. It is called from
+// main rather than init, so the tests can run without them.
+func loadConfig() {
 	// Attempt to load the .env file.
 	// This will not return an error if the file doesn't exist,
 	// which is perfect for production environments.
@@ -109,11 +179,21 @@ func createFirestoreClient(ctx context.Context) *firestore.Client {
 }
 
 func main() {
+	loadConfig()
 	ctx := context.Background()
 	firestoreClient = createFirestoreClient(ctx)
 	defer firestoreClient.Close()
+	if err := ensureEventBaseline(ctx); err != nil {
+		log.Printf("Failed to start event stream: %v", err)
+	}
 	router := gin.Default()
 
+	// Helpers available to every template; must be set before the templates are loaded
+	router.SetFuncMap(template.FuncMap{
+		"percent": func(v float64) string { return strconv.FormatFloat(v*100, 'f', 2, 64) + "%" },
+		// percentValue renders a fraction as a plain percentage for form inputs, e.g. 0.001 as 0.1
+		"percentValue": func(v float64) string { return strconv.FormatFloat(math.Round(v*1e8)/1e6, 'f', -1, 64) },
+	})
 	// Tell Gin to load HTML templates form the "tempaltes" drectory
 	router.LoadHTMLGlob("templates/*")
 
@@ -122,6 +202,9 @@ func main() {
 	router.POST("/login", handleLogin)
 	router.POST("/logout", handleLogout)
 
+	// The API description is public so clients can be generated from it
+	router.GET("/api/openapi.json", serveOpenAPISpec)
+
 	// Group protected routes that require login
 	protected := router.Group("/")
 	protected.Use(authMiddleware())
@@ -135,9 +218,33 @@ func main() {
 		protected.POST("/analyze", handleAnalysis)
 		protected.POST("/allocate", handleAllocation)
 		protected.POST("/update-budget", handleUpdateBudget)
+		protected.POST("/update-costs", handleUpdateCosts)
+		protected.POST("/update-drift-band", handleUpdateDriftBand)
+		protected.POST("/update-price-basis", handleUpdatePriceBasis)
+		protected.POST("/update-benchmark", handleUpdateBenchmark)
+		protected.POST("/update-exposure-limits", handleUpdateExposureLimits)
+		protected.POST("/update-value-averaging", handleUpdateValueAveraging)
+		protected.POST("/update-indicators", handleUpdateIndicators)
+		protected.POST("/watchlist/add", handleAddToWatchlist)
+		protected.POST("/watchlist/remove", handleRemoveFromWatchlist)
+		protected.POST("/rules/save", handleSaveRuleStrategy)
+		protected.POST("/rules/delete", handleDeleteRuleStrategy)
 		protected.POST("/logs/delete", handleDeleteLog)
 		protected.GET("/chart", showChartPage)
-		protected.GET("/api/portfolio-history", handlePortfolioHistory)
+		protected.GET("/charts/:file", handleChartImage)
+		protected.GET("/performance", showPerformancePage)
+		protected.GET("/exposure", showExposurePage)
+		protected.GET("/audit", showAuditPage)
+		protected.GET("/audit/export", handleAuditExport)
+		protected.GET("/sweep", showSweepPage)
+		protected.GET("/corporate-actions", showCorporateActionsPage)
+		protected.POST("/corporate-actions/fetch", handleFetchCorporateActions)
+		protected.POST("/corporate-actions/add", handleAddCorporateAction)
+		protected.POST("/corporate-actions/apply", handleApplyCorporateAction)
+		protected.POST("/corporate-actions/dismiss", handleDismissCorporateAction)
+		protected.POST("/sweep", handleSweep)
+
+		registerAPIRoutes(protected)
 	}
 
 	// Get the port from the environment variable for Cloud Run
@@ -151,81 +258,72 @@ func main() {
 
 }
 
-func showChartPage(c *gin.Context) {
-	// Create a slice of dummy data points for testing
-	dummyHistory := []PortfolioHistoryPoint{
-		{Date: time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC).UnixMilli(), MAValue: 100, NaiveValue: 100},
-		{Date: time.Date(2025, 7, 8, 0, 0, 0, 0, time.UTC).UnixMilli(), MAValue: 105, NaiveValue: 102},
-		{Date: time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC).UnixMilli(), MAValue: 112, NaiveValue: 108},
-		{Date: time.Date(2025, 7, 22, 0, 0, 0, 0, time.UTC).UnixMilli(), MAValue: 110, NaiveValue: 115},
-		{Date: time.Date(2025, 7, 29, 0, 0, 0, 0, time.UTC).UnixMilli(), MAValue: 120, NaiveValue: 118},
-	}
+// showPortfolioPage renders the portfolio page with the current stock data.
+func showPortfolioPage(c *gin.Context) {
+	renderPortfolioPage(c, nil)
+}
 
-	dummyDataJSON, err := json.Marshal(dummyHistory)
+// renderPortfolioPage renders the dashboard, optionally with stock search results.
+func renderPortfolioPage(c *gin.Context, searchResults []StockSearchResult) {
+	ctx := c.Request.Context()
+	stocks, err := listStocks(ctx)
 	if err != nil {
-		log.Printf("Failed to marshal dummy data: %v", err)
-		c.String(http.StatusInternalServerError, "Failed to generate chart data")
-		return
+		log.Printf("Failed to load portfolio: %v", err)
 	}
 
-	// Pass the dummy data directly to the template
-	c.HTML(http.StatusOK, "chart.tmpl.html", gin.H{
-		"dummyData": template.JS(dummyDataJSON),
-	})
-}
-
-// showPortfolioPage renders the portfolio page with the current stock data.
-func showPortfolioPage(c *gin.Context) {
-	ctx := context.Background()
-	var stocks []Stock
-	// ... (your existing code to fetch stocks is the same) ...
-	iter := firestoreClient.Collection("portfolio").Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil { /* ... error handling ... */
-		}
-		var stock Stock
-		doc.DataTo(&stock)
-		stocks = append(stocks, stock)
+	currentSettings, err := getSettings(ctx)
+	if err != nil {
+		log.Printf("Failed to load settings: %v", err)
 	}
-
-	settingsDocRef := firestoreClient.Collection("settings").Doc("app") // Use a clear doc ID
-	doc, err := settingsDocRef.Get(ctx)
-	var currentSettings Settings
+	dividends, err := listCorporateActions(ctx, actionApplied)
 	if err != nil {
-		log.Printf("Settings document not found, creating with default values...")
-		currentSettings = Settings{Amount: 100.0, NextBatchNumber: 1} // Default settings
-		_, setErr := settingsDocRef.Set(ctx, currentSettings)
-		if setErr != nil {
-			log.Printf("Failed to create settings document: %v", setErr)
-		}
-	} else {
-		doc.DataTo(&currentSettings)
+		log.Printf("Failed to load dividends: %v", err)
 	}
+	now := time.Now()
+	targetValue, _ := currentSettings.ValueAveraging.target(now)
 
 	c.HTML(http.StatusOK, "index.tmpl.html", gin.H{
 		"stocks":        stocks,
-		"searchResults": nil,
+		"searchResults": searchResults,
 		"currentBudget": currentSettings.Amount, // Pass budget amount to template
+		"dividendCash":  currentSettings.DividendCash,
+		"costs":         currentSettings.Costs,
+		"driftBand":     currentSettings.DriftBand,
+		"adjusted":      currentSettings.AdjustedPrices,
+		"benchmark":     currentSettings.Benchmark,
+		"watchlist":     currentSettings.Watchlist,
+		"valueAvg":      currentSettings.ValueAveraging,
+		"rules":         currentSettings.Rules,
+		"indicatorCols": currentSettings.IndicatorColumns,
+		"indicatorVals": indicatorValues(stocks, currentSettings.IndicatorColumns),
+		"indicatorOpts": indicatorOptions(currentSettings.IndicatorColumns),
+		"ruleVariables": ruleVariables,
+		"nextBudget":    currentSettings.budget(stocks, now),
+		"targetValue":   targetValue,
+		"holdingsValue": holdingsValue(stocks),
+		"income":        projectIncome(stocks, dividends),
 	})
 }
 
-func handleDeleteLog(c *gin.Context) {
-	logID := c.PostForm("logID")
-	if logID == "" {
-		c.String(http.StatusBadRequest, "Log ID is required")
-		return
-	}
+// formErrorStatus maps a service error onto the status code used by the HTML handlers.
+func formErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidInput):
+		return http.StatusBadRequest
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUpstream):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
 
-	ctx := context.Background()
-	// Delete the document with the matching ID from the 'investment_logs' collection
-	_, err := firestoreClient.Collection("investment_logs").Doc(logID).Delete(ctx)
-	if err != nil {
-		log.Printf("Failed to delete log entry %s: %v", logID, err)
-		c.String(http.StatusInternalServerError, "Failed to delete log entry")
+func handleDeleteLog(c *gin.Context) {
+	// Older forms only post the log ID, which always referred to the MA strategy logs
+	strategyKey := c.DefaultPostForm("strategy", primaryStrategyKey)
+	if err := deleteLog(c.Request.Context(), strategyKey, c.PostForm("logID")); err != nil {
+		log.Printf("Failed to delete log entry: %v", err)
+		c.String(formErrorStatus(err), "Failed to delete log entry: %v", err)
 		return
 	}
 
@@ -234,71 +332,26 @@ func handleDeleteLog(c *gin.Context) {
 }
 
 func handleDeleteLogBatch(c *gin.Context) {
-	batchStr := c.PostForm("batch")
-	batch, _ := strconv.Atoi(batchStr)
-	if batch == 0 {
-		c.String(http.StatusBadRequest, "Invalid batch number")
-		return
-	}
-
-	ctx := context.Background()
+	batch, _ := strconv.Atoi(c.PostForm("batch"))
 	// Find all documents in the naive logs collection with the matching batch number
-	query := firestoreClient.Collection("naive_strategy_logs").Where("batch", "==", batch)
-	iter := query.Documents(ctx)
-
-	// Use a batched write to delete all found documents efficiently
-	batchWrite := firestoreClient.Batch()
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("Failed to iterate for batch delete: %v", err)
-			break
-		}
-		batchWrite.Delete(doc.Ref)
-	}
-
-	// Commit the batched delete operation
-	_, err := batchWrite.Commit(ctx)
-	if err != nil {
-		log.Printf("Failed to commit batch delete for batch %d: %v", batch, err)
+	if err := deleteLogBatch(c.Request.Context(), "naive", batch); err != nil {
+		log.Printf("Failed to delete log batch: %v", err)
+		c.String(formErrorStatus(err), "Failed to delete log batch: %v", err)
+		return
 	}
 
 	c.Redirect(http.StatusFound, "/logs")
 }
 
 func showLogsPage(c *gin.Context) {
-	ctx := context.Background()
+	allLogs, _, err := queryLogs(c.Request.Context(), LogFilter{})
+	if err != nil {
+		log.Printf("Failed to load logs: %v", err)
+	}
 
 	logBatches := make(map[int][]InvestmentLog)
-	var allLogs []InvestmentLog
-
-	collections := []string{"investment_logs", "naive_strategy_logs", "ema_logs"}
-
-	for _, coll := range collections {
-		iter := firestoreClient.Collection(coll).OrderBy("batch", firestore.Desc).OrderBy("timestamp", firestore.Desc).Documents(ctx)
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				log.Printf("Failed to iterate logs from %s: %v", coll, err)
-				continue // Continue to the next document or collection
-			}
-
-			var logEntry InvestmentLog
-			if err := doc.DataTo(&logEntry); err != nil {
-				log.Printf("Failed to convert log document from %s: %v", coll, err)
-				continue
-			}
-			logEntry.ID = doc.Ref.ID
-
-			logBatches[logEntry.Batch] = append(logBatches[logEntry.Batch], logEntry)
-			allLogs = append(allLogs, logEntry)
-		}
+	for _, logEntry := range allLogs {
+		logBatches[logEntry.Batch] = append(logBatches[logEntry.Batch], logEntry)
 	}
 
 	// --- Calculate Metrics from all logs ---
@@ -344,92 +397,112 @@ func handleUpdateBudget(c *gin.Context) {
 	amountStr := c.PostForm("amount")
 	amount, _ := strconv.ParseFloat(strings.Replace(amountStr, ",", ".", -1), 64)
 
-	ctx := context.Background()
-	// FIX: Use the 'app' document ID for consistency
-	settingsDocRef := firestoreClient.Collection("settings").Doc("app")
-
-	// Update the 'amount' field in the document
-	_, err := settingsDocRef.Update(ctx, []firestore.Update{
-		{Path: "amount", Value: amount},
-	})
-	if err != nil {
+	if _, err := updateBudget(c.Request.Context(), amount); err != nil {
 		log.Printf("Failed to update budget: %v", err)
 	}
 
 	c.Redirect(http.StatusFound, "/")
 }
 
-// main.go
+// handleUpdateCosts saves the cost model. The percentages are entered as percent
+// on the form and stored as fractions.
+func handleUpdateCosts(c *gin.Context) {
+	value := func(field string) float64 {
+		v, _ := strconv.ParseFloat(strings.Replace(c.PostForm(field), ",", ".", -1), 64)
+		return v
+	}
+	costs := CostModel{
+		FixedFee:   value("fixedFee"),
+		PercentFee: value("percentFee") / 100,
+		MinFee:     value("minFee"),
+		Spread:     value("spread") / 100,
+		Slippage:   value("slippage") / 100,
+	}
+	if _, err := updateCosts(c.Request.Context(), costs); err != nil {
+		log.Printf("Failed to update trading costs: %v", err)
+	}
 
-func handleSearch(c *gin.Context) {
-	query := c.Query("query")
-	if query == "" {
-		c.Redirect(http.StatusFound, "/")
-		return
+	c.Redirect(http.StatusFound, "/")
+}
+
+func handleUpdateDriftBand(c *gin.Context) {
+	band, _ := strconv.ParseFloat(strings.Replace(c.PostForm("driftBand"), ",", ".", -1), 64)
+	if _, err := updateDriftBand(c.Request.Context(), band/100); err != nil {
+		log.Printf("Failed to update drift band: %v", err)
 	}
 
-	results, err := searchStocks(query)
-	if err != nil {
-		fmt.Printf("Error searching stocks: %v\n", err)
-		c.Redirect(http.StatusFound, "/")
+	c.Redirect(http.StatusFound, "/")
+}
+
+func handleUpdatePriceBasis(c *gin.Context) {
+	if _, err := updatePriceBasis(c.Request.Context(), c.PostForm("adjusted") == "true"); err != nil {
+		log.Printf("Failed to update price basis: %v", err)
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func handleUpdateBenchmark(c *gin.Context) {
+	if _, err := updateBenchmark(c.Request.Context(), c.PostForm("benchmark")); err != nil {
+		log.Printf("Failed to update benchmark: %v", err)
+		c.String(formErrorStatus(err), "Failed to update benchmark: %v", err)
 		return
 	}
 
-	ctx := context.Background()
+	c.Redirect(http.StatusFound, "/")
+}
 
-	// ... (fetching portfolio stocks is the same) ...
-	var stocks []Stock
-	iter := firestoreClient.Collection("portfolio").Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil { /* ... error handling ... */
-		}
-		var stock Stock
-		doc.DataTo(&stock)
-		stocks = append(stocks, stock)
+// handleUpdateValueAveraging saves the value averaging plan. The monthly growth is
+// entered as percent on the form and stored as a fraction.
+func handleUpdateValueAveraging(c *gin.Context) {
+	value := func(field string) float64 {
+		v, _ := strconv.ParseFloat(strings.Replace(c.PostForm(field), ",", ".", -1), 64)
+		return v
 	}
+	va := ValueAveraging{
+		Enabled:         c.PostForm("enabled") == "true",
+		Start:           c.PostForm("start"),
+		StartValue:      value("startValue"),
+		MonthlyIncrease: value("monthlyIncrease"),
+		MonthlyGrowth:   value("monthlyGrowth") / 100,
+		MinContribution: value("minContribution"),
+		MaxContribution: value("maxContribution"),
+	}
+	if _, err := updateValueAveraging(c.Request.Context(), va); err != nil {
+		log.Printf("Failed to update value averaging: %v", err)
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
 
-	// --- FIX: Use 'Settings' struct and 'app' document ID ---
-	settingsDocRef := firestoreClient.Collection("settings").Doc("app")
-	doc, err := settingsDocRef.Get(ctx)
+func handleSearch(c *gin.Context) {
+	query := c.Query("query")
+	if query == "" {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	results, err := searchStocks(query)
 	if err != nil {
+		log.Printf("Error searching stocks: %v", err)
 		c.Redirect(http.StatusFound, "/")
 		return
 	}
-	var currentSettings Settings // Use the correct Settings struct
-	doc.DataTo(&currentSettings)
 
-	c.HTML(http.StatusOK, "index.tmpl.html", gin.H{
-		"stocks":        stocks,
-		"searchResults": results,
-		"currentBudget": currentSettings.Amount, // Pass the amount to the template
-	})
+	renderPortfolioPage(c, results)
 }
 
 func handleDelete(c *gin.Context) {
 	ticker := c.PostForm("ticker")
-	if ticker == "" {
-		c.String(http.StatusBadRequest, "Ticker is required")
-		return
-	}
-
-	ctx := context.Background()
-	// Delete the document with the matching ticker ID
-	_, err := firestoreClient.Collection("portfolio").Doc(ticker).Delete(ctx)
-	if err != nil {
+	if err := deleteStock(c.Request.Context(), ticker); err != nil {
 		log.Printf("Failed to delete stock %s: %v", ticker, err)
-		c.String(http.StatusInternalServerError, "Failed to delete stock")
+		c.String(formErrorStatus(err), "Failed to delete stock")
 		return
 	}
 
 	c.Redirect(http.StatusFound, "/")
 }
 
-// main.go
-
 func handleUpdate(c *gin.Context) {
 	// The ticker now comes from the button's value, not a query parameter
 	ticker := c.PostForm("ticker")
@@ -439,30 +512,23 @@ func handleUpdate(c *gin.Context) {
 	// Convert string values, handling potential commas from different locales
 	quantity, _ := strconv.ParseFloat(strings.Replace(quantityStr, ",", ".", -1), 64)
 	price, _ := strconv.ParseFloat(strings.Replace(priceStr, ",", ".", -1), 64)
-
-	if ticker == "" {
-		c.String(http.StatusBadRequest, "Ticker is required")
-		return
-	}
-
-	ctx := context.Background()
-	// Update specific fields in the document
-	_, err := firestoreClient.Collection("portfolio").Doc(ticker).Update(ctx, []firestore.Update{
-		{Path: "Quantity", Value: quantity},
-		{Path: "Price", Value: price},
-	})
-
-	if err != nil {
+	minOrder, _ := strconv.ParseFloat(strings.Replace(c.PostForm("min_order"), ",", ".", -1), 64)
+	rules := &OrderRules{WholeShares: c.PostForm("whole_shares") == "true", MinOrder: minOrder}
+	// The form shows target weights as percentages
+	targetWeight, _ := strconv.ParseFloat(strings.Replace(c.PostForm("target_weight"), ",", ".", -1), 64)
+	targetWeight /= 100
+	withholding, _ := strconv.ParseFloat(strings.Replace(c.PostForm("withholding"), ",", ".", -1), 64)
+	dividends := &DividendOptions{DRIP: c.PostForm("drip") == "true", Withholding: withholding / 100}
+
+	if err := updateHolding(c.Request.Context(), ticker, quantity, price, rules, &targetWeight, dividends); err != nil {
 		log.Printf("Failed to update stock %s: %v", ticker, err)
-		c.String(http.StatusInternalServerError, "Failed to update stock")
+		c.String(formErrorStatus(err), "Failed to update stock")
 		return
 	}
 
 	c.Redirect(http.StatusFound, "/")
 }
 
-// main.go
-
 func addStock(c *gin.Context) {
 	var newStock Stock
 
@@ -471,13 +537,15 @@ func addStock(c *gin.Context) {
 		c.String(http.StatusBadRequest, "bad request: %v", err)
 		return
 	}
+	// The form shows target weights as percentages
+	targetWeight, _ := strconv.ParseFloat(strings.Replace(c.PostForm("target_weight"), ",", ".", -1), 64)
+	newStock.TargetWeight = targetWeight / 100
+	withholding, _ := strconv.ParseFloat(strings.Replace(c.PostForm("withholding"), ",", ".", -1), 64)
+	newStock.Withholding = withholding / 100
 
-	ctx := context.Background()
-	// Use the Ticker as the document ID in the "portfolio" collection
-	_, err := firestoreClient.Collection("portfolio").Doc(newStock.Ticker).Set(ctx, newStock)
-	if err != nil {
+	if err := saveStock(c.Request.Context(), newStock); err != nil {
 		log.Printf("Failed to add stock: %v", err)
-		c.String(http.StatusInternalServerError, "Failed to add stock")
+		c.String(formErrorStatus(err), "Failed to add stock")
 		return
 	}
 
@@ -485,266 +553,22 @@ func addStock(c *gin.Context) {
 }
 
 func handleAnalysis(c *gin.Context) {
-	ctx := context.Background()
-	iter := firestoreClient.Collection("portfolio").Documents(ctx)
-
-	// This can be slow! In a real app, this would be a background job.
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("Failed to iterate for analysis: %v", err)
-			c.Redirect(http.StatusFound, "/")
-			return
-		}
-
-		var stock Stock
-		doc.DataTo(&stock)
-
-		currentPrice, ma200, emaTrend, _, err := fetchAndAnalyzeStock(stock.Ticker)
-		if err != nil {
-			fmt.Printf("Could not analyze %s: %v\n", stock.Ticker, err)
-			continue
-		}
-
-		// Update the struct with new data
-		stock.CurrentPrice = currentPrice
-		stock.MA200 = ma200
-		stock.IsBelowMA = currentPrice < ma200
-		stock.EMATrend = emaTrend
-
-		// Save the updated stock data back to Firestore
-		_, err = firestoreClient.Collection("portfolio").Doc(stock.Ticker).Set(ctx, stock)
-		if err != nil {
-			log.Printf("Failed to update stock %s: %v", stock.Ticker, err)
-		}
-	}
-
-	// Log the interaction to a separate "logs" collection
-	_, _, err := firestoreClient.Collection("logs").Add(ctx, map[string]interface{}{
-		"action":    "Portfolio Analyzed",
-		"timestamp": time.Now(),
-	})
-	if err != nil {
-		log.Printf("Failed to add log entry: %v", err)
+	if _, err := runAnalysis(c.Request.Context()); err != nil {
+		log.Printf("Failed to analyze portfolio: %v", err)
+		c.Redirect(http.StatusFound, "/")
+		return
 	}
 
 	c.Redirect(http.StatusFound, "/?status=analyzed")
 }
 
 func handleAllocation(c *gin.Context) {
-	ctx := context.Background()
-
-	// 1. Fetch the DYNAMIC SETTINGS (Budget and Batch Number)
-	settingsDocRef := firestoreClient.Collection("settings").Doc("app")
-	doc, err := settingsDocRef.Get(ctx)
-	if err != nil {
-		log.Printf("Could not fetch budget for allocation: %v", err)
+	if _, err := commitAllocation(c.Request.Context()); err != nil {
+		log.Printf("Failed to allocate budget: %v", err)
 		c.Redirect(http.StatusFound, "/")
 		return
 	}
-	var currentSettings Settings
-	doc.DataTo(&currentSettings)
-
-	budget := currentSettings.Amount
-	batchNumber := currentSettings.NextBatchNumber // Get the current batch number
-
-	// 2. Fetch all stocks from Firestore
-	var portfolioStocks []*Stock
-	iter := firestoreClient.Collection("portfolio").Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Printf("Failed to iterate for allocation: %v", err)
-			c.Redirect(http.StatusFound, "/")
-			return
-		}
-		var stock Stock
-		doc.DataTo(&stock)
-		portfolioStocks = append(portfolioStocks, &stock)
-	}
-
-	// 3. Primary Strategy
-	var eligibleStocks []*Stock
-	var totalScore float64
-	for _, stock := range portfolioStocks {
-		if stock.IsBelowMA && stock.MA200 > 0 && stock.CurrentPrice > 0 {
-			score := stock.MA200 - stock.CurrentPrice
-			totalScore += score
-			eligibleStocks = append(eligibleStocks, stock)
-		}
-	}
-
-	// 4. Handle Rollover or Allocation
-	if len(eligibleStocks) == 0 {
-		log.Println("No eligible stocks for investment. Budget will roll over.")
-	} else {
-		if totalScore > 0 {
-			recommendedTickers := make(map[string]bool)
-
-			for _, stock := range eligibleStocks {
-				score := stock.MA200 - stock.CurrentPrice
-				weight := score / totalScore
-				investmentAmount := budget * weight
-				quantityToBuy := investmentAmount / stock.CurrentPrice
-				recommendationText := fmt.Sprintf("Invest €%.2f", investmentAmount)
-
-				recommendedTickers[stock.Ticker] = true
-
-				totalOldValue := stock.Price * stock.Quantity
-				newTotalQuantity := stock.Quantity + quantityToBuy
-				newAveragePrice := (totalOldValue + investmentAmount) / newTotalQuantity
-
-				newTotalQuantity = math.Round(newTotalQuantity*100) / 100
-
-				// --- FIX: Remove the int() conversion for Quantity ---
-				_, err := firestoreClient.Collection("portfolio").Doc(stock.Ticker).Update(ctx, []firestore.Update{
-					{Path: "Quantity", Value: newTotalQuantity}, // Correctly pass the float64
-					{Path: "Price", Value: newAveragePrice},
-					{Path: "Recommendation", Value: recommendationText},
-				})
-				if err != nil {
-					log.Printf("Failed to auto-update portfolio for %s: %v", stock.Ticker, err)
-				}
-
-				// Log to 'investment_logs'
-				// This part is correct and doesn't need changes.
-				_, _, err = firestoreClient.Collection("investment_logs").Add(ctx, map[string]interface{}{
-					"batch":            batchNumber, // <-- ADD BATCH NUMBER
-					"ticker":           stock.Ticker,
-					"name":             stock.Name,
-					"investmentAmount": investmentAmount,
-					"pricePerShare":    stock.CurrentPrice,
-					"quantityBought":   quantityToBuy,
-					"strategy":         "200-Day MA Undervalued",
-					"timestamp":        time.Now(),
-				})
-				if err != nil {
-					log.Printf("Failed to add investment log for %s: %v", stock.Ticker, err)
-				}
-			}
-
-			// Clear recommendations for non-eligible stocks
-			for _, stock := range portfolioStocks {
-				if !recommendedTickers[stock.Ticker] {
-					firestoreClient.Collection("portfolio").Doc(stock.Ticker).Update(ctx, []firestore.Update{
-						{Path: "Recommendation", Value: ""},
-					})
-				}
-			}
-		}
 
-		// After a successful allocation, increment the batch number and reset the budget
-		_, err := settingsDocRef.Update(ctx, []firestore.Update{
-			{Path: "amount", Value: 100.0},
-			{Path: "nextBatchNumber", Value: batchNumber + 1}, // <-- INCREMENT BATCH
-		})
-		if err != nil {
-			log.Printf("Failed to reset budget after allocation: %v", err)
-		}
-	}
-
-	// 5. Naive Strategy (This part remains the same, just logging)
-	var totalPortfolioValue float64
-	// Recalculate total value based on original quantities for fair comparison
-	for _, stock := range portfolioStocks {
-		totalPortfolioValue += stock.CurrentPrice * float64(stock.Quantity)
-	}
-
-	if totalPortfolioValue > 0 {
-		for _, stock := range portfolioStocks {
-			stockValue := stock.CurrentPrice * float64(stock.Quantity)
-			weight := stockValue / totalPortfolioValue
-			investmentAmount := budget * weight
-			quantityToBuy := investmentAmount / stock.CurrentPrice
-
-			// Log this transaction to 'naive_strategy_logs'
-			_, _, err := firestoreClient.Collection("naive_strategy_logs").Add(ctx, map[string]interface{}{
-				"batch":            batchNumber,
-				"ticker":           stock.Ticker,
-				"name":             stock.Name,
-				"investmentAmount": investmentAmount,
-				"pricePerShare":    stock.CurrentPrice,
-				"quantityBought":   quantityToBuy,
-				"strategy":         "Naive Proportional Allocation",
-				"timestamp":        time.Now(),
-			})
-			if err != nil {
-				log.Printf("Failed to add naive strategy log for %s: %v", stock.Ticker, err)
-			}
-		}
-	}
-
-	// 6. EMA-112 Strategy
-	if len(portfolioStocks) >= 3 {
-		var mostNegativeStock, firstPositiveStock, secondPositiveStock *Stock
-		minEma := math.MaxFloat64
-		maxEma1, maxEma2 := -math.MaxFloat64, -math.MaxFloat64
-
-		for _, stock := range portfolioStocks {
-			if stock.EMATrend < minEma {
-				minEma = stock.EMATrend
-				mostNegativeStock = stock
-			}
-			if stock.EMATrend > maxEma1 {
-				maxEma2 = maxEma1
-				secondPositiveStock = firstPositiveStock
-				maxEma1 = stock.EMATrend
-				firstPositiveStock = stock
-			} else if stock.EMATrend > maxEma2 {
-				maxEma2 = stock.EMATrend
-				secondPositiveStock = stock
-			}
-		}
-
-		// Log the hypothetical "sell" of the most negative stock
-		if mostNegativeStock != nil && mostNegativeStock.EMATrend < 0 {
-			_, _, err := firestoreClient.Collection("ema_logs").Add(ctx, map[string]interface{}{
-				"batch":            batchNumber,
-				"ticker":           mostNegativeStock.Ticker,
-				"name":             mostNegativeStock.Name,
-				"investmentAmount": -mostNegativeStock.CurrentPrice, // Negative for sell
-				"pricePerShare":    mostNegativeStock.CurrentPrice,
-				"quantityBought":   -1, // Negative for sell
-				"strategy":         "ema-approach",
-				"timestamp":        time.Now(),
-			})
-			if err != nil {
-				log.Printf("Failed to add EMA strategy sell log for %s: %v", mostNegativeStock.Ticker, err)
-			}
-		}
-
-		// Log the hypothetical "buy" of the two most positive stocks
-		if firstPositiveStock != nil && secondPositiveStock != nil && firstPositiveStock.EMATrend > 0 && secondPositiveStock.EMATrend > 0 {
-			totalPositiveEma := firstPositiveStock.EMATrend + secondPositiveStock.EMATrend
-			stocksToBuy := []*Stock{firstPositiveStock, secondPositiveStock}
-
-			for _, stock := range stocksToBuy {
-				weight := stock.EMATrend / totalPositiveEma
-				investmentAmount := budget * weight
-				quantityToBuy := investmentAmount / stock.CurrentPrice
-
-				_, _, err := firestoreClient.Collection("ema_logs").Add(ctx, map[string]interface{}{
-					"batch":            batchNumber,
-					"ticker":           stock.Ticker,
-					"name":             stock.Name,
-					"investmentAmount": investmentAmount,
-					"pricePerShare":    stock.CurrentPrice,
-					"quantityBought":   quantityToBuy,
-					"strategy":         "ema-approach",
-					"timestamp":        time.Now(),
-				})
-				if err != nil {
-					log.Printf("Failed to add EMA strategy buy log for %s: %v", stock.Ticker, err)
-				}
-			}
-		}
-	}
 	c.Redirect(http.StatusFound, "/?status=allocated")
 }
 
@@ -755,11 +579,23 @@ func authMiddleware() gin.HandlerFunc {
 
 		// Check if user is authenticated
 		if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+			// API clients get a JSON error instead of being sent to the login page
+			if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, APIError{Error: "authentication required"})
+				return
+			}
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort() // Stop the request chain
 			return
 		}
 
+		// Attach the user to the request so the service layer can attribute changes
+		username, _ := session.Values["username"].(string)
+		if username == "" {
+			username = "unknown" // Sessions created before usernames were stored
+		}
+		c.Request = c.Request.WithContext(withActor(c.Request.Context(), Actor{User: username, IP: c.ClientIP()}))
+
 		// If authenticated, proceed to the next handler
 		c.Next()
 	}
@@ -774,13 +610,18 @@ func handleLogin(c *gin.Context) {
 	username := c.PostForm("username")
 	password := c.PostForm("password")
 
+	ctx := withActor(c.Request.Context(), Actor{User: username, IP: c.ClientIP()})
+
 	// Check if username and password ar valid
 	if expectedPassword, ok := users[username]; ok && expectedPassword == password {
 		session, _ := store.Get(c.Request, "session-name")
 		session.Values["authenticated"] = true
+		session.Values["username"] = username
 		session.Save(c.Request, c.Writer)
+		recordAudit(ctx, auditLogin, username, nil, nil, nil)
 		c.Redirect(http.StatusFound, "/")
 	} else {
+		recordAudit(ctx, auditLogin, username, nil, nil, errors.New("invalid credentials"))
 		// If login fails, render the login page with an error
 		c.HTML(http.StatusUnauthorized, "login.tmpl.html", gin.H{
 			"error": "Invalid credentials"})
@@ -789,105 +630,59 @@ func handleLogin(c *gin.Context) {
 
 func handleLogout(c *gin.Context) {
 	session, _ := store.Get(c.Request, "session-name")
+	username, _ := session.Values["username"].(string)
+	if auth, ok := session.Values["authenticated"].(bool); ok && auth {
+		ctx := withActor(c.Request.Context(), Actor{User: username, IP: c.ClientIP()})
+		recordAudit(ctx, auditLogout, username, nil, nil, nil)
+	}
 	session.Values["authenticated"] = false
+	delete(session.Values, "username")
 	session.Save(c.Request, c.Writer)
 	c.Redirect(http.StatusFound, "/login")
 }
 
-func getPriceOnDate(prices []HistoricalPrice, date time.Time) float64 {
-	targetDateStr := date.Format("2006-01-02")
-	lastKnownPrice := 0.0
-	for _, p := range prices {
-		if p.Date > targetDateStr {
-			break // Stop once we've passed the target date
-		}
-		lastKnownPrice = p.Close
-	}
-	return lastKnownPrice
-}
-
 func handlePortfolioHistory(c *gin.Context) {
-	ctx := context.Background()
+	ctx := c.Request.Context()
+	r, err := parseHistoryRange(c.Query("start"), c.Query("end"), c.Query("resolution"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-	// 1. Fetch all logs for both strategies
-	var allLogs []InvestmentLog
-	collections := []string{"investment_logs", "naive_strategy_logs"}
-	for _, coll := range collections {
-		iter := firestoreClient.Collection(coll).OrderBy("timestamp", firestore.Asc).Documents(ctx) // Order Ascending now
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				continue
-			}
-			var logEntry InvestmentLog
-			doc.DataTo(&logEntry)
-			allLogs = append(allLogs, logEntry)
-		}
+	// Under value averaging the real portfolio is shown against the target path
+	settings, err := getSettings(ctx)
+	if err != nil {
+		log.Printf("Failed to load settings: %v", err)
 	}
+	va := settings.ValueAveraging
 
-	if len(allLogs) == 0 {
-		c.JSON(http.StatusOK, []PortfolioHistoryPoint{})
+	h, err := buildPortfolioHistory(ctx, historyOptions{historyRange: r, strategies: settings.allStrategies(), actual: va.Enabled, benchmark: settings.Benchmark})
+	if err != nil {
+		log.Printf("Failed to load log history: %v", err)
+		respondError(c, err)
 		return
 	}
 
-	// 2. Get all unique tickers
-	tickers := make(map[string]bool)
-	for _, logEntry := range allLogs {
-		tickers[logEntry.Ticker] = true
+	history := PortfolioHistory{Strategies: []HistorySeries{}, Points: []PortfolioHistoryPoint{}}
+	for _, s := range h.strategies {
+		history.Strategies = append(history.Strategies, HistorySeries{Key: s.Key, Name: s.Name})
 	}
-
-	// 3. Fetch all required historical price data
-	priceHistory := make(map[string][]HistoricalPrice)
-	for ticker := range tickers {
-		_, _, _, historicalData, _ := fetchAndAnalyzeStock(ticker)
-		// Reverse the historical data so it's oldest to newest
-		for i, j := 0, len(historicalData)-1; i < j; i, j = i+1, j-1 {
-			historicalData[i], historicalData[j] = historicalData[j], historicalData[i]
-		}
-		priceHistory[ticker] = historicalData
-	}
-
-	// 4. Reconstruct portfolio values over time
-	var history []PortfolioHistoryPoint
-	holdingsMA := make(map[string]float64)
-	holdingsNaive := make(map[string]float64)
-	logIndex := 0
-
-	// Iterate from the first investment day to today
-	for d := allLogs[0].Timestamp; !d.After(time.Now()); d = d.AddDate(0, 0, 1) {
-		// Add any shares "bought" on this day
-		for logIndex < len(allLogs) && !allLogs[logIndex].Timestamp.After(d) {
-			logEntry := allLogs[logIndex]
-			if logEntry.Strategy == "200-Day MA Undervalued" {
-				holdingsMA[logEntry.Ticker] += logEntry.QuantityBought
-			} else {
-				holdingsNaive[logEntry.Ticker] += logEntry.QuantityBought
-			}
-			logIndex++
+	benchmark := h.benchmarkFor(h.series[primaryStrategyKey], h.flows[primaryStrategyKey])
+	for i, d := range h.dates {
+		point := PortfolioHistoryPoint{Date: d.UnixMilli(), Values: make(map[string]float64)}
+		for _, s := range h.strategies {
+			point.Values[s.Key] = h.series[s.Key][i].Value
 		}
-
-		// We only need to calculate the value at the end of each week (Friday)
-		if d.Weekday() != time.Friday {
-			continue
+		if target, ok := va.target(d); ok && va.Enabled {
+			point.TargetValue = target
 		}
-
-		// Calculate total portfolio value using the most recent price available
-		var maValue, naiveValue float64
-		for ticker, qty := range holdingsMA {
-			maValue += qty * getPriceOnDate(priceHistory[ticker], d)
+		if i < len(h.actual) {
+			point.ActualValue = h.actual[i].Value
 		}
-		for ticker, qty := range holdingsNaive {
-			naiveValue += qty * getPriceOnDate(priceHistory[ticker], d)
+		if benchmark != nil {
+			point.BenchmarkValue = benchmark[i].Value
 		}
-
-		history = append(history, PortfolioHistoryPoint{
-			Date:       d.UnixMilli(),
-			MAValue:    maValue,
-			NaiveValue: naiveValue,
-		})
+		history.Points = append(history.Points, point)
 	}
 
 	c.JSON(http.StatusOK, history)
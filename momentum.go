@@ -0,0 +1,72 @@
+package main
+
+import "sort"
+
+// momentumLookbacks are the periods, in trading days, whose total returns are averaged
+// into a stock's momentum score: about 3, 6 and 12 months.
+var momentumLookbacks = []int{63, 126, 252}
+
+// momentumScore returns the average total return of a stock over momentumLookbacks,
+// each ending skip trading days ago, from the closes in basis. ok is false when the
+// history is too short.
+func momentumScore(history []HistoricalPrice, skip int, basis priceBasis) (score float64, ok bool) {
+	// The history is ordered newest first
+	longest := momentumLookbacks[len(momentumLookbacks)-1]
+	if len(history) <= skip+longest || history[skip].value(basis) <= 0 {
+		return 0, false
+	}
+	end := history[skip].value(basis)
+	for _, lookback := range momentumLookbacks {
+		start := history[skip+lookback].value(basis)
+		if start <= 0 {
+			return 0, false
+		}
+		score += end/start - 1
+	}
+	return score / float64(len(momentumLookbacks)), true
+}
+
+// allocateMomentum ranks the stocks by their momentum score and splits the budget
+// between the params.MomentumWinners best ones, weighted by the inverse of their
+// volatility so each contributes about the same risk. Stocks with a negative score are
+// never bought, even when they rank in the top. Every entry records its rank and score.
+func allocateMomentum(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	type ranked struct {
+		stock *Stock
+		score float64
+	}
+	var ranking []ranked
+	for _, stock := range stocks {
+		if score, ok := momentumScore(stock.History, params.MomentumSkip, params.basis()); ok && stock.CurrentPrice > 0 {
+			ranking = append(ranking, ranked{stock, score})
+		}
+	}
+	sort.SliceStable(ranking, func(i, j int) bool { return ranking[i].score > ranking[j].score })
+
+	weights := make([]float64, min(len(ranking), params.MomentumWinners))
+	var total float64
+	for i := range weights {
+		if ranking[i].score <= 0 {
+			continue
+		}
+		closes := closesIn(ranking[i].stock.History, newestFirst, params.basis())
+		if vol, ok := returnVolatility(closes, params.RiskWindow); ok && vol > 0 {
+			weights[i] = 1 / vol
+			total += weights[i]
+		}
+	}
+	if total == 0 || budget <= 0 {
+		return nil
+	}
+
+	var entries []AllocationEntry
+	for i, w := range weights {
+		if w == 0 {
+			continue
+		}
+		entry := buyEntry(ranking[i].stock, budget*w/total)
+		entry.Rank, entry.Score = i+1, ranking[i].score
+		entries = append(entries, entry)
+	}
+	return entries
+}
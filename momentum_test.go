@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// trendHistory returns days closes ordered newest first, where the close k days ago
+// is price(k).
+func trendHistory(days int, price func(k int) float64) []HistoricalPrice {
+	history := make([]HistoricalPrice, days)
+	for k := range history {
+		history[k] = HistoricalPrice{Date: day("2024-12-31").AddDate(0, 0, -k).Format("2006-01-02"), Close: price(k)}
+	}
+	return history
+}
+
+// uptrend gains a point a day, downtrend loses one.
+func uptrend(k int) float64   { return 400 - float64(k) }
+func downtrend(k int) float64 { return 100 + float64(k) }
+
+func TestMomentumScore(t *testing.T) {
+	history := trendHistory(300, uptrend)
+	adjusted := trendHistory(300, uptrend)
+	for k := range adjusted {
+		adjusted[k].AdjClose = adjusted[k].Close + 600
+	}
+	tests := []struct {
+		name    string
+		history []HistoricalPrice
+		skip    int
+		basis   priceBasis
+		ok      bool
+		want    float64
+	}{
+		// The returns over 63, 126 and 252 days ending a month ago: 379 against 316, 253 and 127
+		{"skipping a month", history, 21, rawClose, true, (379.0/316 + 379.0/253 + 379.0/127 - 3) / 3},
+		{"skipping a day", history, 1, rawClose, true, (399.0/336 + 399.0/273 + 399.0/147 - 3) / 3},
+		{"downtrend", trendHistory(300, downtrend), 21, rawClose, true, (121.0/184 + 121.0/247 + 121.0/373 - 3) / 3},
+		{"just long enough", history[:274], 21, rawClose, true, (379.0/316 + 379.0/253 + 379.0/127 - 3) / 3},
+		{"too short for the longest lookback", history[:273], 21, rawClose, false, 0},
+		{"no close at the end", trendHistory(300, func(k int) float64 { return float64(21 - k) }), 21, rawClose, false, 0},
+		// Adjusted closes 600 above the raw ones
+		{"adjusted", adjusted, 21, adjustedClose, true, (979.0/916 + 979.0/853 + 979.0/727 - 3) / 3},
+		{"raw closes of an adjusted history", adjusted, 21, rawClose, true, (379.0/316 + 379.0/253 + 379.0/127 - 3) / 3},
+	}
+	for _, tt := range tests {
+		score, ok := momentumScore(tt.history, tt.skip, tt.basis)
+		if ok != tt.ok || math.Abs(score-tt.want) > 1e-12 {
+			t.Errorf("%s: momentumScore = %v, %v; want %v, %v", tt.name, score, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// zigzag replaces the newest 21 closes of history with a price that alternates
+// between the close 21 days ago and amplitude above it, which changes the recent
+// volatility but none of the closes the momentum score uses.
+func zigzag(history []HistoricalPrice, amplitude float64) []HistoricalPrice {
+	zigzagged := append([]HistoricalPrice(nil), history...)
+	base := history[21].Close
+	for k := 0; k <= 20; k++ {
+		zigzagged[k].Close = base
+		if k%2 == 1 {
+			zigzagged[k].Close = base * (1 + amplitude)
+		}
+	}
+	return zigzagged
+}
+
+// sampleStdDev is the sample standard deviation of the daily returns between the
+// newest n+1 closes of history.
+func sampleStdDev(history []HistoricalPrice, n int) float64 {
+	returns := make([]float64, n)
+	for d := range returns {
+		returns[d] = history[d].Close/history[d+1].Close - 1
+	}
+	m := mean(returns)
+	var sum float64
+	for _, r := range returns {
+		sum += (r - m) * (r - m)
+	}
+	return math.Sqrt(sum / float64(n-1))
+}
+
+func TestAllocateMomentum(t *testing.T) {
+	params := defaultStrategyParams
+	params.RiskWindow, params.MomentumWinners = 20, 3
+
+	calm := &Stock{Ticker: "CALM", CurrentPrice: 400, History: zigzag(trendHistory(300, uptrend), 0.01)}
+	wild := &Stock{Ticker: "WILD", CurrentPrice: 400, History: zigzag(trendHistory(300, uptrend), 0.02)}
+	loser := &Stock{Ticker: "LOSER", CurrentPrice: 100, History: trendHistory(300, downtrend)}
+	young := &Stock{Ticker: "YOUNG", CurrentPrice: 50, History: trendHistory(200, uptrend)}
+
+	entries := allocateMomentum([]*Stock{loser, young, wild, calm}, 1000, params)
+	// LOSER ranks third but has a negative score, YOUNG has too little history
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want CALM and WILD: %+v", len(entries), entries)
+	}
+
+	score, _ := momentumScore(calm.History, params.MomentumSkip, rawClose)
+	volCalm, volWild := sampleStdDev(calm.History, 20), sampleStdDev(wild.History, 20)
+	// Both score the same, so the stable sort keeps WILD, which came first, on top
+	want := map[string]struct {
+		rank   int
+		amount float64
+	}{
+		"WILD": {1, 1000 * (1 / volWild) / (1/volCalm + 1/volWild)},
+		"CALM": {2, 1000 * (1 / volCalm) / (1/volCalm + 1/volWild)},
+	}
+	for _, e := range entries {
+		w, ok := want[e.Ticker]
+		if !ok {
+			t.Errorf("bought %s", e.Ticker)
+			continue
+		}
+		if e.Rank != w.rank || math.Abs(e.Score-score) > 1e-12 || math.Abs(e.InvestmentAmount-w.amount) > 1e-6 {
+			t.Errorf("%s: rank %d, score %v, amount %v; want %d, %v, %v", e.Ticker, e.Rank, e.Score, e.InvestmentAmount, w.rank, score, w.amount)
+		}
+	}
+	// Half the volatility gets about twice the money
+	if ratio := want["CALM"].amount / want["WILD"].amount; ratio < 1.9 || ratio > 2.1 {
+		t.Errorf("CALM gets %v times the amount of WILD, want about 2", ratio)
+	}
+
+	params.MomentumWinners = 1
+	if entries := allocateMomentum([]*Stock{loser, wild, calm}, 1000, params); len(entries) != 1 || entries[0].Ticker != "WILD" || entries[0].InvestmentAmount != 1000 {
+		t.Errorf("one winner: got %+v, want all of the budget in WILD", entries)
+	}
+	if entries := allocateMomentum([]*Stock{loser, young}, 1000, params); entries != nil {
+		t.Errorf("no positive score: got %+v, want nothing", entries)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The Monte Carlo simulation runs the strategies over many synthetic futures instead
+// of the single path history took. Each path is built with a block bootstrap: blocks
+// of consecutive daily returns are drawn from the historical sample and chained
+// together, which keeps short-term autocorrelation, and every block takes the
+// returns of all tickers from the same days, which keeps their correlation.
+
+const (
+	defaultMonteCarloPaths = 1000
+	maxMonteCarloPaths     = 10000
+	defaultBlockSize       = 20 // About a month of trading days
+	tradingDaysPerMonth    = 21
+)
+
+// MonteCarloRequest is the body accepted by POST /api/v1/backtests/montecarlo. The
+// backtest's start and end select the historical sample the returns are drawn from;
+// the simulated paths start the day after end.
+type MonteCarloRequest struct {
+	Backtest      BacktestRequest `json:"backtest" openapi:"required"`
+	Paths         int             `json:"paths" openapi:"min=1,max=10000"`       // Defaults to 1000
+	HorizonMonths int             `json:"horizonMonths" openapi:"min=1,max=600"` // Defaults to 60
+	BlockSize     int             `json:"blockSize" openapi:"min=1,max=250"`     // Trading days per block, defaults to 20
+	Seed          uint64          `json:"seed"`                                  // Defaults to 1; the same seed gives the same result
+}
+
+// StrategyOutcome is the distribution of a strategy's terminal value across paths.
+type StrategyOutcome struct {
+	Key      string  `json:"key"`
+	Strategy string  `json:"strategy"`
+	Mean     float64 `json:"mean"`
+	Median   float64 `json:"median"`
+	P5       float64 `json:"p5"`
+	P95      float64 `json:"p95"`
+	// ProbBelowBaseline is the share of paths where the strategy ended below the
+	// naive strategy on the same path.
+	ProbBelowBaseline float64 `json:"probBelowBaseline"`
+}
+
+// MonteCarloResult is the outcome of a Monte Carlo simulation.
+type MonteCarloResult struct {
+	Seed        uint64            `json:"seed"`
+	Paths       int               `json:"paths"`
+	BlockSize   int               `json:"blockSize"`
+	SampleStart string            `json:"sampleStart"` // First day of the historical returns drawn from
+	SampleEnd   string            `json:"sampleEnd"`
+	Start       string            `json:"start"` // First simulated day
+	End         string            `json:"end"`
+	Contributed float64           `json:"contributed"` // The same on every path
+	Baseline    string            `json:"baseline"`
+	Strategies  []StrategyOutcome `json:"strategies"`
+}
+
+// returnSample is the historical data a simulation draws from.
+type returnSample struct {
+	tickers []string
+	warmup  map[string][]HistoricalPrice // Real prices the synthetic paths continue from
+	returns [][]float64                  // Daily simple returns, one row per day, one column per ticker
+	start   string
+	end     string
+}
+
+// buildReturnSample aligns the prices of all tickers on the days they all traded and
+// computes daily returns between from and to. The last warmupLen aligned days are
+// kept as the warm-up every synthetic path starts from.
+func buildReturnSample(tickers []string, prices map[string][]HistoricalPrice, from, to time.Time, warmupLen int) (returnSample, error) {
+	sample := returnSample{tickers: tickers, warmup: make(map[string][]HistoricalPrice)}
+
+	closes := make([]map[string]float64, len(tickers))
+	counts := make(map[string]int)
+	for i, ticker := range tickers {
+		closes[i] = make(map[string]float64)
+		for _, p := range prices[ticker] {
+			if p.Close > 0 {
+				closes[i][p.Date] = p.Close
+				counts[p.Date]++
+			}
+		}
+	}
+	var days []string
+	for day, n := range counts {
+		if n == len(tickers) && day <= to.Format("2006-01-02") {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	if len(days) == 0 {
+		return sample, fmt.Errorf("%w: the tickers have no trading days in common", errInvalidInput)
+	}
+
+	first := from.Format("2006-01-02")
+	for d := 1; d < len(days); d++ {
+		if days[d] < first {
+			continue
+		}
+		if sample.start == "" {
+			sample.start = days[d]
+		}
+		sample.end = days[d]
+		row := make([]float64, len(tickers))
+		for i := range tickers {
+			row[i] = closes[i][days[d]]/closes[i][days[d-1]] - 1
+		}
+		sample.returns = append(sample.returns, row)
+	}
+
+	for _, day := range days[max(0, len(days)-warmupLen):] {
+		for i, ticker := range tickers {
+			sample.warmup[ticker] = append(sample.warmup[ticker], HistoricalPrice{Date: day, Close: closes[i][day]})
+		}
+	}
+	return sample, nil
+}
+
+// tradingDaysAfter returns the next n weekdays after last, a date in YYYY-MM-DD format.
+func tradingDaysAfter(last string, n int) []string {
+	d, _ := time.Parse("2006-01-02", last)
+	days := make([]string, 0, n)
+	for len(days) < n {
+		d = d.AddDate(0, 0, 1)
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			days = append(days, d.Format("2006-01-02"))
+		}
+	}
+	return days
+}
+
+// syntheticPrices continues the warm-up prices over days using bootstrapped blocks of
+// historical returns.
+func (s returnSample) syntheticPrices(rng *rand.Rand, days []string, blockSize int) map[string][]HistoricalPrice {
+	prices := make(map[string][]HistoricalPrice, len(s.tickers))
+	last := make([]float64, len(s.tickers))
+	for i, ticker := range s.tickers {
+		warmup := s.warmup[ticker]
+		series := make([]HistoricalPrice, len(warmup), len(warmup)+len(days))
+		copy(series, warmup)
+		prices[ticker] = series
+		last[i] = warmup[len(warmup)-1].Close
+	}
+
+	blockSize = min(blockSize, len(s.returns))
+	for d := 0; d < len(days); {
+		start := rng.IntN(len(s.returns) - blockSize + 1)
+		for _, row := range s.returns[start : start+blockSize] {
+			if d == len(days) {
+				break
+			}
+			for i, ticker := range s.tickers {
+				last[i] *= 1 + row[i]
+				prices[ticker] = append(prices[ticker], HistoricalPrice{Date: days[d], Close: last[i]})
+			}
+			d++
+		}
+	}
+	return prices
+}
+
+// percentile returns the p-th percentile (0-100) of sorted values, interpolating
+// between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// runMonteCarlo simulates cfg over paths synthetic futures drawn from sample, spread
+// across all CPU cores. Path i always uses the random stream (seed, i), so the result
+// only depends on the seed and not on how the paths were scheduled.
+func runMonteCarlo(cfg BacktestConfig, sample returnSample, paths, horizonDays, blockSize int, seed uint64) MonteCarloResult {
+	days := tradingDaysAfter(sample.end, horizonDays)
+	cfg.Start, _ = time.Parse("2006-01-02", days[0])
+	cfg.End, _ = time.Parse("2006-01-02", days[len(days)-1])
+
+	// finals[s][i] is the terminal value of strategy s on path i
+	strategies := cfg.strategies()
+	finals := make([][]float64, len(strategies))
+	for s := range finals {
+		finals[s] = make([]float64, paths)
+	}
+	var contributed float64
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				rng := rand.New(rand.NewPCG(seed, uint64(i)))
+				result := runBacktest(cfg, sample.syntheticPrices(rng, days, blockSize))
+				for s, sb := range result.Strategies {
+					finals[s][i] = sb.FinalValue
+				}
+				once.Do(func() { contributed = result.Strategies[0].Contributed })
+			}
+		}()
+	}
+	for i := 0; i < paths; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	result := MonteCarloResult{
+		Seed:        seed,
+		Paths:       paths,
+		BlockSize:   blockSize,
+		SampleStart: sample.start,
+		SampleEnd:   sample.end,
+		Start:       days[0],
+		End:         days[len(days)-1],
+		Contributed: contributed,
+		Baseline:    sweepBaseline,
+	}
+	baseline := -1
+	for s, strategy := range strategies {
+		if strategy.Key == sweepBaseline {
+			baseline = s
+		}
+	}
+	for s, strategy := range strategies {
+		outcome := StrategyOutcome{Key: strategy.Key, Strategy: strategy.Name, Mean: mean(finals[s])}
+		if baseline >= 0 && s != baseline {
+			var below int
+			for i, v := range finals[s] {
+				if v < finals[baseline][i] {
+					below++
+				}
+			}
+			outcome.ProbBelowBaseline = float64(below) / float64(paths)
+		}
+		sorted := append([]float64(nil), finals[s]...)
+		sort.Float64s(sorted)
+		outcome.Median = percentile(sorted, 50)
+		outcome.P5 = percentile(sorted, 5)
+		outcome.P95 = percentile(sorted, 95)
+		result.Strategies = append(result.Strategies, outcome)
+	}
+	return result
+}
+
+// monteCarlo validates req, fetches the historical sample and runs the simulation.
+func monteCarlo(ctx context.Context, req MonteCarloRequest) (MonteCarloResult, error) {
+	cfg, err := req.Backtest.backtestConfig(ctx)
+	if err != nil {
+		return MonteCarloResult{}, err
+	}
+	if req.Paths <= 0 {
+		req.Paths = defaultMonteCarloPaths
+	}
+	if req.Paths > maxMonteCarloPaths {
+		return MonteCarloResult{}, fmt.Errorf("%w: at most %d paths can be simulated", errInvalidInput, maxMonteCarloPaths)
+	}
+	if req.HorizonMonths <= 0 {
+		req.HorizonMonths = 60
+	}
+	if req.BlockSize <= 0 {
+		req.BlockSize = defaultBlockSize
+	}
+	if req.Seed == 0 {
+		req.Seed = 1
+	}
+
+	prices, err := fetchBacktestPrices(cfg)
+	if err != nil {
+		return MonteCarloResult{}, fmt.Errorf("%w: %v", errUpstream, err)
+	}
+	warmupLen := cfg.Params.lookback()
+	sample, err := buildReturnSample(cfg.Tickers, prices, cfg.Start, cfg.End, warmupLen)
+	if err != nil {
+		return MonteCarloResult{}, err
+	}
+	if len(sample.returns) < req.BlockSize {
+		return MonteCarloResult{}, fmt.Errorf("%w: the sample has %d days of returns, fewer than one block of %d",
+			errInvalidInput, len(sample.returns), req.BlockSize)
+	}
+	return runMonteCarlo(cfg, sample, req.Paths, req.HorizonMonths*tradingDaysPerMonth, req.BlockSize, req.Seed), nil
+}
+
+func apiRunMonteCarlo(c *gin.Context) {
+	var req MonteCarloRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+	result, err := monteCarlo(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
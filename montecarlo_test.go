@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// testSample is a return sample of two tickers whose daily returns follow a fixed
+// wave, so every path differs but nothing depends on the clock.
+func testSample(days int) returnSample {
+	sample := returnSample{
+		tickers: []string{"A", "B"},
+		warmup: map[string][]HistoricalPrice{
+			"A": dailyPrices("2024-01-01", 30, 10),
+			"B": dailyPrices("2024-01-01", 30, 20),
+		},
+		start: "2024-01-02",
+		end:   "2024-01-30",
+	}
+	for d := 0; d < days; d++ {
+		sample.returns = append(sample.returns, []float64{0.02 * math.Sin(float64(d)), 0.01 * math.Cos(float64(d)/3)})
+	}
+	return sample
+}
+
+func TestRunMonteCarloIsDeterministic(t *testing.T) {
+	cfg := BacktestConfig{Tickers: []string{"A", "B"}, Contribution: 100, Frequency: "weekly", Initial: 1000, Params: defaultStrategyParams}
+	sample := testSample(60)
+
+	first := runMonteCarlo(cfg, sample, 40, 60, 5, 42)
+	if again := runMonteCarlo(cfg, sample, 40, 60, 5, 42); !reflect.DeepEqual(first, again) {
+		t.Errorf("the same seed gave different results:\n%+v\n%+v", first, again)
+	}
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	if single := runMonteCarlo(cfg, sample, 40, 60, 5, 42); !reflect.DeepEqual(first, single) {
+		t.Errorf("GOMAXPROCS=1 changed the result:\n%+v\n%+v", first, single)
+	}
+
+	if other := runMonteCarlo(cfg, sample, 40, 60, 5, 43); reflect.DeepEqual(first.Strategies, other.Strategies) {
+		t.Error("a different seed gave the same outcomes")
+	}
+}
+
+// drawnRows recovers which sample row produced each synthetic day of ticker A, whose
+// returns are unique per row in blockSample.
+func drawnRows(t *testing.T, series []HistoricalPrice, warmup int) []int {
+	t.Helper()
+	var rows []int
+	for d := warmup; d < len(series); d++ {
+		r := series[d].Close/series[d-1].Close - 1
+		row := int(math.Round(r*1000)) - 1
+		if math.Abs(r-0.001*float64(row+1)) > 1e-9 {
+			t.Fatalf("day %d has return %v, which is not in the sample", d, r)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestSyntheticPricesBlocks(t *testing.T) {
+	// Row k of the sample returns (k+1)/1000, so each drawn row can be identified
+	blockSample := testSample(0)
+	for k := 0; k < 20; k++ {
+		blockSample.returns = append(blockSample.returns, []float64{0.001 * float64(k+1), 0})
+	}
+	days := tradingDaysAfter(blockSample.end, 23)
+
+	tests := []struct {
+		blockSize int
+		wantSize  int
+	}{
+		{1, 1},
+		{5, 5},
+		{20, 20},
+		{50, 20}, // Longer than the sample, so every block is the whole sample
+	}
+	for _, tt := range tests {
+		prices := blockSample.syntheticPrices(rand.New(rand.NewPCG(7, 1)), days, tt.blockSize)
+		if got := len(prices["A"]) - 30; got != len(days) {
+			t.Fatalf("block size %d: %d synthetic days, want %d", tt.blockSize, got, len(days))
+		}
+		rows := drawnRows(t, prices["A"], 30)
+		for d := range rows {
+			if d%tt.wantSize == 0 {
+				if start := rows[d]; start+tt.wantSize > 20 {
+					t.Errorf("block size %d: block at day %d starts at row %d and runs past the sample", tt.blockSize, d, start)
+				}
+				continue
+			}
+			if rows[d] != rows[d-1]+1 {
+				t.Errorf("block size %d: day %d drew row %d after row %d, want consecutive rows within a block", tt.blockSize, d, rows[d], rows[d-1])
+			}
+		}
+
+		again := blockSample.syntheticPrices(rand.New(rand.NewPCG(7, 1)), days, tt.blockSize)
+		if !reflect.DeepEqual(prices, again) {
+			t.Errorf("block size %d: the same random stream gave different prices", tt.blockSize)
+		}
+	}
+}
+
+func TestTradingDaysAfter(t *testing.T) {
+	got := tradingDaysAfter("2024-01-04", 4) // A Thursday
+	want := []string{"2024-01-05", "2024-01-08", "2024-01-09", "2024-01-10"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tradingDaysAfter = %v, want %v", got, want)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	tests := []struct {
+		p, want float64
+	}{
+		{0, 1},
+		{50, 3},
+		{100, 5},
+		{5, 1.2},
+		{95, 4.8},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI document is generated from apiRoutes and the Go types used by the
+// handlers, so it cannot drift from what the server actually does. Field constraints
+// are declared with an `openapi` struct tag, e.g. `openapi:"required,min=0"`:
+//
+//	required      the property must be present
+//	min=, max=    numeric bounds (inclusive)
+//	minLength=    minimum string length
+//	format=       string format, e.g. date or date-time
+//	enum=a|b      allowed values
+//
+// The same document then drives validateRequest, so every route rejects invalid
+// payloads in the same way.
+
+// Schema is the subset of an OpenAPI 3 schema object used by this service.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Parameter describes a path or query parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// MediaType holds the schema of a JSON request or response body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// RequestBody describes the JSON body accepted by an operation.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes one possible response of an operation.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Operation is a single method on a path.
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// OpenAPIDoc is the root of the OpenAPI 3 document served at /api/openapi.json.
+type OpenAPIDoc struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       map[string]string                `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+}
+
+// apiRoute ties a handler to the metadata needed to describe and validate it.
+type apiRoute struct {
+	Method    string
+	Path      string // Gin-style path, e.g. /api/v1/holdings/:ticker
+	ID        string // OpenAPI operationId
+	Summary   string
+	Tag       string
+	Query     []Parameter
+	Body      any         // Zero value of the request body type, nil if the route takes no body
+	Responses map[int]any // Zero value of the response body per status code, nil for an empty body
+	Handler   gin.HandlerFunc
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIDocV *OpenAPIDoc
+)
+
+// openAPISpec returns the OpenAPI document generated from apiRoutes.
+func openAPISpec() *OpenAPIDoc {
+	openAPIOnce.Do(func() {
+		openAPIDocV = buildOpenAPISpec(apiRoutes())
+	})
+	return openAPIDocV
+}
+
+// serveOpenAPISpec handles GET /api/openapi.json.
+func serveOpenAPISpec(c *gin.Context) {
+	c.JSON(http.StatusOK, openAPISpec())
+}
+
+func buildOpenAPISpec(routes []apiRoute) *OpenAPIDoc {
+	doc := &OpenAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    map[string]string{"title": "Portfolio Balancing API", "version": "1.0.0"},
+		Paths:   make(map[string]map[string]*Operation),
+	}
+	doc.Components.Schemas = make(map[string]*Schema)
+	gen := &schemaGenerator{components: doc.Components.Schemas}
+
+	for _, r := range routes {
+		op := &Operation{OperationID: r.ID, Summary: r.Summary, Responses: make(map[string]Response)}
+		if r.Tag != "" {
+			op.Tags = []string{r.Tag}
+		}
+
+		path := openAPIPath(r.Path)
+		for _, segment := range strings.Split(r.Path, "/") {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+			}
+		}
+		for _, p := range r.Query {
+			p.In = "query"
+			op.Parameters = append(op.Parameters, p)
+		}
+
+		if r.Body != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: gen.schemaFor(reflect.TypeOf(r.Body))}},
+			}
+		}
+
+		for code, body := range r.Responses {
+			resp := Response{Description: http.StatusText(code)}
+			if body != nil {
+				resp.Content = map[string]MediaType{"application/json": {Schema: gen.schemaFor(reflect.TypeOf(body))}}
+			}
+			op.Responses[strconv.Itoa(code)] = resp
+		}
+		op.Responses["default"] = Response{
+			Description: "Error",
+			Content:     map[string]MediaType{"application/json": {Schema: gen.schemaFor(reflect.TypeOf(APIError{}))}},
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Operation)
+		}
+		doc.Paths[path][strings.ToLower(r.Method)] = op
+	}
+	return doc
+}
+
+// schemaGenerator turns Go types into schemas, registering named structs as components.
+type schemaGenerator struct {
+	components map[string]*Schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		s := *g.schemaFor(t.Elem())
+		s.Nullable = true
+		return &s
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		format := "int32"
+		if t.Bits() == 64 {
+			format = "int64"
+		}
+		return &Schema{Type: "integer", Format: format}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.components[t.Name()]; !ok {
+			g.components[t.Name()] = nil // Placeholder so recursive types terminate
+			g.components[t.Name()] = g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := g.schemaFor(field.Type)
+		if tag := field.Tag.Get("openapi"); tag != "" {
+			for _, opt := range strings.Split(tag, ",") {
+				key, value, _ := strings.Cut(opt, "=")
+				if prop.Ref != "" && key != "required" {
+					continue // Siblings of a $ref are ignored in OpenAPI 3.0
+				}
+				switch key {
+				case "required":
+					s.Required = append(s.Required, name)
+				case "min":
+					v, _ := strconv.ParseFloat(value, 64)
+					prop.Minimum = &v
+				case "max":
+					v, _ := strconv.ParseFloat(value, 64)
+					prop.Maximum = &v
+				case "minLength":
+					v, _ := strconv.Atoi(value)
+					prop.MinLength = &v
+				case "format":
+					prop.Format = value
+				case "enum":
+					prop.Enum = strings.Split(value, "|")
+				}
+			}
+		}
+		s.Properties[name] = prop
+	}
+	return s
+}
+
+// queryParam is a shorthand for declaring a query parameter on an apiRoute.
+func queryParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, Description: description, Schema: schema}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// validateRequest returns middleware that checks the query parameters and JSON body
+// of a request against the OpenAPI operation generated for the route.
+func validateRequest(r apiRoute) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		doc := openAPISpec()
+		op := doc.Paths[openAPIPath(r.Path)][strings.ToLower(r.Method)]
+		if op == nil {
+			c.Next()
+			return
+		}
+
+		var problems []string
+		for _, p := range op.Parameters {
+			if p.In != "query" {
+				continue
+			}
+			raw, ok := c.GetQuery(p.Name)
+			if !ok {
+				if p.Required {
+					problems = append(problems, fmt.Sprintf("query.%s: is required", p.Name))
+				}
+				continue
+			}
+			value, err := parseQueryValue(p.Schema, raw)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("query.%s: %v", p.Name, err))
+				continue
+			}
+			problems = append(problems, doc.validate(p.Schema, value, "query."+p.Name)...)
+		}
+
+		if op.RequestBody != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				badRequest(c, "failed to read request body")
+				return
+			}
+			// Let the handler bind the body again
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			if len(bytes.TrimSpace(body)) == 0 {
+				problems = append(problems, "body: is required")
+			} else {
+				dec := json.NewDecoder(bytes.NewReader(body))
+				dec.UseNumber()
+				var value any
+				if err := dec.Decode(&value); err != nil {
+					problems = append(problems, "body: invalid JSON: "+err.Error())
+				} else {
+					problems = append(problems, doc.validate(op.RequestBody.Content["application/json"].Schema, value, "body")...)
+				}
+			}
+		}
+
+		if len(problems) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, APIError{Error: "request validation failed", Details: problems})
+			return
+		}
+		c.Next()
+	}
+}
+
+// openAPIPath converts a Gin path such as /holdings/:ticker into /holdings/{ticker}.
+func openAPIPath(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// parseQueryValue converts a raw query string into the JSON value its schema expects.
+func parseQueryValue(s *Schema, raw string) (any, error) {
+	switch s.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			return nil, fmt.Errorf("must be a %s", s.Type)
+		}
+		return json.Number(raw), nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+// validate checks value (as decoded by encoding/json with UseNumber) against s and
+// returns a description of every violation found.
+func (doc *OpenAPIDoc) validate(s *Schema, value any, path string) []string {
+	if s == nil || (value == nil && s.Nullable) {
+		return nil
+	}
+	if s.Ref != "" {
+		return doc.validate(doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")], value, path)
+	}
+	if value == nil {
+		if s.Type == "" {
+			return nil
+		}
+		return []string{path + ": must not be null"}
+	}
+
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return problems
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, path+"."+name+": is required")
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if prop, ok := s.Properties[k]; ok {
+				problems = append(problems, doc.validate(prop, obj[k], path+"."+k)...)
+			} else if s.AdditionalProperties != nil {
+				problems = append(problems, doc.validate(s.AdditionalProperties, obj[k], path+"."+k)...)
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			return problems
+		}
+		for i, item := range arr {
+			problems = append(problems, doc.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be a %s", s.Type)
+			return problems
+		}
+		f, err := n.Float64()
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			fail("must be a %s", s.Type)
+			return problems
+		}
+		if s.Type == "integer" && f != math.Trunc(f) {
+			fail("must be an integer")
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			fail("must be <= %v", *s.Maximum)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return problems
+		}
+		if s.MinLength != nil && len(strings.TrimSpace(str)) < *s.MinLength {
+			fail("must be at least %d characters", *s.MinLength)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			fail("must be one of %s", strings.Join(s.Enum, ", "))
+		}
+		switch s.Format {
+		case "date":
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				fail("must be a date in YYYY-MM-DD format")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				fail("must be an RFC 3339 date-time")
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type boundedRequest struct {
+	Weight float64 `json:"weight" openapi:"required,min=0,max=1"`
+	Count  int     `json:"count" openapi:"min=1,max=10"`
+	Mode   string  `json:"mode" openapi:"enum=fast|slow"`
+}
+
+func decodeJSON(t *testing.T, body string) any {
+	t.Helper()
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		t.Fatalf("invalid test body %s: %v", body, err)
+	}
+	return value
+}
+
+func TestValidateBounds(t *testing.T) {
+	doc := buildOpenAPISpec([]apiRoute{{
+		Method: http.MethodPost, Path: "/bounded", ID: "bounded",
+		Body: boundedRequest{}, Responses: map[int]any{http.StatusOK: nil},
+	}})
+	schema := doc.Paths["/bounded"]["post"].RequestBody.Content["application/json"].Schema
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"within bounds", `{"weight": 0.5, "count": 3, "mode": "fast"}`, nil},
+		{"on the bounds", `{"weight": 1, "count": 10}`, nil},
+		{"below min", `{"weight": -0.01}`, []string{"body.weight: must be >= 0"}},
+		{"above max", `{"weight": 1.5}`, []string{"body.weight: must be <= 1"}},
+		{"integer above max", `{"weight": 0, "count": 11}`, []string{"body.count: must be <= 10"}},
+		{"integer below min", `{"weight": 0, "count": 0}`, []string{"body.count: must be >= 1"}},
+		{"fractional integer", `{"weight": 0, "count": 2.5}`, []string{"body.count: must be an integer"}},
+		{"every violation", `{"count": 20, "mode": "medium"}`, []string{
+			"body.weight: is required",
+			"body.count: must be <= 10",
+			"body.mode: must be one of fast, slow",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := doc.validate(schema, decodeJSON(t, tt.body), "body")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate(%s) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequestRejectsOutOfRange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var route apiRoute
+	for _, r := range apiRoutes() {
+		if r.ID == "updateHolding" {
+			route = r
+		}
+	}
+	reached := false
+	router := gin.New()
+	router.Handle(route.Method, route.Path, validateRequest(route), func(c *gin.Context) {
+		reached = true
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"quantity": 2, "price": 10}`, http.StatusOK},
+		{`{"quantity": -2, "price": 10}`, http.StatusBadRequest},
+		{`{"quantity": 2}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		reached = false
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/holdings/VWCE", bytes.NewBufferString(tt.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("PUT %s: status %d, want %d (%s)", tt.body, w.Code, tt.want, w.Body)
+		}
+		if reached != (tt.want == http.StatusOK) {
+			t.Errorf("PUT %s: handler reached = %v", tt.body, reached)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+)
+
+// OrderRules are the constraints the broker puts on orders for a holding.
+type OrderRules struct {
+	WholeShares bool    `json:"wholeShares"`              // Only whole shares can be bought
+	MinOrder    float64 `json:"minOrder" openapi:"min=0"` // Smallest order accepted, in € including fees
+}
+
+// pendingOrder is a buy being sized by executeOrders.
+type pendingOrder struct {
+	index  int             // Position of the entry, so the strategy's order is kept
+	entry  AllocationEntry // As decided by the strategy
+	rules  OrderRules
+	target float64 // Cash the strategy wanted to spend, including fees
+	qty    float64 // Whole shares chosen so far
+	cash   float64 // Cash qty costs, including fees
+}
+
+// buyCost returns what buying qty shares of an entry costs under m: the fill price,
+// the value of the shares and the fee.
+func (m CostModel) buyCost(e AllocationEntry, qty float64) (price, gross, fee float64) {
+	if qty <= 0 {
+		return 0, 0, 0
+	}
+	price = e.PricePerShare * (1 + m.Spread/2 + m.Slippage)
+	gross = qty * price
+	return price, gross, m.fee(gross)
+}
+
+// executeOrders turns a strategy's entries into orders that respect the order rules of
+// each holding, executed through costs. Fractional holdings get exactly the amount
+// the strategy chose. Whole-share holdings start with the most shares their amount
+// affords; the cash left over by rounding down is then spent one share at a time on
+// whichever holding is furthest below its target, as long as that brings it closer.
+// Orders below a holding's minimum are dropped. Nothing is spent beyond what the
+// strategy allocated, so cash the plan doesn't use stays available for the next cycle.
+func executeOrders(entries []AllocationEntry, stocks []*Stock, costs CostModel) []AllocationEntry {
+	rules := make(map[string]OrderRules, len(stocks))
+	for _, s := range stocks {
+		rules[s.Ticker] = OrderRules{WholeShares: s.WholeShares, MinOrder: s.MinOrder}
+	}
+
+	filled := make([]*AllocationEntry, len(entries))
+	var orders []*pendingOrder
+	var pool float64 // Cash left over by rounding whole-share orders down
+	for i, e := range entries {
+		r := rules[e.Ticker]
+		if e.QuantityBought < 0 || !r.WholeShares {
+			if f, ok := costs.execute(e); ok && (e.QuantityBought < 0 || f.InvestmentAmount+f.Fees >= r.MinOrder) {
+				filled[i] = &f
+			}
+			continue
+		}
+
+		o := &pendingOrder{index: i, entry: e, rules: r, target: e.InvestmentAmount}
+		if price, _, _ := costs.buyCost(e, 1); price > 0 {
+			o.qty = math.Floor(o.target / price)
+		}
+		for ; o.qty > 0; o.qty-- {
+			if _, gross, fee := costs.buyCost(e, o.qty); gross+fee <= o.target {
+				o.cash = gross + fee
+				break
+			}
+		}
+		pool += o.target - o.cash
+		orders = append(orders, o)
+	}
+
+	for {
+		// Spend the pool on the order whose next share reduces its shortfall the most
+		for {
+			var best *pendingOrder
+			var bestGain, bestExtra float64
+			for _, o := range orders {
+				_, gross, fee := costs.buyCost(o.entry, o.qty+1)
+				extra := gross + fee - o.cash
+				if extra > pool+1e-9 {
+					continue
+				}
+				shortfall := o.target - o.cash
+				if gain := shortfall*shortfall - (shortfall-extra)*(shortfall-extra); gain > bestGain {
+					best, bestGain, bestExtra = o, gain, extra
+				}
+			}
+			if best == nil {
+				break
+			}
+			best.qty++
+			best.cash += bestExtra
+			pool -= bestExtra
+		}
+
+		// Drop the orders below their minimum and give their cash to the others
+		dropped := false
+		kept := orders[:0]
+		for _, o := range orders {
+			if o.qty > 0 && o.cash < o.rules.MinOrder {
+				pool += o.cash
+				dropped = true
+				continue
+			}
+			kept = append(kept, o)
+		}
+		orders = kept
+		if !dropped {
+			break
+		}
+	}
+
+	for _, o := range orders {
+		if o.qty <= 0 {
+			continue
+		}
+		price, gross, fee := costs.buyCost(o.entry, o.qty)
+		e := o.entry
+		e.PricePerShare, e.InvestmentAmount, e.QuantityBought, e.Fees = price, gross, o.qty, fee
+		filled[o.index] = &e
+	}
+
+	var executed []AllocationEntry
+	for _, e := range filled {
+		if e != nil {
+			executed = append(executed, *e)
+		}
+	}
+	return executed
+}
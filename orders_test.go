@@ -0,0 +1,89 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExecuteOrders(t *testing.T) {
+	type want struct {
+		ticker string
+		qty    float64
+		amount float64
+	}
+	tests := []struct {
+		name    string
+		stocks  []*Stock
+		entries []AllocationEntry
+		want    []want
+	}{
+		{
+			name:    "fractional shares get exactly their amount",
+			stocks:  []*Stock{{Ticker: "A"}},
+			entries: []AllocationEntry{buy("A", 100, 30)},
+			want:    []want{{"A", 100.0 / 30, 100}},
+		},
+		{
+			name:    "whole shares round down",
+			stocks:  []*Stock{{Ticker: "A", WholeShares: true}},
+			entries: []AllocationEntry{buy("A", 100, 30)},
+			want:    []want{{"A", 3, 90}},
+		},
+		{
+			// A is 20 short with 2 shares, B 10 short with 6. The 30 left over can't buy
+			// a share of A but buys one more of B, which gets it closer to its target.
+			name:    "the leftover buys the share that brings a holding closest",
+			stocks:  []*Stock{{Ticker: "A", WholeShares: true}, {Ticker: "B", WholeShares: true}},
+			entries: []AllocationEntry{buy("A", 100, 40), buy("B", 100, 15)},
+			want:    []want{{"A", 2, 80}, {"B", 7, 105}},
+		},
+		{
+			name:    "a holding whose amount buys no share is left out",
+			stocks:  []*Stock{{Ticker: "A", WholeShares: true}, {Ticker: "B", WholeShares: true}},
+			entries: []AllocationEntry{buy("A", 10, 40), buy("B", 100, 50)},
+			want:    []want{{"B", 2, 100}},
+		},
+		{
+			name:    "fractional orders below the minimum are dropped",
+			stocks:  []*Stock{{Ticker: "A", MinOrder: 25}, {Ticker: "B", MinOrder: 25}},
+			entries: []AllocationEntry{buy("A", 20, 10), buy("B", 30, 10)},
+			want:    []want{{"B", 3, 30}},
+		},
+		{
+			// B's single share is below its minimum. Once it is dropped, its 45 and the
+			// 40 A was short buy A a second share.
+			name: "the cash of dropped orders goes to the others",
+			stocks: []*Stock{
+				{Ticker: "A", WholeShares: true},
+				{Ticker: "B", WholeShares: true, MinOrder: 50},
+			},
+			entries: []AllocationEntry{buy("A", 100, 60), buy("B", 50, 45)},
+			want:    []want{{"A", 2, 120}},
+		},
+	}
+	for _, tt := range tests {
+		got := executeOrders(tt.entries, tt.stocks, CostModel{})
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d orders, want %d: %+v", tt.name, len(got), len(tt.want), got)
+			continue
+		}
+		for i, w := range tt.want {
+			g := got[i]
+			if g.Ticker != w.ticker || math.Abs(g.QuantityBought-w.qty) > 1e-9 || math.Abs(g.InvestmentAmount-w.amount) > 1e-9 {
+				t.Errorf("%s: order %d = %s %v shares for %v, want %s %v shares for %v",
+					tt.name, i, g.Ticker, g.QuantityBought, g.InvestmentAmount, w.ticker, w.qty, w.amount)
+			}
+		}
+	}
+}
+
+func TestExecuteOrdersWholeSharesWithFees(t *testing.T) {
+	// With the minimum fee of 10, three shares at 30 would cost 100, more than the 95
+	// allocated, so the fee is counted before rounding and two are bought
+	costs := CostModel{FixedFee: 5, MinFee: 10}
+	stocks := []*Stock{{Ticker: "A", WholeShares: true}}
+	got := executeOrders([]AllocationEntry{buy("A", 95, 30)}, stocks, costs)
+	if len(got) != 1 || got[0].QuantityBought != 2 || got[0].InvestmentAmount != 60 || got[0].Fees != 10 {
+		t.Errorf("executeOrders = %+v, want 2 shares for 60 and a fee of 10", got)
+	}
+}
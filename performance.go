@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Performance is measured on the valuations of the history: the value of each strategy
+// and of the real portfolio every week, and the money put into them by then. A
+// contribution is dated to the first valuation it shows up in.
+
+// PerformanceMetrics are the returns and risk of a strategy, or of the real portfolio.
+type PerformanceMetrics struct {
+	Key      string  `json:"key"`
+	Name     string  `json:"name"`
+	Start    string  `json:"start,omitempty"` // First valuation with money in it, YYYY-MM-DD
+	End      string  `json:"end,omitempty"`
+	Invested float64 `json:"invested"` // Net contributions
+	Value    float64 `json:"value"`
+	Gain     float64 `json:"gain"` // Value less the net contributions
+	// Time-weighted return: the growth of the money invested, whatever the timing
+	// and size of the contributions
+	TWR              float64 `json:"twr"`
+	AnnualisedReturn float64 `json:"annualisedReturn"` // TWR per year
+	// Money-weighted return: the yearly rate (XIRR) at which the contributions would
+	// grow to the value. Unset if there is no such rate.
+	MWR         *float64 `json:"mwr,omitempty"`
+	Volatility  float64  `json:"volatility"`  // Annualised standard deviation of the period returns
+	MaxDrawdown float64  `json:"maxDrawdown"` // Largest fall of the TWR from its previous high
+	Sharpe      float64  `json:"sharpe"`      // Annualised excess return per unit of volatility
+	Sortino     float64  `json:"sortino"`     // The same per unit of downside deviation
+	// Only set with a benchmark
+	Benchmark *BenchmarkComparison `json:"benchmark,omitempty"`
+}
+
+// BenchmarkComparison compares a series with the same contributions invested in the
+// benchmark on the same dates.
+type BenchmarkComparison struct {
+	Ticker           string  `json:"ticker"`
+	Value            float64 `json:"value"` // What the contributions would be worth in the benchmark
+	TWR              float64 `json:"twr"`
+	AnnualisedReturn float64 `json:"annualisedReturn"`
+	MaxDrawdown      float64 `json:"maxDrawdown"`
+	// Annualised return above the benchmark's
+	Alpha float64 `json:"alpha"`
+	// Annualised standard deviation of the difference between the period returns
+	TrackingError float64 `json:"trackingError"`
+	// Largest fall of the growth relative to the benchmark from its previous high
+	RelativeDrawdown float64 `json:"relativeDrawdown"`
+}
+
+// PerformanceReport is the performance of the real portfolio and every strategy.
+type PerformanceReport struct {
+	RiskFree       float64 `json:"riskFree"`       // Yearly rate the excess returns are measured against
+	PeriodsPerYear float64 `json:"periodsPerYear"` // Valuations per year, for annualising
+	Benchmark      string  `json:"benchmark,omitempty"`
+	// Unset if the event stream could not be replayed
+	Portfolio  *PerformanceMetrics  `json:"portfolio,omitempty"`
+	Strategies []PerformanceMetrics `json:"strategies"`
+}
+
+// cashFlow is money going into (negative) or coming out of (positive) an investment.
+type cashFlow struct {
+	years  float64 // Since the first flow
+	amount float64
+}
+
+// xirr finds the yearly rate at which the flows have a net present value of 0, by
+// bisection. ok is false if the NPV doesn't change sign over the rates tried.
+func xirr(flows []cashFlow) (rate float64, ok bool) {
+	npv := func(r float64) float64 {
+		var sum float64
+		for _, f := range flows {
+			sum += f.amount / math.Pow(1+r, f.years)
+		}
+		return sum
+	}
+	lo, hi := -0.9999, 100.0
+	fLo, fHi := npv(lo), npv(hi)
+	if math.IsNaN(fLo) || math.IsNaN(fHi) || fLo*fHi > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200 && hi-lo > 1e-10; i++ {
+		mid := (lo + hi) / 2
+		if fMid := npv(mid); fMid*fLo > 0 {
+			lo, fLo = mid, fMid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2, true
+}
+
+// periodsPerYear is how many valuations a year the series has, from its average spacing.
+func periodsPerYear(series []valuation) float64 {
+	if len(series) < 2 {
+		return 52
+	}
+	days := series[len(series)-1].Date.Sub(series[0].Date).Hours() / 24 / float64(len(series)-1)
+	if days <= 0 {
+		return 52
+	}
+	return 365.25 / days
+}
+
+// periodReturns returns the return of each period of series, treating its contribution
+// as made at the start of the period. The first valuation opens the series: its value
+// is the capital the next period starts with, whatever went in before the range, so it
+// has no return itself and is NaN. A period that starts with nothing invested, before
+// the first contribution or after selling everything, has no return either.
+func periodReturns(series []valuation) []float64 {
+	returns := make([]float64, len(series))
+	for i, v := range series {
+		returns[i] = math.NaN()
+		if i == 0 {
+			continue
+		}
+		prev := series[i-1]
+		if base := prev.Value + v.Invested - prev.Invested; base > 0 {
+			returns[i] = v.Value/base - 1
+		}
+	}
+	return returns
+}
+
+// measurePerformance works out the metrics of a series of valuations. riskFree is a
+// yearly rate.
+func measurePerformance(series []valuation, riskFree, perYear float64) PerformanceMetrics {
+	var m PerformanceMetrics
+	var returns []float64
+	var flows []cashFlow
+	var first, prev valuation
+	growth, peak := 1.0, 1.0
+	for i, r := range periodReturns(series) {
+		v := series[i]
+		if first.Date.IsZero() && !math.IsNaN(r) {
+			first = v
+			// Money already in when the first period starts, like everything invested before
+			// the range, goes in at the start as one contribution of what it was worth
+			if prev.Value > 0 {
+				first = prev
+				flows = append(flows, cashFlow{amount: -prev.Value})
+			}
+		}
+		if !first.Date.IsZero() {
+			flows = append(flows, cashFlow{years: v.Date.Sub(first.Date).Hours() / 24 / 365.25, amount: prev.Invested - v.Invested})
+		}
+		prev = v
+		if math.IsNaN(r) {
+			continue
+		}
+		returns = append(returns, r)
+		growth *= 1 + r
+		peak = math.Max(peak, growth)
+		m.MaxDrawdown = math.Max(m.MaxDrawdown, 1-growth/peak)
+	}
+	if len(returns) == 0 {
+		return m
+	}
+
+	last := series[len(series)-1]
+	m.Start, m.End = first.Date.Format("2006-01-02"), last.Date.Format("2006-01-02")
+	m.Invested, m.Value = last.Invested, last.Value
+	m.Gain = m.Value - m.Invested
+	m.TWR = growth - 1
+	if years := float64(len(returns)) / perYear; growth > 0 {
+		m.AnnualisedReturn = math.Pow(growth, 1/years) - 1
+	}
+
+	flows[len(flows)-1].amount += last.Value
+	if rate, ok := xirr(flows); ok {
+		m.MWR = &rate
+	}
+
+	// Sharpe and Sortino compare the period returns with the risk-free rate of a period
+	periodFree := math.Pow(1+riskFree, 1/perYear) - 1
+	excess := make([]float64, len(returns))
+	var downside float64
+	for i, r := range returns {
+		excess[i] = r - periodFree
+		if excess[i] < 0 {
+			downside += excess[i] * excess[i]
+		}
+	}
+	avg, sd := meanStd(excess)
+	downside = math.Sqrt(downside / float64(len(excess)))
+	_, vol := meanStd(returns)
+	m.Volatility = vol * math.Sqrt(perYear)
+	if sd > 0 {
+		m.Sharpe = avg / sd * math.Sqrt(perYear)
+	}
+	if downside > 0 {
+		m.Sortino = avg / downside * math.Sqrt(perYear)
+	}
+	return m
+}
+
+// compareBenchmark compares series with bench, the same contributions invested in the
+// benchmark, over the periods both have a return for.
+func compareBenchmark(m PerformanceMetrics, series, bench []valuation, ticker string, perYear float64) *BenchmarkComparison {
+	b := measurePerformance(bench, 0, perYear)
+	comparison := &BenchmarkComparison{
+		Ticker:           ticker,
+		Value:            b.Value,
+		TWR:              b.TWR,
+		AnnualisedReturn: b.AnnualisedReturn,
+		MaxDrawdown:      b.MaxDrawdown,
+		Alpha:            m.AnnualisedReturn - b.AnnualisedReturn,
+	}
+
+	own, theirs := periodReturns(series), periodReturns(bench)
+	var active []float64
+	relative, peak := 1.0, 1.0
+	for i := range own {
+		if math.IsNaN(own[i]) || math.IsNaN(theirs[i]) {
+			continue
+		}
+		active = append(active, own[i]-theirs[i])
+		relative *= (1 + own[i]) / (1 + theirs[i])
+		peak = math.Max(peak, relative)
+		comparison.RelativeDrawdown = math.Max(comparison.RelativeDrawdown, 1-relative/peak)
+	}
+	if len(active) > 0 {
+		_, sd := meanStd(active)
+		comparison.TrackingError = sd * math.Sqrt(perYear)
+	}
+	return comparison
+}
+
+// performanceReport measures the real portfolio and every strategy over the range, by
+// default their whole history at weekly valuations.
+func performanceReport(ctx context.Context, riskFree float64, r historyRange) (PerformanceReport, error) {
+	if riskFree <= -1 || riskFree >= 1 {
+		return PerformanceReport{}, fmt.Errorf("%w: the risk-free rate must be between -100%% and 100%%", errInvalidInput)
+	}
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return PerformanceReport{}, err
+	}
+	h, err := buildPortfolioHistory(ctx, historyOptions{historyRange: r, strategies: settings.allStrategies(), actual: true, benchmark: settings.Benchmark})
+	if err != nil {
+		return PerformanceReport{}, err
+	}
+
+	report := PerformanceReport{RiskFree: riskFree, Benchmark: settings.Benchmark, Strategies: []PerformanceMetrics{}}
+	report.PeriodsPerYear = periodsPerYear(h.series[primaryStrategyKey])
+	measure := func(key, name string, series []valuation, flows []contribution) PerformanceMetrics {
+		m := measurePerformance(series, riskFree, report.PeriodsPerYear)
+		m.Key, m.Name = key, name
+		if bench := h.benchmarkFor(series, flows); bench != nil && m.Start != "" {
+			m.Benchmark = compareBenchmark(m, series, bench, h.benchmark, report.PeriodsPerYear)
+		}
+		return m
+	}
+	if h.actual != nil {
+		m := measure("portfolio", "Portfolio", h.actual, h.actualFlows)
+		report.Portfolio = &m
+	}
+	for _, s := range h.strategies {
+		report.Strategies = append(report.Strategies, measure(s.Key, s.Name, h.series[s.Key], h.flows[s.Key]))
+	}
+	return report, nil
+}
+
+func apiPerformance(c *gin.Context) {
+	var riskFree float64
+	if s := c.Query("riskFree"); s != "" {
+		var err error
+		if riskFree, err = strconv.ParseFloat(s, 64); err != nil {
+			badRequest(c, "riskFree must be a number")
+			return
+		}
+	}
+	r, err := parseHistoryRange(c.Query("start"), c.Query("end"), c.Query("resolution"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	report, err := performanceReport(c.Request.Context(), riskFree, r)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// showPerformancePage renders the performance metrics, with the risk-free rate entered
+// as a percentage.
+func showPerformancePage(c *gin.Context) {
+	riskFree, _ := strconv.ParseFloat(c.DefaultQuery("risk_free", "0"), 64)
+	r, err := parseHistoryRange(c.Query("start"), c.Query("end"), c.Query("resolution"))
+	if err != nil {
+		c.String(formErrorStatus(err), "Failed to measure performance: %v", err)
+		return
+	}
+	report, err := performanceReport(c.Request.Context(), riskFree/100, r)
+	if err != nil {
+		log.Printf("Failed to measure performance: %v", err)
+		c.String(formErrorStatus(err), "Failed to measure performance: %v", err)
+		return
+	}
+	c.HTML(http.StatusOK, "performance.tmpl.html", gin.H{
+		"report":     report,
+		"start":      c.Query("start"),
+		"end":        c.Query("end"),
+		"resolution": r.resolution,
+	})
+}
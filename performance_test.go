@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestXIRR(t *testing.T) {
+	tests := []struct {
+		name  string
+		flows []cashFlow
+		want  float64
+	}{
+		{"one year", []cashFlow{{0, -1000}, {1, 1100}}, 0.1},
+		{"half a year", []cashFlow{{0, -1000}, {0.5, 1100}}, 0.21},
+		{"a loss", []cashFlow{{0, -1000}, {2, 810}}, -0.1},
+		// The rate at which the NPV is 0, solved outside the code under test
+		{"several flows", []cashFlow{{0, -1000}, {0.5, -500}, {1.25, 300}, {2, 1500}}, 0.1127183091620866},
+	}
+	for _, tt := range tests {
+		got, ok := xirr(tt.flows)
+		if !ok || math.Abs(got-tt.want) > 1e-8 {
+			t.Errorf("%s: xirr = %v, %v, want %v", tt.name, got, ok, tt.want)
+		}
+	}
+
+	// Money that only goes in has no rate
+	if got, ok := xirr([]cashFlow{{0, -1000}, {1, -100}}); ok {
+		t.Errorf("xirr of contributions only = %v, want ok = false", got)
+	}
+}
+
+func TestMeasurePerformance(t *testing.T) {
+	series := []valuation{
+		{Date: day("2023-12-29")},
+		{Date: day("2024-01-05"), Value: 1000, Invested: 1000},
+		{Date: day("2024-07-05"), Value: 1100, Invested: 1000}, // +10%
+		{Date: day("2025-01-03"), Value: 1650, Invested: 1500}, // 500 more grows 1600 to 1650
+		{Date: day("2025-04-04"), Value: 1320, Invested: 1500}, // -20%
+	}
+	m := measurePerformance(series, 0, 2)
+
+	growth := 1.1 * 1650 / 1600 * 0.8
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"invested", m.Invested, 1500},
+		{"value", m.Value, 1320},
+		{"gain", m.Gain, -180},
+		{"TWR", m.TWR, growth - 1},
+		{"annualised return", m.AnnualisedReturn, math.Pow(growth, 1/2.0) - 1}, // 4 returns at 2 a year
+		{"max drawdown", m.MaxDrawdown, 0.2},
+	}
+	for _, c := range checks {
+		if math.Abs(c.got-c.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if m.Start != "2024-01-05" || m.End != "2025-04-04" {
+		t.Errorf("range = %s to %s, want 2024-01-05 to 2025-04-04", m.Start, m.End)
+	}
+	// The XIRR of -1000 on 2024-01-05, -500 on 2025-01-03 and 1320 on 2025-04-04
+	if m.MWR == nil || math.Abs(*m.MWR-(-0.132743854492721)) > 1e-8 {
+		t.Errorf("MWR = %v, want -0.1327", m.MWR)
+	}
+}
+
+func TestMeasurePerformanceOpeningCapital(t *testing.T) {
+	// The range starts with 1000 already in, 800 of it contributed before the range
+	series := []valuation{
+		{Date: day("2023-01-01"), Value: 1000, Invested: 800},
+		{Date: day("2024-01-01"), Value: 1100, Invested: 800},
+	}
+	m := measurePerformance(series, 0, 1)
+	if math.Abs(m.TWR-0.1) > 1e-9 {
+		t.Errorf("TWR = %v, want 0.1", m.TWR)
+	}
+	// 2023 has 365 days, a little less than the year XIRR counts in
+	if want := math.Pow(1.1, 365.25/365) - 1; m.MWR == nil || math.Abs(*m.MWR-want) > 1e-8 {
+		t.Errorf("MWR = %v, want %v", m.MWR, want)
+	}
+	if m.Start != "2023-01-01" {
+		t.Errorf("start = %s, want 2023-01-01", m.Start)
+	}
+}
+
+func TestMeasurePerformanceNothingInvested(t *testing.T) {
+	series := []valuation{{Date: day("2024-01-05")}, {Date: day("2024-01-12")}}
+	if m := measurePerformance(series, 0, 52); m.MWR != nil || m.TWR != 0 || m.Start != "" {
+		t.Errorf("measurePerformance of nothing = %+v, want no metrics", m)
+	}
+}
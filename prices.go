@@ -0,0 +1,46 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"time"
+)
+
+// priceSeries is the daily prices of a ticker ordered oldest to newest. Looking up a
+// date is a binary search, so valuing holdings on every date of a long history doesn't
+// rescan the prices each time.
+type priceSeries []HistoricalPrice
+
+// newPriceSeries orders prices oldest to newest, copying them if they aren't already.
+func newPriceSeries(prices []HistoricalPrice) priceSeries {
+	byDate := func(a, b HistoricalPrice) int { return cmp.Compare(a.Date, b.Date) }
+	if slices.IsSortedFunc(prices, byDate) {
+		return priceSeries(prices)
+	}
+	sorted := slices.Clone(prices)
+	slices.SortStableFunc(sorted, byDate)
+	return priceSeries(sorted)
+}
+
+// upTo returns the prices dated on or before d.
+func (s priceSeries) upTo(d time.Time) priceSeries {
+	day := d.Format("2006-01-02")
+	return s[:sort.Search(len(s), func(i int) bool { return s[i].Date > day })]
+}
+
+// at returns the last price on or before d, so weekends and holidays take the close of
+// the last trading day. ok is false if the series starts after d.
+func (s priceSeries) at(d time.Time) (p HistoricalPrice, ok bool) {
+	known := s.upTo(d)
+	if len(known) == 0 {
+		return HistoricalPrice{}, false
+	}
+	return known[len(known)-1], true
+}
+
+// closeOn returns the last raw close on or before d, or 0 if there is none.
+func (s priceSeries) closeOn(d time.Time) float64 {
+	p, _ := s.at(d)
+	return p.Close
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// gappedSeries is a week of closes around a weekend and the Monday holiday after it.
+var gappedSeries = newPriceSeries([]HistoricalPrice{
+	{Date: "2024-01-10", Close: 10},
+	{Date: "2024-01-11", Close: 11},
+	{Date: "2024-01-12", Close: 12}, // Friday
+	{Date: "2024-01-16", Close: 16}, // Tuesday, after Martin Luther King Day
+	{Date: "2024-01-17", Close: 17},
+})
+
+func TestPriceSeriesLookups(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   time.Time
+		upTo   int
+		ok     bool
+		close  float64
+		wantOn string
+	}{
+		{"before the first price", day("2024-01-09"), 0, false, 0, ""},
+		{"on the first price", day("2024-01-10"), 1, true, 10, "2024-01-10"},
+		{"late on a trading day", day("2024-01-11").Add(23 * time.Hour), 2, true, 11, "2024-01-11"},
+		{"on a Saturday", day("2024-01-13"), 3, true, 12, "2024-01-12"},
+		{"on a holiday", day("2024-01-15"), 3, true, 12, "2024-01-12"},
+		{"after the holiday", day("2024-01-16"), 4, true, 16, "2024-01-16"},
+		{"on the last price", day("2024-01-17"), 5, true, 17, "2024-01-17"},
+		{"after the last price", day("2024-02-01"), 5, true, 17, "2024-01-17"},
+	}
+	for _, tt := range tests {
+		if got := len(gappedSeries.upTo(tt.date)); got != tt.upTo {
+			t.Errorf("%s: upTo has %d prices, want %d", tt.name, got, tt.upTo)
+		}
+		p, ok := gappedSeries.at(tt.date)
+		if ok != tt.ok || p.Date != tt.wantOn {
+			t.Errorf("%s: at = %s, %v, want %s, %v", tt.name, p.Date, ok, tt.wantOn, tt.ok)
+		}
+		if got := gappedSeries.closeOn(tt.date); got != tt.close {
+			t.Errorf("%s: closeOn = %v, want %v", tt.name, got, tt.close)
+		}
+	}
+}
+
+func TestNewPriceSeriesSorts(t *testing.T) {
+	newestFirst := []HistoricalPrice{{Date: "2024-01-12", Close: 12}, {Date: "2024-01-11", Close: 11}, {Date: "2024-01-10", Close: 10}}
+	s := newPriceSeries(newestFirst)
+	if s[0].Date != "2024-01-10" || s[2].Date != "2024-01-12" {
+		t.Errorf("newPriceSeries = %v, want oldest first", s)
+	}
+	if newestFirst[0].Date != "2024-01-12" {
+		t.Error("newPriceSeries reordered the prices it was given")
+	}
+	if got := s.closeOn(day("2024-01-11")); got != 11 {
+		t.Errorf("closeOn = %v, want 11", got)
+	}
+
+	var empty priceSeries
+	if _, ok := empty.at(day("2024-01-11")); ok {
+		t.Error("at of an empty series is ok")
+	}
+}
+
+// BenchmarkPriceSeriesAt values 50 holdings on every day of 10 years of daily prices,
+// as the portfolio history does.
+func BenchmarkPriceSeriesAt(b *testing.B) {
+	const tickers = 50
+	start := day("2015-01-01")
+	end := start.AddDate(10, 0, 0)
+	var days []HistoricalPrice
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			days = append(days, HistoricalPrice{Date: d.Format(time.DateOnly), Close: float64(len(days))})
+		}
+	}
+	series := make([]priceSeries, tickers)
+	for i := range series {
+		series[i] = newPriceSeries(days)
+	}
+	b.Logf("%d tickers of %d days", tickers, len(days))
+
+	b.ResetTimer()
+	for range b.N {
+		var value float64
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			for _, s := range series {
+				value += s.closeOn(d)
+			}
+		}
+		if value == 0 {
+			b.Fatal("the holdings have no value")
+		}
+	}
+}
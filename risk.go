@@ -0,0 +1,277 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// The risk-based strategies only look at how the holdings move, not at where their
+// prices are heading. They estimate the covariance of daily returns from the closes
+// stored by the analysis, turn it into target weights and then spend the budget on
+// the holdings furthest below those weights, like the rebalance strategy.
+
+// minRiskReturns is the fewest daily returns a covariance is estimated from.
+const minRiskReturns = 20
+
+// riskTuned keeps the parameters the risk-based strategies use.
+func riskTuned(p StrategyParams) StrategyParams {
+	return StrategyParams{RiskWindow: p.RiskWindow, AdjustedPrices: p.AdjustedPrices}
+}
+
+// covariance estimates the sample covariance of daily returns of stocks over the last
+// window days they all traded, from their closes in basis. It returns the stocks it
+// could use, in the order given, and their covariance matrix; stocks without a price or
+// history are left out. ok is false when fewer than minRiskReturns common returns are
+// available.
+func covariance(stocks []*Stock, window int, basis priceBasis) (used []*Stock, cov [][]float64, ok bool) {
+	for _, stock := range stocks {
+		if stock.CurrentPrice > 0 && len(stock.History) >= 2 {
+			used = append(used, stock)
+		}
+	}
+	if len(used) == 0 {
+		return nil, nil, false
+	}
+
+	closes := commonCloses(used, basis)
+	if n := len(closes[0]); n > window+1 {
+		for i := range closes {
+			closes[i] = closes[i][n-window-1:]
+		}
+	}
+	days := len(closes[0])
+	if days <= minRiskReturns {
+		return nil, nil, false
+	}
+
+	returns := make([][]float64, len(used))
+	means := make([]float64, len(used))
+	for i := range used {
+		returns[i] = make([]float64, days-1)
+		for d := 1; d < days; d++ {
+			returns[i][d-1] = closes[i][d]/closes[i][d-1] - 1
+			means[i] += returns[i][d-1]
+		}
+		means[i] /= float64(days - 1)
+	}
+
+	cov = make([][]float64, len(used))
+	for i := range used {
+		cov[i] = make([]float64, len(used))
+		for j := 0; j <= i; j++ {
+			var sum float64
+			for d := range returns[i] {
+				sum += (returns[i][d] - means[i]) * (returns[j][d] - means[j])
+			}
+			cov[i][j] = sum / float64(len(returns[i])-1)
+			cov[j][i] = cov[i][j]
+		}
+	}
+	return used, cov, true
+}
+
+// commonCloses returns the closes in basis of each stock on the days all of them have a
+// price, oldest first. Backtests give every stock the same days, which is checked first
+// so the dates only need to be matched up when the histories actually differ.
+func commonCloses(stocks []*Stock, basis priceBasis) [][]float64 {
+	closes := make([][]float64, len(stocks))
+	aligned := true
+	first := stocks[0].History
+	sameDate := func(a, b HistoricalPrice) bool { return a.Date == b.Date }
+	for _, stock := range stocks[1:] {
+		if !slices.EqualFunc(stock.History, first, sameDate) {
+			aligned = false
+			break
+		}
+	}
+	for i, stock := range stocks {
+		if !aligned {
+			break
+		}
+		closes[i] = make([]float64, len(first))
+		for d, p := range stock.History {
+			price := p.value(basis)
+			if price <= 0 {
+				aligned = false // A missing close makes the days differ after all
+				break
+			}
+			closes[i][len(first)-1-d] = price
+		}
+	}
+	if aligned {
+		return closes
+	}
+
+	counts := make(map[string]int)
+	byDate := make([]map[string]float64, len(stocks))
+	for i, stock := range stocks {
+		byDate[i] = make(map[string]float64, len(stock.History))
+		for _, p := range stock.History {
+			if price := p.value(basis); price > 0 {
+				byDate[i][p.Date] = price
+				counts[p.Date]++
+			}
+		}
+	}
+	var days []string
+	for day, n := range counts {
+		if n == len(stocks) {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	for i := range stocks {
+		closes[i] = make([]float64, len(days))
+		for d, day := range days {
+			closes[i][d] = byDate[i][day]
+		}
+	}
+	return closes
+}
+
+// riskAllocation computes target weights with weigh and fills the budget towards them.
+// Stocks weigh gives no weight to are not bought.
+func riskAllocation(stocks []*Stock, budget float64, params StrategyParams, weigh func(cov [][]float64) []float64) []AllocationEntry {
+	used, cov, ok := covariance(stocks, params.RiskWindow, params.basis())
+	if !ok {
+		return nil
+	}
+	for i := range used {
+		if cov[i][i] <= 0 {
+			return nil // A price that never moved has no risk to balance
+		}
+	}
+
+	weights := make(map[*Stock]float64)
+	var candidates []*Stock
+	for i, w := range weigh(cov) {
+		if w > 1e-6 && !math.IsNaN(w) {
+			weights[used[i]] = w
+			candidates = append(candidates, used[i])
+		}
+	}
+	return fillToWeights(candidates, weights, budget)
+}
+
+// inverseVolatilityWeights weights each asset by the inverse of its volatility.
+func inverseVolatilityWeights(cov [][]float64) []float64 {
+	weights := make([]float64, len(cov))
+	var total float64
+	for i := range cov {
+		weights[i] = 1 / math.Sqrt(cov[i][i])
+		total += weights[i]
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+	return weights
+}
+
+// equalRiskWeights returns the weights under which every asset contributes the same
+// share of the portfolio variance. It minimises ½yᵀΣy − Σ ln(y_i)/n one coordinate at a
+// time, which converges for any positive definite Σ, and normalises the result.
+func equalRiskWeights(cov [][]float64) []float64 {
+	n := len(cov)
+	y := inverseVolatilityWeights(cov)
+	b := 1 / float64(n)
+	for iter := 0; iter < 1000; iter++ {
+		var change float64
+		for i := range y {
+			var c float64
+			for j := range y {
+				if j != i {
+					c += cov[i][j] * y[j]
+				}
+			}
+			next := (-c + math.Sqrt(c*c+4*cov[i][i]*b)) / (2 * cov[i][i])
+			change = math.Max(change, math.Abs(next-y[i]))
+			y[i] = next
+		}
+		if change < 1e-10 {
+			break
+		}
+	}
+
+	var total float64
+	for _, v := range y {
+		total += v
+	}
+	for i := range y {
+		y[i] /= total
+	}
+	return y
+}
+
+// minVarianceWeights returns the long-only weights with the lowest portfolio variance,
+// found by projected gradient descent on the simplex.
+func minVarianceWeights(cov [][]float64) []float64 {
+	n := len(cov)
+	// The gradient is 2Σw and the largest eigenvalue of Σ is at most its trace, so
+	// 1/(2·trace) is a safe step size
+	var trace float64
+	for i := range cov {
+		trace += cov[i][i]
+	}
+	step := 1 / (2 * trace)
+
+	w := make([]float64, n)
+	for i := range w {
+		w[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iter := 0; iter < 5000; iter++ {
+		for i := range w {
+			var grad float64
+			for j := range w {
+				grad += 2 * cov[i][j] * w[j]
+			}
+			next[i] = w[i] - step*grad
+		}
+		projectOnSimplex(next)
+		var change float64
+		for i := range w {
+			change = math.Max(change, math.Abs(next[i]-w[i]))
+			w[i] = next[i]
+		}
+		if change < 1e-12 {
+			break
+		}
+	}
+	return w
+}
+
+// projectOnSimplex replaces v with the closest point whose entries are non-negative
+// and sum to 1.
+func projectOnSimplex(v []float64) {
+	sorted := append([]float64(nil), v...)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+	var cumulative, theta float64
+	for i, u := range sorted {
+		cumulative += u
+		if t := (cumulative - 1) / float64(i+1); u-t > 0 {
+			theta = t
+		}
+	}
+	for i := range v {
+		v[i] = math.Max(v[i]-theta, 0)
+	}
+}
+
+// allocateInverseVolatility fills the budget towards weights inversely proportional to
+// each holding's volatility, so calmer holdings get a bigger share.
+func allocateInverseVolatility(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	return riskAllocation(stocks, budget, params, inverseVolatilityWeights)
+}
+
+// allocateEqualRisk fills the budget towards the weights under which every holding
+// contributes the same amount of risk, taking their correlations into account.
+func allocateEqualRisk(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	return riskAllocation(stocks, budget, params, equalRiskWeights)
+}
+
+// allocateMinVariance fills the budget towards the long-only portfolio with the lowest
+// variance. Unlike the other two it can leave holdings out entirely.
+func allocateMinVariance(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	return riskAllocation(stocks, budget, params, minVarianceWeights)
+}
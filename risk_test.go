@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// stockWithReturns builds a holding whose history (newest first, like the analysis
+// stores it) starts at 100 and then moves by returns, oldest first.
+func stockWithReturns(ticker string, returns []float64) *Stock {
+	closes := []float64{100}
+	for _, r := range returns {
+		closes = append(closes, closes[len(closes)-1]*(1+r))
+	}
+	stock := &Stock{Ticker: ticker, CurrentPrice: closes[len(closes)-1]}
+	for d := len(closes) - 1; d >= 0; d-- {
+		stock.History = append(stock.History, HistoricalPrice{Date: day("2024-01-01").AddDate(0, 0, d).Format("2006-01-02"), Close: closes[d]})
+	}
+	return stock
+}
+
+// alternating returns n returns of +r, -r, +r, ..., whose mean is 0 when n is even.
+func alternating(n int, r float64) []float64 {
+	returns := make([]float64, n)
+	for i := range returns {
+		returns[i] = r
+		if i%2 == 1 {
+			returns[i] = -r
+		}
+	}
+	return returns
+}
+
+func TestCovariance(t *testing.T) {
+	// B moves twice as much as A, C moves against A, and D has no history
+	a := stockWithReturns("A", alternating(30, 0.01))
+	b := stockWithReturns("B", alternating(30, 0.02))
+	c := stockWithReturns("C", alternating(30, -0.01))
+	d := &Stock{Ticker: "D", CurrentPrice: 10}
+
+	used, cov, ok := covariance([]*Stock{a, d, b, c}, 24, rawClose)
+	if !ok {
+		t.Fatal("covariance: ok = false, want true")
+	}
+	if !reflect.DeepEqual(used, []*Stock{a, b, c}) {
+		t.Errorf("used %d stocks, want A, B and C", len(used))
+	}
+	// 24 returns of ±1% with a mean of 0: the sample variance is 24·0.0001/23
+	v := 24 * 0.0001 / 23
+	want := [][]float64{
+		{v, 2 * v, -v},
+		{2 * v, 4 * v, -2 * v},
+		{-v, -2 * v, v},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(cov[i][j]-want[i][j]) > 1e-12 {
+				t.Errorf("cov[%d][%d] = %v, want %v", i, j, cov[i][j], want[i][j])
+			}
+		}
+	}
+
+	if _, _, ok := covariance([]*Stock{a, b}, minRiskReturns-1, rawClose); ok {
+		t.Errorf("covariance over %d returns: ok = true, want false", minRiskReturns-1)
+	}
+	if _, _, ok := covariance([]*Stock{d}, 24, rawClose); ok {
+		t.Error("covariance without history: ok = true, want false")
+	}
+}
+
+func TestCommonCloses(t *testing.T) {
+	a := &Stock{History: []HistoricalPrice{{Date: "2024-01-04", Close: 4}, {Date: "2024-01-03", Close: 3}, {Date: "2024-01-02", Close: 2}}}
+	b := &Stock{History: []HistoricalPrice{{Date: "2024-01-04", Close: 40}, {Date: "2024-01-03", Close: 30}, {Date: "2024-01-02", Close: 20}}}
+	if got, want := commonCloses([]*Stock{a, b}, rawClose), [][]float64{{2, 3, 4}, {20, 30, 40}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("aligned: commonCloses = %v, want %v", got, want)
+	}
+
+	// C misses a day and has no close on another, so only the first day is common
+	c := &Stock{History: []HistoricalPrice{{Date: "2024-01-04", Close: 0}, {Date: "2024-01-02", Close: 200}}}
+	if got, want := commonCloses([]*Stock{a, b, c}, rawClose), [][]float64{{2}, {20}, {200}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("misaligned: commonCloses = %v, want %v", got, want)
+	}
+
+	// The same dates with a missing close fall back to matching the dates
+	e := &Stock{History: []HistoricalPrice{{Date: "2024-01-04", Close: 8}, {Date: "2024-01-03", Close: 0}, {Date: "2024-01-02", Close: 6}}}
+	if got, want := commonCloses([]*Stock{a, e}, rawClose), [][]float64{{2, 4}, {6, 8}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("missing close: commonCloses = %v, want %v", got, want)
+	}
+
+	// Adjusted closes are used where they are known
+	f := &Stock{History: []HistoricalPrice{{Date: "2024-01-04", Close: 8, AdjClose: 4}, {Date: "2024-01-03", Close: 6}, {Date: "2024-01-02", Close: 4, AdjClose: 2}}}
+	if got, want := commonCloses([]*Stock{a, f}, adjustedClose), [][]float64{{2, 3, 4}, {2, 6, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("adjusted: commonCloses = %v, want %v", got, want)
+	}
+}
+
+func assertWeights(t *testing.T, name string, got, want []float64, tolerance float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: got %d weights, want %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > tolerance {
+			t.Errorf("%s: weights = %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+func TestEqualRiskWeights(t *testing.T) {
+	// Without correlation equal risk is inverse volatility: 1/0.2 and 1/0.1
+	diagonal := [][]float64{{0.04, 0}, {0, 0.01}}
+	assertWeights(t, "diagonal", equalRiskWeights(diagonal), []float64{1.0 / 3, 2.0 / 3}, 1e-9)
+	assertWeights(t, "inverse volatility", inverseVolatilityWeights(diagonal), []float64{1.0 / 3, 2.0 / 3}, 1e-12)
+
+	correlated := [][]float64{
+		{0.04, 0.006, 0.002},
+		{0.006, 0.09, 0.027},
+		{0.002, 0.027, 0.01},
+	}
+	w := equalRiskWeights(correlated)
+	var sum float64
+	contributions := make([]float64, len(w))
+	for i := range w {
+		sum += w[i]
+		for j := range w {
+			contributions[i] += w[i] * correlated[i][j] * w[j]
+		}
+	}
+	if math.Abs(sum-1) > 1e-12 {
+		t.Errorf("weights %v sum to %v, want 1", w, sum)
+	}
+	for i := 1; i < len(contributions); i++ {
+		if math.Abs(contributions[i]-contributions[0]) > 1e-6 {
+			t.Errorf("risk contributions %v are not equal", contributions)
+			break
+		}
+	}
+}
+
+func TestMinVarianceWeights(t *testing.T) {
+	tests := []struct {
+		name string
+		cov  [][]float64
+		want []float64
+	}{
+		// Uncorrelated: proportional to 1/variance, 100:25
+		{"uncorrelated", [][]float64{{0.01, 0}, {0, 0.04}}, []float64{0.8, 0.2}},
+		// The unconstrained optimum shorts B, so the long-only one drops it
+		{"drops an asset", [][]float64{{0.01, 0.018}, {0.018, 0.04}}, []float64{1, 0}},
+		{"equal assets", [][]float64{{0.02, 0.01, 0.01}, {0.01, 0.02, 0.01}, {0.01, 0.01, 0.02}}, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+	}
+	for _, tt := range tests {
+		assertWeights(t, tt.name, minVarianceWeights(tt.cov), tt.want, 1e-6)
+	}
+}
+
+func TestProjectOnSimplex(t *testing.T) {
+	tests := []struct {
+		name string
+		v    []float64
+		want []float64
+	}{
+		{"already feasible", []float64{0.2, 0.3, 0.5}, []float64{0.2, 0.3, 0.5}},
+		{"shifted down", []float64{0.5, 0.5, 0.5}, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+		{"shifted up", []float64{0.1, 0.2, 0.1}, []float64{0.3, 0.4, 0.3}},
+		{"negative entry", []float64{1, -0.2}, []float64{1, 0}},
+		{"one dominant entry", []float64{-1, 0.5, 2}, []float64{0, 0, 1}},
+		{"partly clipped", []float64{0.9, 0.6, -0.5}, []float64{0.65, 0.35, 0}},
+	}
+	for _, tt := range tests {
+		v := append([]float64(nil), tt.v...)
+		projectOnSimplex(v)
+		assertWeights(t, tt.name, v, tt.want, 1e-12)
+	}
+}
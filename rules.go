@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+// Rule strategies are strategies defined by the user as expressions instead of code,
+// so eligibility and weighting ideas can be tried without a deploy. Like the
+// watchlist they are part of the settings, so they are audited and event-sourced with
+// them. Once saved they are compiled into a Strategy and run wherever the built-in
+// strategies run: allocations, logs and backtests.
+
+// RuleStrategy is a user-defined strategy. For every stock, Eligible decides whether
+// it may be bought and Weight how much of it; the budget is split between the eligible
+// stocks in proportion to their weights. Stocks whose weight is not positive are not
+// bought.
+type RuleStrategy struct {
+	Key        string `firestore:"key" json:"key"`                                      // Taken from the URL in the API
+	Name       string `firestore:"name" json:"name"`                                    // Shown in the logs, defaults to "Rule: <key>"
+	Eligible   string `firestore:"eligible" json:"eligible"`                            // Condition; empty means every stock
+	Weight     string `firestore:"weight" json:"weight" openapi:"required,minLength=1"` // Expression giving the stock's weight
+	Candidates bool   `firestore:"candidates" json:"candidates"`                        // Also consider the watchlist, not only the holdings
+}
+
+// ruleVariables are the variables rule expressions can use, in the order their values
+// are passed to eval.
+var ruleVariables = []string{
+	"price",            // Last analysed price
+	"ma200",            // Moving average of the price, over 200 days unless tuned
+	"ema_trend",        // Trend of the EMA
+	"quantity",         // Shares held, 0 for watchlist candidates
+	"purchase_price",   // Average purchase price
+	"value",            // quantity * price
+	"portfolio_weight", // value / portfolio_value
+	"target_weight",    // Target weight set for the rebalance strategy
+	"momentum",         // Average 3, 6 and 12-month return as ranked by the momentum strategy, 0 if unknown
+	"volatility",       // Annualised standard deviation of the daily returns over the risk window, 0 if the history is shorter
+	"held",             // 1 for holdings, 0 for watchlist candidates
+	"budget",           // Amount being allocated
+	"portfolio_value",  // Value of all holdings
+	"stocks",           // Number of stocks the strategy chooses from
+}
+
+// ruleKeyPattern keeps keys usable in URLs and collection names.
+var ruleKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// compiledRule holds the parsed expressions of a rule strategy.
+type compiledRule struct {
+	eligible expr // Nil when every stock is eligible
+	weight   expr
+}
+
+// compile checks the rule and turns it into a Strategy.
+func (r RuleStrategy) compile() (Strategy, error) {
+	if !ruleKeyPattern.MatchString(r.Key) {
+		return Strategy{}, fmt.Errorf("%w: the key must be 1 to 32 lowercase letters, digits, dashes or underscores", errInvalidInput)
+	}
+	if _, ok := findStrategy(r.Key); ok {
+		return Strategy{}, fmt.Errorf("%w: %q is the key of a built-in strategy", errInvalidInput, r.Key)
+	}
+
+	var rule compiledRule
+	var err error
+	if strings.TrimSpace(r.Eligible) != "" {
+		if rule.eligible, err = compileExpr(r.Eligible, ruleVariables); err != nil {
+			return Strategy{}, fmt.Errorf("%w: eligible: %v", errInvalidInput, err)
+		}
+	}
+	if strings.TrimSpace(r.Weight) == "" {
+		return Strategy{}, fmt.Errorf("%w: weight is required", errInvalidInput)
+	}
+	if rule.weight, err = compileExpr(r.Weight, ruleVariables); err != nil {
+		return Strategy{}, fmt.Errorf("%w: weight: %v", errInvalidInput, err)
+	}
+
+	name := strings.TrimSpace(r.Name)
+	if name == "" {
+		name = "Rule: " + r.Key
+	}
+	return Strategy{
+		Key:        r.Key,
+		Name:       name,
+		Collection: "rule_" + r.Key + "_logs",
+		Allocate:   rule.allocate,
+		// The variables depend on these parameters, whatever the expressions use
+		Tuned: func(p StrategyParams) StrategyParams {
+			return StrategyParams{MAPeriod: p.MAPeriod, EMAPeriod: p.EMAPeriod, RiskWindow: p.RiskWindow, MomentumSkip: p.MomentumSkip, AdjustedPrices: p.AdjustedPrices}
+		},
+		Candidates: r.Candidates,
+	}, nil
+}
+
+// allocate evaluates the rule for every stock and splits the budget in proportion to
+// the weights of the eligible ones.
+func (rule compiledRule) allocate(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	var portfolioValue float64
+	for _, s := range stocks {
+		portfolioValue += s.Quantity * s.CurrentPrice
+	}
+
+	weights := make([]float64, len(stocks))
+	var total float64
+	for i, s := range stocks {
+		if s.CurrentPrice <= 0 {
+			continue
+		}
+		momentum, _ := momentumScore(s.History, params.MomentumSkip, params.basis())
+		volatility, _ := returnVolatility(closesIn(s.History, newestFirst, params.basis()), params.RiskWindow)
+		value := s.Quantity * s.CurrentPrice
+		var weight float64
+		if portfolioValue > 0 {
+			weight = value / portfolioValue
+		}
+		vars := []float64{
+			s.CurrentPrice, s.MA200, s.EMATrend, s.Quantity, s.Price, value, weight, s.TargetWeight,
+			momentum, volatility, boolValue(s.Quantity > 0),
+			budget, portfolioValue, float64(len(stocks)),
+		}
+
+		if rule.eligible != nil && !truthy(rule.eligible.eval(vars)) {
+			continue
+		}
+		if w := rule.weight.eval(vars); w > 0 && !math.IsInf(w, 0) {
+			weights[i] = w
+			total += w
+		}
+	}
+	if total == 0 || budget <= 0 {
+		return nil
+	}
+
+	var entries []AllocationEntry
+	for i, w := range weights {
+		if w > 0 {
+			entries = append(entries, buyEntry(stocks[i], budget*w/total))
+		}
+	}
+	return entries
+}
+
+// allStrategies returns the built-in strategies followed by the rule strategies.
+// Rules that no longer compile are skipped.
+func (s Settings) allStrategies() []Strategy {
+	all := append([]Strategy(nil), strategies...)
+	for _, r := range s.Rules {
+		strategy, err := r.compile()
+		if err != nil {
+			log.Printf("Skipping rule strategy %s: %v", r.Key, err)
+			continue
+		}
+		all = append(all, strategy)
+	}
+	return all
+}
+
+// findStrategy returns the built-in or rule strategy with the given key.
+func (s Settings) findStrategy(key string) (Strategy, bool) {
+	for _, strategy := range s.allStrategies() {
+		if strategy.Key == key {
+			return strategy, true
+		}
+	}
+	return Strategy{}, false
+}
+
+// lookupStrategy returns the built-in or rule strategy with the given key.
+func lookupStrategy(ctx context.Context, key string) (Strategy, bool) {
+	if strategy, ok := findStrategy(key); ok {
+		return strategy, true
+	}
+	settings, err := getSettings(ctx)
+	if err != nil {
+		log.Printf("Failed to load rule strategies: %v", err)
+		return Strategy{}, false
+	}
+	return settings.findStrategy(key)
+}
+
+// saveRuleStrategy adds a rule strategy, or replaces the one with the same key.
+func saveRuleStrategy(ctx context.Context, rule RuleStrategy) (settings Settings, err error) {
+	rule.Key = strings.TrimSpace(rule.Key)
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Rules = nil
+	for _, existing := range before.Rules {
+		if existing.Key != rule.Key {
+			after.Rules = append(after.Rules, existing)
+		}
+	}
+	after.Rules = append(after.Rules, rule)
+	defer func() { recordAudit(ctx, auditRuleUpdate, rule.Key, before, after, err) }()
+
+	if _, err := rule.compile(); err != nil {
+		return Settings{}, err
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "rules", Value: after.Rules}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update rule strategies: %w", err)
+	}
+	return after, appendEvent(ctx, eventRulesChanged, after)
+}
+
+// deleteRuleStrategy removes a rule strategy. Its logs are kept and show up again if
+// a rule with the same key is saved.
+func deleteRuleStrategy(ctx context.Context, key string) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Rules = nil
+	for _, existing := range before.Rules {
+		if existing.Key != key {
+			after.Rules = append(after.Rules, existing)
+		}
+	}
+	defer func() { recordAudit(ctx, auditRuleUpdate, key, before, after, err) }()
+
+	if len(after.Rules) == len(before.Rules) {
+		return Settings{}, fmt.Errorf("rule strategy %s: %w", key, errNotFound)
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "rules", Value: after.Rules}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update rule strategies: %w", err)
+	}
+	return after, appendEvent(ctx, eventRulesChanged, after)
+}
+
+func handleSaveRuleStrategy(c *gin.Context) {
+	rule := RuleStrategy{
+		Key:        c.PostForm("key"),
+		Name:       c.PostForm("name"),
+		Eligible:   c.PostForm("eligible"),
+		Weight:     c.PostForm("weight"),
+		Candidates: c.PostForm("candidates") == "true",
+	}
+	if _, err := saveRuleStrategy(c.Request.Context(), rule); err != nil {
+		log.Printf("Failed to save rule strategy %s: %v", rule.Key, err)
+		c.String(formErrorStatus(err), "Failed to save rule strategy: %v", err)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func handleDeleteRuleStrategy(c *gin.Context) {
+	key := c.PostForm("key")
+	if _, err := deleteRuleStrategy(c.Request.Context(), key); err != nil {
+		log.Printf("Failed to delete rule strategy %s: %v", key, err)
+		c.String(formErrorStatus(err), "Failed to delete rule strategy")
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func apiListRuleStrategies(c *gin.Context) {
+	settings, err := getSettings(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	rules := settings.Rules
+	if rules == nil {
+		rules = []RuleStrategy{}
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func apiSaveRuleStrategy(c *gin.Context) {
+	var req RuleStrategy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+	req.Key = c.Param("key")
+
+	settings, err := saveRuleStrategy(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func apiDeleteRuleStrategy(c *gin.Context) {
+	if _, err := deleteRuleStrategy(c.Request.Context(), c.Param("key")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
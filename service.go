@@ -0,0 +1,905 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Errors returned by the service layer. Handlers map these onto HTTP status codes.
+var (
+	errNotFound     = errors.New("not found")
+	errInvalidInput = errors.New("invalid input")
+	errUpstream     = errors.New("market data unavailable")
+)
+
+// defaultBudget is the budget a new cycle starts with after an allocation.
+const defaultBudget = 100.0
+
+func settingsDoc() *firestore.DocumentRef {
+	return firestoreClient.Collection("settings").Doc("app")
+}
+
+// listStocks returns every holding in the portfolio.
+func listStocks(ctx context.Context) ([]Stock, error) {
+	var stocks []Stock
+	iter := firestoreClient.Collection("portfolio").Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate portfolio: %w", err)
+		}
+		var stock Stock
+		if err := doc.DataTo(&stock); err != nil {
+			return nil, fmt.Errorf("failed to decode stock %s: %w", doc.Ref.ID, err)
+		}
+		stocks = append(stocks, stock)
+	}
+	return stocks, nil
+}
+
+// getStock returns a single holding by ticker.
+func getStock(ctx context.Context, ticker string) (Stock, error) {
+	doc, err := firestoreClient.Collection("portfolio").Doc(ticker).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return Stock{}, fmt.Errorf("stock %s: %w", ticker, errNotFound)
+	}
+	if err != nil {
+		return Stock{}, fmt.Errorf("failed to get stock %s: %w", ticker, err)
+	}
+	var stock Stock
+	if err := doc.DataTo(&stock); err != nil {
+		return Stock{}, fmt.Errorf("failed to decode stock %s: %w", ticker, err)
+	}
+	return stock, nil
+}
+
+// saveStock adds a holding to the portfolio, replacing any existing one with the same ticker.
+func saveStock(ctx context.Context, stock Stock) (err error) {
+	stock.Ticker = strings.TrimSpace(stock.Ticker)
+	before := existingStock(ctx, stock.Ticker)
+	defer func() { recordAudit(ctx, auditStockAdd, stock.Ticker, before, stock, err) }()
+
+	if stock.Ticker == "" {
+		return fmt.Errorf("%w: ticker is required", errInvalidInput)
+	}
+	if stock.Quantity < 0 || stock.Price < 0 || stock.MinOrder < 0 {
+		return fmt.Errorf("%w: quantity, price and minimum order must not be negative", errInvalidInput)
+	}
+	if stock.TargetWeight < 0 || stock.TargetWeight > 1 {
+		return fmt.Errorf("%w: the target weight must be between 0 and 1", errInvalidInput)
+	}
+	if stock.Withholding < 0 || stock.Withholding >= 1 {
+		return fmt.Errorf("%w: the withholding tax must be between 0 and 1", errInvalidInput)
+	}
+	// Use the Ticker as the document ID in the "portfolio" collection
+	if _, err := firestoreClient.Collection("portfolio").Doc(stock.Ticker).Set(ctx, stock); err != nil {
+		return fmt.Errorf("failed to save stock %s: %w", stock.Ticker, err)
+	}
+	return appendEvent(ctx, eventStockAdded, stock)
+}
+
+// existingStock returns the current state of a holding for the audit log, or nil if
+// it does not exist.
+func existingStock(ctx context.Context, ticker string) *Stock {
+	if ticker == "" {
+		return nil
+	}
+	stock, err := getStock(ctx, ticker)
+	if err != nil {
+		return nil
+	}
+	return &stock
+}
+
+// updateHolding sets the quantity held and average purchase price of a stock, and its
+// order rules, target weight and dividend options unless they are nil.
+func updateHolding(ctx context.Context, ticker string, quantity, price float64, rules *OrderRules, targetWeight *float64, dividends *DividendOptions) (err error) {
+	if ticker == "" {
+		return fmt.Errorf("%w: ticker is required", errInvalidInput)
+	}
+	var before, after *Stock
+	defer func() { recordAudit(ctx, auditStockUpdate, ticker, before, after, err) }()
+
+	if quantity < 0 || price < 0 {
+		return fmt.Errorf("%w: quantity and price must not be negative", errInvalidInput)
+	}
+	// The event carries the whole holding, so it has to be read first
+	stock, err := getStock(ctx, ticker)
+	if err != nil {
+		return err
+	}
+	before = &stock
+	updated := stock
+	updated.Quantity, updated.Price = quantity, price
+	if rules != nil {
+		updated.WholeShares, updated.MinOrder = rules.WholeShares, rules.MinOrder
+	}
+	if targetWeight != nil {
+		updated.TargetWeight = *targetWeight
+	}
+	if dividends != nil {
+		updated.DRIP, updated.Withholding = dividends.DRIP, dividends.Withholding
+	}
+	after = &updated
+
+	updates := []firestore.Update{
+		{Path: "Quantity", Value: quantity},
+		{Path: "Price", Value: price},
+	}
+	if rules != nil {
+		if rules.MinOrder < 0 {
+			return fmt.Errorf("%w: the minimum order must not be negative", errInvalidInput)
+		}
+		updates = append(updates,
+			firestore.Update{Path: "WholeShares", Value: rules.WholeShares},
+			firestore.Update{Path: "MinOrder", Value: rules.MinOrder})
+	}
+	if targetWeight != nil {
+		if *targetWeight < 0 || *targetWeight > 1 {
+			return fmt.Errorf("%w: the target weight must be between 0 and 1", errInvalidInput)
+		}
+		updates = append(updates, firestore.Update{Path: "TargetWeight", Value: *targetWeight})
+	}
+	if dividends != nil {
+		if dividends.Withholding < 0 || dividends.Withholding >= 1 {
+			return fmt.Errorf("%w: the withholding tax must be between 0 and 1", errInvalidInput)
+		}
+		updates = append(updates,
+			firestore.Update{Path: "DRIP", Value: dividends.DRIP},
+			firestore.Update{Path: "Withholding", Value: dividends.Withholding})
+	}
+	_, err = firestoreClient.Collection("portfolio").Doc(ticker).Update(ctx, updates)
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("stock %s: %w", ticker, errNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update stock %s: %w", ticker, err)
+	}
+	return appendEvent(ctx, eventStockUpdated, updated)
+}
+
+// deleteStock removes a holding from the portfolio.
+func deleteStock(ctx context.Context, ticker string) (err error) {
+	if ticker == "" {
+		return fmt.Errorf("%w: ticker is required", errInvalidInput)
+	}
+	before := existingStock(ctx, ticker)
+	defer func() { recordAudit(ctx, auditStockDelete, ticker, before, nil, err) }()
+
+	ref := firestoreClient.Collection("portfolio").Doc(ticker)
+	if _, err := ref.Delete(ctx, firestore.Exists); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("stock %s: %w", ticker, errNotFound)
+		}
+		return fmt.Errorf("failed to delete stock %s: %w", ticker, err)
+	}
+	return appendEvent(ctx, eventStockDeleted, stockDeletedPayload{Ticker: ticker})
+}
+
+// getSettings returns the app settings, creating them with default values if missing.
+func getSettings(ctx context.Context) (Settings, error) {
+	doc, err := settingsDoc().Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		log.Printf("Settings document not found, creating with default values...")
+		settings := Settings{Amount: defaultBudget, NextBatchNumber: 1}
+		if _, err := settingsDoc().Set(ctx, settings); err != nil {
+			return settings, fmt.Errorf("failed to create settings: %w", err)
+		}
+		return settings, nil
+	}
+	if err != nil {
+		return Settings{}, fmt.Errorf("failed to get settings: %w", err)
+	}
+	var settings Settings
+	if err := doc.DataTo(&settings); err != nil {
+		return Settings{}, fmt.Errorf("failed to decode settings: %w", err)
+	}
+	return settings, nil
+}
+
+// updateBudget sets the amount to invest in the next allocation.
+func updateBudget(ctx context.Context, amount float64) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Amount = amount
+	defer func() { recordAudit(ctx, auditBudgetUpdate, "settings", before, after, err) }()
+
+	if amount < 0 || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return Settings{}, fmt.Errorf("%w: amount must be a non-negative number", errInvalidInput)
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "amount", Value: amount}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update budget: %w", err)
+	}
+	return after, appendEvent(ctx, eventBudgetChanged, after)
+}
+
+// updateCosts sets the cost model applied to allocations.
+func updateCosts(ctx context.Context, costs CostModel) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Costs = costs
+	defer func() { recordAudit(ctx, auditCostsUpdate, "settings", before, after, err) }()
+
+	if err := costs.validate(); err != nil {
+		return Settings{}, err
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "costs", Value: costs}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update costs: %w", err)
+	}
+	return after, appendEvent(ctx, eventCostsChanged, after)
+}
+
+// updateDriftBand sets how far above its target weight a holding may drift before the
+// rebalance strategy proposes selling it. Zero turns the sells off.
+func updateDriftBand(ctx context.Context, band float64) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.DriftBand = band
+	defer func() { recordAudit(ctx, auditDriftUpdate, "settings", before, after, err) }()
+
+	if band < 0 || band >= 1 || math.IsNaN(band) {
+		return Settings{}, fmt.Errorf("%w: the drift band must be between 0 and 1", errInvalidInput)
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "driftBand", Value: band}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update drift band: %w", err)
+	}
+	return after, appendEvent(ctx, eventDriftChanged, after)
+}
+
+// updatePriceBasis chooses whether the MA-200 and EMA trend are computed from raw or
+// adjusted closes. The stored indicators keep the old basis until the next analysis.
+func updatePriceBasis(ctx context.Context, adjusted bool) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.AdjustedPrices = adjusted
+	defer func() { recordAudit(ctx, auditPriceBasis, "settings", before, after, err) }()
+
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "adjustedPrices", Value: adjusted}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update price basis: %w", err)
+	}
+	return after, appendEvent(ctx, eventPriceBasis, after)
+}
+
+// updateBenchmark sets the ticker the portfolio and the strategies are compared with in
+// the history and the performance metrics. An empty ticker removes the benchmark.
+func updateBenchmark(ctx context.Context, ticker string) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Benchmark = strings.ToUpper(strings.TrimSpace(ticker))
+	defer func() { recordAudit(ctx, auditBenchmark, "settings", before, after, err) }()
+
+	if len(after.Benchmark) > 20 || strings.ContainsAny(after.Benchmark, " /?#") {
+		return Settings{}, fmt.Errorf("%w: %q is not a ticker", errInvalidInput, ticker)
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "benchmark", Value: after.Benchmark}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update benchmark: %w", err)
+	}
+	return after, appendEvent(ctx, eventBenchmark, after)
+}
+
+// updateValueAveraging sets the value averaging plan. While it is enabled, each
+// allocation invests the gap to the plan's target path instead of the fixed budget.
+func updateValueAveraging(ctx context.Context, va ValueAveraging) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.ValueAveraging = va
+	defer func() { recordAudit(ctx, auditValueAvgUpdate, "settings", before, after, err) }()
+
+	if err := va.validate(); err != nil {
+		return Settings{}, err
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "valueAveraging", Value: va}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update value averaging: %w", err)
+	}
+	return after, appendEvent(ctx, eventValueAvgChanged, after)
+}
+
+// updateExposureLimits sets the shares of the portfolio above which the exposure page
+// flags a holding, sector, country, currency or asset class.
+func updateExposureLimits(ctx context.Context, limits ExposureLimits) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.ExposureLimits = limits
+	defer func() { recordAudit(ctx, auditExposureLimits, "settings", before, after, err) }()
+
+	if err := limits.validate(); err != nil {
+		return Settings{}, err
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "exposureLimits", Value: limits}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update exposure limits: %w", err)
+	}
+	return after, appendEvent(ctx, eventExposureLimits, after)
+}
+
+// budget returns what the next allocation invests in a portfolio of stocks: the
+// contribution the value averaging plan asks for when it is enabled, the fixed
+// budget otherwise, plus the dividend cash received since the last allocation.
+func (s Settings) budget(stocks []Stock, at time.Time) float64 {
+	if !s.ValueAveraging.Enabled {
+		return s.Amount + s.DividendCash
+	}
+	return math.Round((s.ValueAveraging.contribution(holdingsValue(stocks), at)+s.DividendCash)*100) / 100
+}
+
+// liveParams returns the strategy parameters used for the live portfolio.
+func (s Settings) liveParams() StrategyParams {
+	params := defaultStrategyParams
+	params.DriftBand = s.DriftBand
+	params.AdjustedPrices = s.AdjustedPrices
+	return params
+}
+
+// AnalysisResult summarises a run of runAnalysis.
+type AnalysisResult struct {
+	Stocks     []Stock           `json:"stocks"`
+	Candidates []Stock           `json:"candidates,omitempty"` // Watchlist stocks that are not held
+	Failed     map[string]string `json:"failed,omitempty"`
+}
+
+// runAnalysis refreshes the price, MA-200, EMA trend and price history of every holding
+// and watchlist candidate. Stocks that cannot be analysed are reported in Failed and
+// left unchanged.
+func runAnalysis(ctx context.Context) (AnalysisResult, error) {
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return AnalysisResult{}, err
+	}
+
+	// The settings choose the price basis and hold the watchlist
+	settings, err := getSettings(ctx)
+	if err != nil {
+		log.Printf("Could not load the settings: %v", err)
+	}
+	basis := settings.liveParams().basis()
+
+	result := AnalysisResult{Failed: make(map[string]string)}
+	// This can be slow! In a real app, this would be a background job.
+	for _, stock := range stocks {
+		currentPrice, ma200, emaTrend, history, err := fetchAndAnalyzeStock(stock.Ticker, basis)
+		if err != nil {
+			log.Printf("Could not analyze %s: %v", stock.Ticker, err)
+			result.Failed[stock.Ticker] = err.Error()
+			result.Stocks = append(result.Stocks, stock)
+			continue
+		}
+
+		stock.CurrentPrice = currentPrice
+		stock.MA200 = ma200
+		stock.IsBelowMA = currentPrice < ma200
+		stock.EMATrend = emaTrend
+		stock.History = history
+		// The profile rarely changes, so it is only fetched again once it is a month old. Like
+		// the dividends, the analysis goes on without it.
+		if time.Since(stock.Profile.Updated) > profileMaxAge {
+			if profile, err := fetchProfile(stock.Ticker); err != nil {
+				log.Printf("Could not fetch the profile of %s: %v", stock.Ticker, err)
+			} else {
+				stock.Profile = profile
+			}
+		}
+		// Dividends are only needed for the income projection, so the analysis goes on without them
+		if perShare, err := trailingDividends(stock.Ticker, time.Now()); err != nil {
+			log.Printf("Could not fetch the dividends of %s: %v", stock.Ticker, err)
+		} else {
+			stock.DividendTTM = perShare
+		}
+
+		if _, err := firestoreClient.Collection("portfolio").Doc(stock.Ticker).Set(ctx, stock); err != nil {
+			log.Printf("Failed to update stock %s: %v", stock.Ticker, err)
+			result.Failed[stock.Ticker] = err.Error()
+		} else if err := appendEvent(ctx, eventStockAnalyzed, stock); err != nil {
+			log.Print(err)
+			result.Failed[stock.Ticker] = err.Error()
+		}
+		result.Stocks = append(result.Stocks, stock)
+	}
+
+	// Watchlist candidates are analysed the same way, but their prices are only
+	// cached for the strategies, so no event is recorded
+	analyzed := len(result.Stocks) - len(result.Failed)
+	for _, item := range settings.notHeld(stocks) {
+		currentPrice, ma200, emaTrend, history, err := fetchAndAnalyzeStock(item.Ticker, basis)
+		if err != nil {
+			log.Printf("Could not analyze watchlist entry %s: %v", item.Ticker, err)
+			result.Failed[item.Ticker] = err.Error()
+			continue
+		}
+		candidate := Stock{Ticker: item.Ticker, Name: item.Name, CurrentPrice: currentPrice, MA200: ma200,
+			IsBelowMA: currentPrice < ma200, EMATrend: emaTrend, History: history}
+		if _, err := watchlistDoc(item.Ticker).Set(ctx, candidate); err != nil {
+			log.Printf("Failed to cache watchlist entry %s: %v", item.Ticker, err)
+			result.Failed[item.Ticker] = err.Error()
+			continue
+		}
+		result.Candidates = append(result.Candidates, candidate)
+	}
+
+	recordAudit(ctx, auditAnalysis, "portfolio", nil, map[string]interface{}{
+		"analyzed": analyzed + len(result.Candidates),
+		"failed":   result.Failed,
+	}, nil)
+
+	return result, nil
+}
+
+// StrategyAllocation holds the decisions of one strategy for an allocation.
+type StrategyAllocation struct {
+	Key      string            `json:"key"`
+	Strategy string            `json:"strategy"`
+	Entries  []AllocationEntry `json:"entries"`
+	Leftover float64           `json:"leftover"` // Part of the budget the orders don't use
+}
+
+// AllocationPlan is the outcome of running every strategy against the current budget.
+type AllocationPlan struct {
+	Batch  int     `json:"batch"`
+	Budget float64 `json:"budget"`
+	// Value the value averaging path asks for today, when it is enabled
+	Target     float64              `json:"target,omitempty"`
+	RolledOver bool                 `json:"rolledOver"` // True when the primary strategy found nothing to buy
+	Strategies []StrategyAllocation `json:"strategies"`
+}
+
+// previewAllocation computes what an allocation would do without writing anything.
+func previewAllocation(ctx context.Context) (AllocationPlan, []Stock, error) {
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return AllocationPlan{}, nil, err
+	}
+	stocks, err := listStocks(ctx)
+	if err != nil {
+		return AllocationPlan{}, nil, err
+	}
+
+	candidates, err := watchlistCandidates(ctx, settings, stocks)
+	if err != nil {
+		return AllocationPlan{}, nil, err
+	}
+
+	portfolioStocks := make([]*Stock, len(stocks))
+	for i := range stocks {
+		portfolioStocks[i] = &stocks[i]
+	}
+	withCandidates := portfolioStocks
+	for i := range candidates {
+		withCandidates = append(withCandidates, &candidates[i])
+	}
+
+	now := time.Now()
+	budget := settings.budget(stocks, now)
+	plan := AllocationPlan{Batch: settings.NextBatchNumber, Budget: budget, RolledOver: true}
+	if settings.ValueAveraging.Enabled {
+		plan.Target, _ = settings.ValueAveraging.target(now)
+	}
+	for _, s := range settings.allStrategies() {
+		universe := portfolioStocks
+		if s.Candidates {
+			universe = withCandidates
+		}
+		entries := executeOrders(s.Allocate(universe, budget, settings.liveParams()), universe, settings.Costs)
+		if s.Key == primaryStrategyKey && len(entries) > 0 {
+			plan.RolledOver = false
+		}
+		leftover := budget
+		for _, e := range entries {
+			if e.QuantityBought > 0 {
+				leftover -= e.InvestmentAmount + e.Fees
+			}
+		}
+		plan.Strategies = append(plan.Strategies, StrategyAllocation{Key: s.Key, Strategy: s.Name, Entries: entries, Leftover: math.Max(0, leftover)})
+	}
+	return plan, stocks, nil
+}
+
+// commitAllocation runs every strategy, applies the primary strategy's purchases to the
+// portfolio and logs all decisions. If the primary strategy buys anything the batch
+// number is incremented and the budget reset, plus whatever the primary strategy's
+// orders left unspent; otherwise the whole budget rolls over. Under value averaging
+// the budget is left alone, since the next gap to the path already includes anything
+// that wasn't invested.
+func commitAllocation(ctx context.Context) (plan AllocationPlan, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return AllocationPlan{}, err
+	}
+	defer func() { recordAudit(ctx, auditAllocation, fmt.Sprintf("batch %d", plan.Batch), before, plan, err) }()
+
+	plan, stocks, err := previewAllocation(ctx)
+	if err != nil {
+		return plan, err
+	}
+	now := time.Now()
+	event := allocationPayload{Settings: before}
+
+	for _, sa := range plan.Strategies {
+		strategy, _ := before.findStrategy(sa.Key)
+		if sa.Key == primaryStrategyKey {
+			event.Holdings = applyPrimaryAllocation(ctx, stocks, sa.Entries)
+		}
+		for _, entry := range sa.Entries {
+			logEntry, err := writeInvestmentLog(ctx, strategy, plan.Batch, entry, now)
+			if err != nil {
+				log.Printf("Failed to add %s log for %s: %v", strategy.Key, entry.Ticker, err)
+				continue
+			}
+			event.Logs = append(event.Logs, logEntry)
+		}
+	}
+
+	if plan.RolledOver {
+		log.Println("No eligible stocks for investment. Budget will roll over.")
+		return plan, appendEvent(ctx, eventAllocation, event)
+	}
+
+	nextBudget := defaultBudget
+	for _, sa := range plan.Strategies {
+		if sa.Key == primaryStrategyKey {
+			nextBudget += math.Round(sa.Leftover*100) / 100
+		}
+	}
+	if before.ValueAveraging.Enabled {
+		nextBudget = before.Amount
+	}
+	_, err = settingsDoc().Update(ctx, []firestore.Update{
+		{Path: "amount", Value: nextBudget},
+		{Path: "nextBatchNumber", Value: plan.Batch + 1},
+		// Only the dividends this allocation invested; any applied meanwhile wait for the next
+		{Path: "dividendCash", Value: firestore.Increment(-before.DividendCash)},
+	})
+	if err != nil {
+		// The holdings and logs are written, so they still need their event
+		if eventErr := appendEvent(ctx, eventAllocation, event); eventErr != nil {
+			log.Print(eventErr)
+		}
+		return plan, fmt.Errorf("failed to reset budget after allocation: %w", err)
+	}
+	event.Settings.Amount, event.Settings.NextBatchNumber, event.Settings.DividendCash = nextBudget, plan.Batch+1, 0
+	return plan, appendEvent(ctx, eventAllocation, event)
+}
+
+// applyPrimaryAllocation adds the bought quantities to the holdings, updates their
+// average price and sets the recommendation text shown on the dashboard. It returns
+// the holdings as written.
+func applyPrimaryAllocation(ctx context.Context, stocks []Stock, entries []AllocationEntry) []Stock {
+	if len(entries) == 0 {
+		return nil
+	}
+	bought := make(map[string]AllocationEntry)
+	for _, entry := range entries {
+		bought[entry.Ticker] = entry
+	}
+
+	var written []Stock
+	for _, stock := range stocks {
+		ref := firestoreClient.Collection("portfolio").Doc(stock.Ticker)
+		entry, ok := bought[stock.Ticker]
+		if !ok {
+			// Clear recommendations for non-eligible stocks
+			if _, err := ref.Update(ctx, []firestore.Update{{Path: "Recommendation", Value: ""}}); err == nil {
+				stock.Recommendation = ""
+				written = append(written, stock)
+			}
+			continue
+		}
+
+		// Fees are part of the cost basis, and of the cash the recommendation asks for
+		cost := entry.InvestmentAmount + entry.Fees
+		totalOldValue := stock.Price * stock.Quantity
+		newTotalQuantity := stock.Quantity + entry.QuantityBought
+		newAveragePrice := (totalOldValue + cost) / newTotalQuantity
+		newTotalQuantity = math.Round(newTotalQuantity*100) / 100
+
+		_, err := ref.Update(ctx, []firestore.Update{
+			{Path: "Quantity", Value: newTotalQuantity},
+			{Path: "Price", Value: newAveragePrice},
+			{Path: "Recommendation", Value: fmt.Sprintf("Invest €%.2f", cost)},
+		})
+		if err != nil {
+			log.Printf("Failed to auto-update portfolio for %s: %v", stock.Ticker, err)
+			continue
+		}
+		stock.Quantity = newTotalQuantity
+		stock.Price = newAveragePrice
+		stock.Recommendation = fmt.Sprintf("Invest €%.2f", cost)
+		written = append(written, stock)
+	}
+	return written
+}
+
+// writeInvestmentLog adds a log document for entry to the strategy's collection and
+// returns the log as written.
+func writeInvestmentLog(ctx context.Context, strategy Strategy, batch int, entry AllocationEntry, timestamp time.Time) (InvestmentLog, error) {
+	ref := firestoreClient.Collection(strategy.Collection).NewDoc()
+	data := map[string]interface{}{
+		"batch":            batch,
+		"ticker":           entry.Ticker,
+		"name":             entry.Name,
+		"investmentAmount": entry.InvestmentAmount,
+		"pricePerShare":    entry.PricePerShare,
+		"quantityBought":   entry.QuantityBought,
+		"fees":             entry.Fees,
+		"strategy":         strategy.Name,
+		"timestamp":        timestamp,
+	}
+	if entry.Rank > 0 {
+		data["rank"], data["score"] = entry.Rank, entry.Score
+	}
+	_, err := ref.Set(ctx, data)
+	return InvestmentLog{
+		ID:               ref.ID,
+		StrategyKey:      strategy.Key,
+		Batch:            batch,
+		Ticker:           entry.Ticker,
+		Name:             entry.Name,
+		InvestmentAmount: entry.InvestmentAmount,
+		PricePerShare:    entry.PricePerShare,
+		QuantityBought:   entry.QuantityBought,
+		Fees:             entry.Fees,
+		Rank:             entry.Rank,
+		Score:            entry.Score,
+		Strategy:         strategy.Name,
+		Timestamp:        timestamp,
+	}, err
+}
+
+// LogFilter narrows down a log query. Zero values mean "no filter".
+type LogFilter struct {
+	Strategy string // Strategy key, e.g. "ma200"
+	Ticker   string
+	Batch    int
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+// loadLogs reads every log of the given strategy, newest batch first.
+func loadLogs(ctx context.Context, strategy Strategy) ([]InvestmentLog, error) {
+	q := firestoreClient.Collection(strategy.Collection).OrderBy("batch", firestore.Desc).OrderBy("timestamp", firestore.Desc)
+	return readLogs(ctx, strategy, q)
+}
+
+// readLogs returns the logs of strategy selected by q.
+func readLogs(ctx context.Context, strategy Strategy, q firestore.Query) ([]InvestmentLog, error) {
+	var logs []InvestmentLog
+	iter := q.Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate logs from %s: %w", strategy.Collection, err)
+		}
+
+		var logEntry InvestmentLog
+		if err := doc.DataTo(&logEntry); err != nil {
+			log.Printf("Failed to convert log document from %s: %v", strategy.Collection, err)
+			continue
+		}
+		logEntry.ID = doc.Ref.ID
+		logEntry.StrategyKey = strategy.Key
+		logs = append(logs, logEntry)
+	}
+	return logs, nil
+}
+
+// countDocuments returns the number of documents matched by q without reading them.
+func countDocuments(ctx context.Context, q firestore.Query) (int, error) {
+	result, err := q.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := result["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", result["count"])
+	}
+	return int(value.GetIntegerValue()), nil
+}
+
+// logsQuery builds the Firestore query selecting the logs of strategy that match
+// filter, newest first.
+func logsQuery(strategy Strategy, filter LogFilter) firestore.Query {
+	q := firestoreClient.Collection(strategy.Collection).Query
+	if filter.Ticker != "" {
+		q = q.Where("ticker", "==", filter.Ticker)
+	}
+	if filter.Batch != 0 {
+		q = q.Where("batch", "==", filter.Batch)
+	}
+	if !filter.From.IsZero() {
+		q = q.Where("timestamp", ">=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		q = q.Where("timestamp", "<", filter.To)
+	}
+	return q.OrderBy("timestamp", firestore.Desc)
+}
+
+// queryLogs returns the logs matching filter together with the total number of matches
+// before pagination was applied. The filters and the page size are evaluated by
+// Firestore, so only the requested page is read from each collection.
+func queryLogs(ctx context.Context, filter LogFilter) ([]InvestmentLog, int, error) {
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, 0, fmt.Errorf("%w: limit and offset must not be negative", errInvalidInput)
+	}
+
+	settings, err := getSettings(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	selected := settings.allStrategies()
+	if filter.Strategy != "" {
+		strategy, ok := settings.findStrategy(filter.Strategy)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: unknown strategy %q", errInvalidInput, filter.Strategy)
+		}
+		selected = []Strategy{strategy}
+	}
+
+	matches := []InvestmentLog{}
+	total := 0
+	for _, strategy := range selected {
+		q := logsQuery(strategy, filter)
+		if filter.Limit > 0 {
+			count, err := countDocuments(ctx, q)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to count logs in %s: %w", strategy.Collection, err)
+			}
+			total += count
+			if len(selected) == 1 {
+				q = q.Offset(filter.Offset).Limit(filter.Limit)
+			} else {
+				// The page can be drawn from any of the collections once they are merged.
+				q = q.Limit(filter.Offset + filter.Limit)
+			}
+		}
+		logs, err := readLogs(ctx, strategy, q)
+		if err != nil {
+			return nil, 0, err
+		}
+		matches = append(matches, logs...)
+	}
+	if filter.Limit == 0 {
+		total = len(matches)
+	}
+	if len(selected) > 1 || filter.Limit == 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			if !matches[i].Timestamp.Equal(matches[j].Timestamp) {
+				return matches[i].Timestamp.After(matches[j].Timestamp)
+			}
+			return matches[i].Batch > matches[j].Batch
+		})
+		if filter.Offset >= len(matches) {
+			return []InvestmentLog{}, total, nil
+		}
+		matches = matches[filter.Offset:]
+		if filter.Limit > 0 && filter.Limit < len(matches) {
+			matches = matches[:filter.Limit]
+		}
+	}
+
+	// Logs keep the shares they were written with; the split factor relates them to today's
+	if splits, err := appliedSplits(ctx); err != nil {
+		log.Printf("Failed to load splits for the logs: %v", err)
+	} else {
+		splits.adjustLogs(matches)
+	}
+	return matches, total, nil
+}
+
+// deleteLog removes a single log entry of the given strategy.
+func deleteLog(ctx context.Context, strategyKey, logID string) (err error) {
+	var before *InvestmentLog
+	defer func() { recordAudit(ctx, auditLogDelete, strategyKey+"/"+logID, before, nil, err) }()
+
+	strategy, ok := lookupStrategy(ctx, strategyKey)
+	if !ok {
+		return fmt.Errorf("%w: unknown strategy %q", errInvalidInput, strategyKey)
+	}
+	if logID == "" {
+		return fmt.Errorf("%w: log ID is required", errInvalidInput)
+	}
+	ref := firestoreClient.Collection(strategy.Collection).Doc(logID)
+	if doc, err := ref.Get(ctx); err == nil {
+		var logEntry InvestmentLog
+		if doc.DataTo(&logEntry) == nil {
+			logEntry.ID, logEntry.StrategyKey = logID, strategyKey
+			before = &logEntry
+		}
+	}
+	_, err = ref.Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("log %s: %w", logID, errNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete log entry %s: %w", logID, err)
+	}
+	return appendEvent(ctx, eventLogDeleted, logDeletedPayload{Strategy: strategyKey, IDs: []string{logID}})
+}
+
+// deleteLogBatch removes every log of the given strategy that belongs to batch.
+func deleteLogBatch(ctx context.Context, strategyKey string, batch int) (err error) {
+	var deletedLogs []InvestmentLog
+	defer func() {
+		before := map[string]interface{}{"strategy": strategyKey, "batch": batch, "logs": deletedLogs}
+		recordAudit(ctx, auditLogBatchDelete, fmt.Sprintf("%s batch %d", strategyKey, batch), before, nil, err)
+	}()
+
+	strategy, ok := lookupStrategy(ctx, strategyKey)
+	if !ok {
+		return fmt.Errorf("%w: unknown strategy %q", errInvalidInput, strategyKey)
+	}
+	if batch <= 0 {
+		return fmt.Errorf("%w: invalid batch number", errInvalidInput)
+	}
+
+	iter := firestoreClient.Collection(strategy.Collection).Where("batch", "==", batch).Documents(ctx)
+	// Use a batched write to delete all found documents efficiently
+	batchWrite := firestoreClient.Batch()
+	var found []InvestmentLog
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to iterate for batch delete: %w", err)
+		}
+		var logEntry InvestmentLog
+		doc.DataTo(&logEntry)
+		logEntry.ID, logEntry.StrategyKey = doc.Ref.ID, strategyKey
+		found = append(found, logEntry)
+		batchWrite.Delete(doc.Ref)
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	if _, err := batchWrite.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit batch delete for batch %d: %w", batch, err)
+	}
+	deletedLogs = found
+
+	ids := make([]string, len(found))
+	for i, l := range found {
+		ids[i] = l.ID
+	}
+	return appendEvent(ctx, eventLogBatchDeleted, logDeletedPayload{Strategy: strategyKey, IDs: ids})
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"sort"
+)
+
+// AllocationEntry is a single buy decision made by a strategy. Sells are
+// represented with a negative InvestmentAmount and QuantityBought. Once executed
+// through a CostModel, InvestmentAmount and PricePerShare are net of costs and
+// Fees holds the commission paid on top.
+type AllocationEntry struct {
+	Ticker           string  `json:"ticker"`
+	Name             string  `json:"name"`
+	InvestmentAmount float64 `json:"investmentAmount"`
+	PricePerShare    float64 `json:"pricePerShare"`
+	QuantityBought   float64 `json:"quantityBought"`
+	Fees             float64 `json:"fees"`
+	Rank             int     `json:"rank,omitempty"`  // Position in the strategy's ranking, for strategies that rank stocks
+	Score            float64 `json:"score,omitempty"` // The value the ranking is based on
+}
+
+// StrategyParams are the tunable parameters of the indicators and strategies.
+type StrategyParams struct {
+	MAPeriod        int     `json:"maPeriod" openapi:"min=2"`          // Window of the moving average, in trading days
+	EMAPeriod       int     `json:"emaPeriod" openapi:"min=2"`         // Period of the EMA trend signal, in trading days
+	EMAWinners      int     `json:"emaWinners" openapi:"min=1"`        // Number of stocks with the best EMA trend the EMA strategy buys
+	MAThreshold     float64 `json:"maThreshold" openapi:"min=0,max=1"` // Minimum discount to the MA (0.05 = 5%) to be eligible for the MA strategy
+	DriftBand       float64 `json:"driftBand" openapi:"min=0,max=1"`   // Drift above the target weight (0.05 = 5 points) at which the rebalance strategy sells; 0 never sells
+	RiskWindow      int     `json:"riskWindow" openapi:"min=2"`        // Daily returns the risk-based and momentum strategies estimate volatility from
+	MomentumWinners int     `json:"momentumWinners" openapi:"min=1"`   // Number of top-ranked stocks the momentum strategy buys
+	MomentumSkip    int     `json:"momentumSkip" openapi:"min=1"`      // Most recent trading days left out of the momentum lookbacks, so short-term reversals don't distort the ranking
+	AdjustedPrices  bool    `json:"adjustedPrices"`                    // Compute the MA and EMA trend from split- and dividend-adjusted closes
+}
+
+// basis returns the closes the MA and EMA trend are computed from.
+func (p StrategyParams) basis() priceBasis {
+	if p.AdjustedPrices {
+		return adjustedClose
+	}
+	return rawClose
+}
+
+// defaultStrategyParams are the parameters used for the live portfolio. RiskWindow
+// is a year of daily returns and MomentumSkip about a month.
+var defaultStrategyParams = StrategyParams{MAPeriod: 200, EMAPeriod: 112, EMAWinners: 2, MAThreshold: 0, RiskWindow: 249,
+	MomentumWinners: 3, MomentumSkip: 21}
+
+// withDefaults fills unset parameters from defaultStrategyParams.
+func (p StrategyParams) withDefaults() StrategyParams {
+	if p.MAPeriod == 0 {
+		p.MAPeriod = defaultStrategyParams.MAPeriod
+	}
+	if p.EMAPeriod == 0 {
+		p.EMAPeriod = defaultStrategyParams.EMAPeriod
+	}
+	if p.EMAWinners == 0 {
+		p.EMAWinners = defaultStrategyParams.EMAWinners
+	}
+	if p.RiskWindow == 0 {
+		p.RiskWindow = defaultStrategyParams.RiskWindow
+	}
+	if p.MomentumWinners == 0 {
+		p.MomentumWinners = defaultStrategyParams.MomentumWinners
+	}
+	if p.MomentumSkip == 0 {
+		p.MomentumSkip = defaultStrategyParams.MomentumSkip
+	}
+	return p
+}
+
+// Strategy describes an allocation strategy and where its decisions are logged.
+type Strategy struct {
+	Key        string // Short identifier used by the API, e.g. "ma200"
+	Name       string // Value written to the "strategy" field of each log
+	Collection string // Firestore collection the logs are written to
+	Allocate   func(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry
+	// Tuned reduces params to the ones this strategy actually uses, so parameter
+	// sweeps don't evaluate the same configuration twice. Nil means none.
+	Tuned func(params StrategyParams) StrategyParams
+	// Candidates is true for strategies that also consider the watchlist, not only
+	// the stocks already held.
+	Candidates bool
+}
+
+// primaryStrategyKey is the strategy whose purchases are applied to the real portfolio.
+const primaryStrategyKey = "ma200"
+
+// strategies lists every strategy that is run on each allocation, in logging order.
+var strategies = []Strategy{
+	{Key: "ma200", Name: "200-Day MA Undervalued", Collection: "investment_logs", Allocate: allocateMA200,
+		Tuned: func(p StrategyParams) StrategyParams {
+			return StrategyParams{MAPeriod: p.MAPeriod, MAThreshold: p.MAThreshold, AdjustedPrices: p.AdjustedPrices}
+		}},
+	{Key: "naive", Name: "Naive Proportional Allocation", Collection: "naive_strategy_logs", Allocate: allocateNaive},
+	{Key: "ema", Name: "ema-approach", Collection: "ema_logs", Allocate: allocateEMA,
+		Tuned: func(p StrategyParams) StrategyParams {
+			return StrategyParams{EMAPeriod: p.EMAPeriod, EMAWinners: p.EMAWinners, AdjustedPrices: p.AdjustedPrices}
+		}},
+	{Key: "rebalance", Name: "Target Weight Rebalancing", Collection: "rebalance_logs", Allocate: allocateRebalance,
+		Tuned: func(p StrategyParams) StrategyParams {
+			return StrategyParams{DriftBand: p.DriftBand}
+		}},
+	{Key: "invvol", Name: "Inverse Volatility", Collection: "inverse_volatility_logs", Allocate: allocateInverseVolatility, Tuned: riskTuned},
+	{Key: "erc", Name: "Equal Risk Contribution", Collection: "risk_parity_logs", Allocate: allocateEqualRisk, Tuned: riskTuned},
+	{Key: "minvar", Name: "Minimum Variance", Collection: "min_variance_logs", Allocate: allocateMinVariance, Tuned: riskTuned},
+	{Key: "momentum", Name: "Cross-Sectional Momentum", Collection: "momentum_logs", Allocate: allocateMomentum, Candidates: true,
+		Tuned: func(p StrategyParams) StrategyParams {
+			return StrategyParams{RiskWindow: p.RiskWindow, MomentumWinners: p.MomentumWinners, MomentumSkip: p.MomentumSkip, AdjustedPrices: p.AdjustedPrices}
+		}},
+}
+
+// findStrategy looks up a registered strategy by its key.
+func findStrategy(key string) (Strategy, bool) {
+	for _, s := range strategies {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return Strategy{}, false
+}
+
+// buyEntry builds an entry that invests amount into stock at its current price.
+func buyEntry(stock *Stock, amount float64) AllocationEntry {
+	return AllocationEntry{
+		Ticker:           stock.Ticker,
+		Name:             stock.Name,
+		InvestmentAmount: amount,
+		PricePerShare:    stock.CurrentPrice,
+		QuantityBought:   amount / stock.CurrentPrice,
+	}
+}
+
+// allocateMA200 splits the budget across stocks trading below their moving average
+// (by at least params.MAThreshold), weighted by how far below the average they are.
+func allocateMA200(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	var eligibleStocks []*Stock
+	var totalScore float64
+	for _, stock := range stocks {
+		if stock.IsBelowMA && stock.MA200 > 0 && stock.CurrentPrice > 0 && stock.CurrentPrice <= stock.MA200*(1-params.MAThreshold) {
+			totalScore += stock.MA200 - stock.CurrentPrice
+			eligibleStocks = append(eligibleStocks, stock)
+		}
+	}
+	if totalScore <= 0 {
+		return nil
+	}
+
+	var entries []AllocationEntry
+	for _, stock := range eligibleStocks {
+		weight := (stock.MA200 - stock.CurrentPrice) / totalScore
+		entries = append(entries, buyEntry(stock, budget*weight))
+	}
+	return entries
+}
+
+// allocateNaive splits the budget proportionally to the current value of each holding.
+func allocateNaive(stocks []*Stock, budget float64, _ StrategyParams) []AllocationEntry {
+	var totalPortfolioValue float64
+	for _, stock := range stocks {
+		totalPortfolioValue += stock.CurrentPrice * stock.Quantity
+	}
+	if totalPortfolioValue <= 0 {
+		return nil
+	}
+
+	var entries []AllocationEntry
+	for _, stock := range stocks {
+		weight := stock.CurrentPrice * stock.Quantity / totalPortfolioValue
+		entries = append(entries, buyEntry(stock, budget*weight))
+	}
+	return entries
+}
+
+// allocateEMA "sells" one share of the stock with the most negative EMA trend and
+// splits the budget between the params.EMAWinners stocks with the most positive
+// trends, weighted by their trend. Nothing is bought unless all winners are positive.
+func allocateEMA(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	winners := params.EMAWinners
+	if winners < 1 || len(stocks) < winners+1 {
+		return nil
+	}
+
+	ranked := make([]*Stock, len(stocks))
+	copy(ranked, stocks)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].EMATrend > ranked[j].EMATrend })
+
+	var entries []AllocationEntry
+	if mostNegativeStock := ranked[len(ranked)-1]; mostNegativeStock.EMATrend < 0 {
+		entries = append(entries, AllocationEntry{
+			Ticker:           mostNegativeStock.Ticker,
+			Name:             mostNegativeStock.Name,
+			InvestmentAmount: -mostNegativeStock.CurrentPrice, // Negative for sell
+			PricePerShare:    mostNegativeStock.CurrentPrice,
+			QuantityBought:   -1, // Negative for sell
+		})
+	}
+
+	var totalPositiveEma float64
+	for _, stock := range ranked[:winners] {
+		if stock.EMATrend <= 0 {
+			return entries
+		}
+		totalPositiveEma += stock.EMATrend
+	}
+	for _, stock := range ranked[:winners] {
+		entries = append(entries, buyEntry(stock, budget*stock.EMATrend/totalPositiveEma))
+	}
+	return entries
+}
+
+// targetWeights returns the normalised target weight of each stock the rebalance
+// strategy manages. Stocks without a target are left alone, unless none has one, in
+// which case every stock gets the same weight.
+func targetWeights(stocks []*Stock) map[*Stock]float64 {
+	weights := make(map[*Stock]float64)
+	var total float64
+	for _, stock := range stocks {
+		if stock.TargetWeight > 0 && stock.CurrentPrice > 0 {
+			weights[stock] = stock.TargetWeight
+			total += stock.TargetWeight
+		}
+	}
+	if total == 0 {
+		for _, stock := range stocks {
+			if stock.CurrentPrice > 0 {
+				weights[stock] = 1
+				total++
+			}
+		}
+	}
+	for stock := range weights {
+		weights[stock] /= total
+	}
+	return weights
+}
+
+// allocateRebalance directs the budget to the holdings furthest below their target
+// weight: the most underweight holding is topped up until it is as underweight as the
+// next one, then both are topped up together, and so on until the budget runs out.
+// When params.DriftBand is set, holdings whose weight exceeds their target by more
+// than the band are "sold" back down to their target.
+func allocateRebalance(stocks []*Stock, budget float64, params StrategyParams) []AllocationEntry {
+	weights := targetWeights(stocks)
+	if len(weights) == 0 {
+		return nil
+	}
+
+	var managed []*Stock
+	var totalValue float64
+	for _, stock := range stocks {
+		if _, ok := weights[stock]; ok {
+			managed = append(managed, stock)
+			totalValue += stock.CurrentPrice * stock.Quantity
+		}
+	}
+
+	var entries []AllocationEntry
+	var candidates []*Stock
+	for _, stock := range managed {
+		value := stock.CurrentPrice * stock.Quantity
+		if params.DriftBand > 0 && totalValue > 0 && value/totalValue-weights[stock] > params.DriftBand {
+			qty := (value - weights[stock]*totalValue) / stock.CurrentPrice
+			entries = append(entries, AllocationEntry{
+				Ticker:           stock.Ticker,
+				Name:             stock.Name,
+				InvestmentAmount: -qty * stock.CurrentPrice,
+				PricePerShare:    stock.CurrentPrice,
+				QuantityBought:   -qty,
+			})
+			continue
+		}
+		candidates = append(candidates, stock)
+	}
+	return append(entries, fillToWeights(candidates, weights, budget)...)
+}
+
+// fillToWeights spends budget on stocks so that they move towards their weights, from
+// the lowest value-to-weight ratio up: it finds the level L for which topping every
+// stock below it up to weight*L uses exactly the budget. Every stock must have a
+// positive weight and price.
+func fillToWeights(stocks []*Stock, weights map[*Stock]float64, budget float64) []AllocationEntry {
+	if budget <= 0 || len(stocks) == 0 {
+		return nil
+	}
+	ratio := func(s *Stock) float64 { return s.CurrentPrice * s.Quantity / weights[s] }
+	sorted := append([]*Stock(nil), stocks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return ratio(sorted[i]) < ratio(sorted[j]) })
+	var filledValue, filledWeight, level float64
+	n := 0
+	for n < len(sorted) {
+		stock := sorted[n]
+		filledValue += stock.CurrentPrice * stock.Quantity
+		filledWeight += weights[stock]
+		n++
+		level = (budget + filledValue) / filledWeight
+		if n == len(sorted) || level <= ratio(sorted[n]) {
+			break
+		}
+	}
+
+	var entries []AllocationEntry
+	for _, stock := range sorted[:n] {
+		if amount := weights[stock]*level - stock.CurrentPrice*stock.Quantity; amount > 0 {
+			entries = append(entries, buyEntry(stock, amount))
+		}
+	}
+	return entries
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A parameter sweep backtests every combination in a grid of StrategyParams using
+// walk-forward validation: the history is cut into consecutive train/test windows,
+// the best parameters of each strategy are picked on the train window and then
+// judged on the test window that follows it. Parameters that only look good
+// in-sample show up as a large gap between their train and test returns.
+
+const (
+	// sweepBaseline is the strategy the others have to beat out of sample.
+	sweepBaseline = "naive"
+	// maxSweepRuns caps the number of backtests a single sweep may run.
+	maxSweepRuns = 2000
+)
+
+// ParamGrid lists the values to try for each parameter. An empty list means only
+// the live value is used.
+type ParamGrid struct {
+	MAPeriods    []int     `json:"maPeriods"`
+	EMAPeriods   []int     `json:"emaPeriods"`
+	EMAWinners   []int     `json:"emaWinners"`
+	MAThresholds []float64 `json:"maThresholds"`
+	DriftBands   []float64 `json:"driftBands"`
+}
+
+// combinations returns every StrategyParams in the grid.
+func (g ParamGrid) combinations() []StrategyParams {
+	orDefault := func(values []int, def int) []int {
+		if len(values) == 0 {
+			return []int{def}
+		}
+		return values
+	}
+	orDefaultFloat := func(values []float64, def float64) []float64 {
+		if len(values) == 0 {
+			return []float64{def}
+		}
+		return values
+	}
+
+	var combos []StrategyParams
+	for _, ma := range orDefault(g.MAPeriods, defaultStrategyParams.MAPeriod) {
+		for _, ema := range orDefault(g.EMAPeriods, defaultStrategyParams.EMAPeriod) {
+			for _, winners := range orDefault(g.EMAWinners, defaultStrategyParams.EMAWinners) {
+				for _, threshold := range orDefaultFloat(g.MAThresholds, defaultStrategyParams.MAThreshold) {
+					for _, band := range orDefaultFloat(g.DriftBands, defaultStrategyParams.DriftBand) {
+						p := defaultStrategyParams
+						p.MAPeriod, p.EMAPeriod, p.EMAWinners, p.MAThreshold, p.DriftBand = ma, ema, winners, threshold, band
+						combos = append(combos, p)
+					}
+				}
+			}
+		}
+	}
+	return combos
+}
+
+// validate checks that every value in the grid is usable.
+func (g ParamGrid) validate() error {
+	for _, v := range append(append([]int{}, g.MAPeriods...), g.EMAPeriods...) {
+		if v < 2 {
+			return fmt.Errorf("%w: indicator periods must be at least 2", errInvalidInput)
+		}
+	}
+	for _, v := range g.EMAWinners {
+		if v < 1 {
+			return fmt.Errorf("%w: emaWinners must be at least 1", errInvalidInput)
+		}
+	}
+	for _, v := range g.MAThresholds {
+		if v < 0 || v >= 1 {
+			return fmt.Errorf("%w: maThresholds must be between 0 and 1", errInvalidInput)
+		}
+	}
+	for _, v := range g.DriftBands {
+		if v < 0 || v >= 1 {
+			return fmt.Errorf("%w: driftBands must be between 0 and 1", errInvalidInput)
+		}
+	}
+	return nil
+}
+
+// WalkForward configures the rolling train/test windows. Each fold starts TestMonths
+// after the previous one, so the test windows follow each other without overlapping.
+type WalkForward struct {
+	Folds       int `json:"folds" openapi:"min=1,max=20"`        // Defaults to 3
+	TrainMonths int `json:"trainMonths" openapi:"min=1,max=240"` // Defaults to 24
+	TestMonths  int `json:"testMonths" openapi:"min=1,max=120"`  // Defaults to 12
+}
+
+// SweepRequest is the body accepted by POST /api/v1/backtests/sweep. The backtest's
+// start and end bound the whole walk-forward; its params field is ignored.
+type SweepRequest struct {
+	Backtest    BacktestRequest `json:"backtest" openapi:"required"`
+	Grid        ParamGrid       `json:"grid"`
+	WalkForward WalkForward     `json:"walkForward"`
+}
+
+// sweepWindow is one fold of a walk-forward.
+type sweepWindow struct {
+	trainStart, trainEnd, testStart, testEnd time.Time
+}
+
+// windows lays out the folds of wf inside [start, end], or fails if they don't fit.
+func (wf WalkForward) windows(start, end time.Time) ([]sweepWindow, error) {
+	var windows []sweepWindow
+	for i := 0; i < wf.Folds; i++ {
+		trainStart := start.AddDate(0, i*wf.TestMonths, 0)
+		testStart := trainStart.AddDate(0, wf.TrainMonths, 0)
+		testEnd := testStart.AddDate(0, wf.TestMonths, 0)
+		if testEnd.After(end.AddDate(0, 0, 1)) {
+			return nil, fmt.Errorf("%w: %d folds of %d+%d months don't fit between %s and %s",
+				errInvalidInput, wf.Folds, wf.TrainMonths, wf.TestMonths, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		}
+		windows = append(windows, sweepWindow{
+			trainStart: trainStart,
+			trainEnd:   testStart.AddDate(0, 0, -1),
+			testStart:  testStart,
+			testEnd:    testEnd.AddDate(0, 0, -1),
+		})
+	}
+	return windows, nil
+}
+
+// SweepPick is the parameter set a fold selected for a strategy on its train window.
+type SweepPick struct {
+	Key         string         `json:"key"`
+	Params      StrategyParams `json:"params"`
+	TrainReturn float64        `json:"trainReturn"`
+	TestReturn  float64        `json:"testReturn"`
+}
+
+// SweepFold describes one walk-forward fold and what it selected.
+type SweepFold struct {
+	Fold       int         `json:"fold"`
+	TrainStart string      `json:"trainStart"`
+	TrainEnd   string      `json:"trainEnd"`
+	TestStart  string      `json:"testStart"`
+	TestEnd    string      `json:"testEnd"`
+	Picks      []SweepPick `json:"picks"`
+}
+
+// SweepRow summarises one strategy/parameter combination across all folds. Returns
+// are the gain on the money contributed within a window (0.1 = 10%).
+type SweepRow struct {
+	Key          string         `json:"key"`
+	Strategy     string         `json:"strategy"`
+	Params       StrategyParams `json:"params"`
+	TrainReturn  float64        `json:"trainReturn"`  // Mean over the train windows
+	TestReturn   float64        `json:"testReturn"`   // Mean over the test windows
+	WorstTest    float64        `json:"worstTest"`    // Lowest return on a test window
+	Gap          float64        `json:"gap"`          // TrainReturn - TestReturn; large means overfit
+	BeatBaseline float64        `json:"beatBaseline"` // Share of test windows that beat the baseline strategy
+	Selected     int            `json:"selected"`     // Number of folds that picked this combination
+	Robust       bool           `json:"robust"`
+}
+
+// SweepResult is the outcome of a parameter sweep.
+type SweepResult struct {
+	Baseline string `json:"baseline"`
+	// WalkForward is, per strategy, the mean test return of the parameters picked on
+	// each train window: the honest estimate of how tuning would have performed.
+	WalkForward map[string]float64 `json:"walkForward"`
+	Folds       []SweepFold        `json:"folds"`
+	Rows        []SweepRow         `json:"rows"`
+}
+
+// windowReturn is the gain of a backtest on the money contributed during it.
+func windowReturn(sb StrategyBacktest) float64 {
+	if sb.Contributed <= 0 {
+		return 0
+	}
+	return sb.FinalValue/sb.Contributed - 1
+}
+
+// sweepScores holds the train and test return of each fold for one combination.
+type sweepScores struct {
+	strategy Strategy
+	params   StrategyParams
+	train    []float64
+	test     []float64
+}
+
+// runSweep backtests every combination of the grid on every fold. cfg supplies the
+// tickers and contribution schedule; prices must cover all windows plus warm-up.
+func runSweep(cfg BacktestConfig, combos []StrategyParams, windows []sweepWindow, prices map[string][]HistoricalPrice) SweepResult {
+	result := SweepResult{Baseline: sweepBaseline, WalkForward: make(map[string]float64)}
+
+	// Strategies ignore most parameters, so only keep distinct tuned combinations
+	type scoreKey struct {
+		strategy string
+		params   StrategyParams
+	}
+	scores := make(map[scoreKey]*sweepScores)
+	var order []scoreKey
+	run := func(params StrategyParams, start, end time.Time) map[string]float64 {
+		c := cfg
+		c.Start, c.End, c.Params = start, end, params
+		returns := make(map[string]float64)
+		for _, sb := range runBacktest(c, prices).Strategies {
+			returns[sb.Key] = windowReturn(sb)
+		}
+		return returns
+	}
+
+	for _, w := range windows {
+		seen := make(map[scoreKey]bool)
+		for _, params := range combos {
+			train := run(params, w.trainStart, w.trainEnd)
+			test := run(params, w.testStart, w.testEnd)
+			for _, s := range cfg.strategies() {
+				key := scoreKey{s.Key, StrategyParams{}}
+				if s.Tuned != nil {
+					key.params = s.Tuned(params)
+				}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if scores[key] == nil {
+					scores[key] = &sweepScores{strategy: s, params: key.params}
+					order = append(order, key)
+				}
+				scores[key].train = append(scores[key].train, train[s.Key])
+				scores[key].test = append(scores[key].test, test[s.Key])
+			}
+		}
+	}
+
+	rows := make(map[scoreKey]*SweepRow)
+	for _, key := range order {
+		sc := scores[key]
+		row := &SweepRow{
+			Key:         key.strategy,
+			Strategy:    sc.strategy.Name,
+			Params:      sc.params,
+			TrainReturn: mean(sc.train),
+			TestReturn:  mean(sc.test),
+			WorstTest:   slicesMin(sc.test),
+		}
+		row.Gap = row.TrainReturn - row.TestReturn
+		if baseline := scores[scoreKey{sweepBaseline, StrategyParams{}}]; baseline != nil && key.strategy != sweepBaseline {
+			var beats int
+			for i, r := range sc.test {
+				if r > baseline.test[i] {
+					beats++
+				}
+			}
+			row.BeatBaseline = float64(beats) / float64(len(sc.test))
+		}
+		rows[key] = row
+	}
+
+	// Walk forward: pick the best combination on each train window, judge it on the test window
+	for i, w := range windows {
+		fold := SweepFold{
+			Fold:       i + 1,
+			TrainStart: w.trainStart.Format("2006-01-02"),
+			TrainEnd:   w.trainEnd.Format("2006-01-02"),
+			TestStart:  w.testStart.Format("2006-01-02"),
+			TestEnd:    w.testEnd.Format("2006-01-02"),
+		}
+		for _, s := range cfg.strategies() {
+			var best *sweepScores
+			for _, key := range order {
+				if sc := scores[key]; key.strategy == s.Key && (best == nil || sc.train[i] > best.train[i]) {
+					best = sc
+				}
+			}
+			if best == nil {
+				continue
+			}
+			rows[scoreKey{s.Key, best.params}].Selected++
+			fold.Picks = append(fold.Picks, SweepPick{Key: s.Key, Params: best.params, TrainReturn: best.train[i], TestReturn: best.test[i]})
+			result.WalkForward[s.Key] += best.test[i] / float64(len(windows))
+		}
+		result.Folds = append(result.Folds, fold)
+	}
+
+	for _, key := range order {
+		row := rows[key]
+		// Robust means it kept at least half of its in-sample edge out of sample and,
+		// unless it is the baseline itself, beat the baseline in most test windows
+		kept := row.TestReturn >= row.TrainReturn-math.Abs(row.TrainReturn)/2
+		row.Robust = kept && (row.Key == sweepBaseline || row.BeatBaseline > 0.5)
+		result.Rows = append(result.Rows, *row)
+	}
+	sort.SliceStable(result.Rows, func(i, j int) bool {
+		if result.Rows[i].Robust != result.Rows[j].Robust {
+			return result.Rows[i].Robust
+		}
+		return result.Rows[i].TestReturn > result.Rows[j].TestReturn
+	})
+	return result
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func slicesMin(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	m := values[0]
+	for _, v := range values[1:] {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+// sweep validates req, fetches the prices it needs once and runs the sweep.
+func sweep(ctx context.Context, req SweepRequest) (SweepResult, error) {
+	cfg, err := req.Backtest.backtestConfig(ctx)
+	if err != nil {
+		return SweepResult{}, err
+	}
+	if err := req.Grid.validate(); err != nil {
+		return SweepResult{}, err
+	}
+
+	wf := req.WalkForward
+	if wf.Folds <= 0 {
+		wf.Folds = 3
+	}
+	if wf.TrainMonths <= 0 {
+		wf.TrainMonths = 24
+	}
+	if wf.TestMonths <= 0 {
+		wf.TestMonths = 12
+	}
+	windows, err := wf.windows(cfg.Start, cfg.End)
+	if err != nil {
+		return SweepResult{}, err
+	}
+
+	combos := req.Grid.combinations()
+	if runs := len(combos) * len(windows) * 2; runs > maxSweepRuns {
+		return SweepResult{}, fmt.Errorf("%w: the sweep needs %d backtests, the limit is %d", errInvalidInput, runs, maxSweepRuns)
+	}
+
+	// Fetch enough warm-up for the longest periods in the grid
+	fetchCfg := cfg
+	for _, p := range combos {
+		fetchCfg.Params.MAPeriod = max(fetchCfg.Params.MAPeriod, p.MAPeriod)
+		fetchCfg.Params.EMAPeriod = max(fetchCfg.Params.EMAPeriod, p.EMAPeriod)
+		fetchCfg.Params.RiskWindow = max(fetchCfg.Params.RiskWindow, p.RiskWindow)
+		fetchCfg.Params.MomentumSkip = max(fetchCfg.Params.MomentumSkip, p.MomentumSkip)
+	}
+	prices, err := fetchBacktestPrices(fetchCfg)
+	if err != nil {
+		return SweepResult{}, fmt.Errorf("%w: %v", errUpstream, err)
+	}
+	return runSweep(cfg, combos, windows, prices), nil
+}
+
+func apiRunSweep(c *gin.Context) {
+	var req SweepRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+	result, err := sweep(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// sweepForm holds the raw values of the sweep page form, so they survive a submit.
+type sweepForm struct {
+	Tickers, Start, End, Contribution, Frequency    string
+	MAPeriods, EMAPeriods, EMAWinners, MAThresholds string
+	DriftBands                                      string
+	Folds, TrainMonths, TestMonths                  string
+}
+
+// defaultSweepForm prefills the sweep page with a small grid around the live parameters.
+func defaultSweepForm() sweepForm {
+	return sweepForm{
+		Start:        time.Now().AddDate(-5, 0, 0).Format("2006-01-02"),
+		Contribution: "100",
+		Frequency:    "monthly",
+		MAPeriods:    "100, 150, 200, 250",
+		EMAPeriods:   "56, 112, 168",
+		EMAWinners:   "1, 2, 3",
+		MAThresholds: "0, 0.05, 0.1",
+		DriftBands:   "0, 0.05, 0.1",
+		Folds:        "3",
+		TrainMonths:  "24",
+		TestMonths:   "12",
+	}
+}
+
+// request converts the form into a SweepRequest.
+func (f sweepForm) request() (SweepRequest, error) {
+	var req SweepRequest
+	var err error
+	req.Backtest = BacktestRequest{Start: f.Start, End: f.End, Frequency: f.Frequency}
+	for _, t := range strings.Split(f.Tickers, ",") {
+		req.Backtest.Tickers = append(req.Backtest.Tickers, t)
+	}
+	if req.Backtest.Contribution, err = strconv.ParseFloat(strings.TrimSpace(f.Contribution), 64); err != nil {
+		return req, fmt.Errorf("%w: contribution must be a number", errInvalidInput)
+	}
+	ints := []struct {
+		name  string
+		value string
+		dest  *[]int
+	}{
+		{"MA periods", f.MAPeriods, &req.Grid.MAPeriods},
+		{"EMA periods", f.EMAPeriods, &req.Grid.EMAPeriods},
+		{"EMA winners", f.EMAWinners, &req.Grid.EMAWinners},
+	}
+	for _, field := range ints {
+		for _, s := range strings.Split(field.value, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return req, fmt.Errorf("%w: %s must be whole numbers", errInvalidInput, field.name)
+			}
+			*field.dest = append(*field.dest, v)
+		}
+	}
+	floats := []struct {
+		name  string
+		value string
+		dest  *[]float64
+	}{
+		{"MA thresholds", f.MAThresholds, &req.Grid.MAThresholds},
+		{"Drift bands", f.DriftBands, &req.Grid.DriftBands},
+	}
+	for _, field := range floats {
+		for _, s := range strings.Split(field.value, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return req, fmt.Errorf("%w: %s must be numbers", errInvalidInput, field.name)
+			}
+			*field.dest = append(*field.dest, v)
+		}
+	}
+	req.WalkForward.Folds, _ = strconv.Atoi(f.Folds)
+	req.WalkForward.TrainMonths, _ = strconv.Atoi(f.TrainMonths)
+	req.WalkForward.TestMonths, _ = strconv.Atoi(f.TestMonths)
+	return req, nil
+}
+
+func showSweepPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "sweep.tmpl.html", gin.H{"Form": defaultSweepForm()})
+}
+
+// handleSweep runs a sweep submitted from the sweep page and renders the results table.
+func handleSweep(c *gin.Context) {
+	form := sweepForm{
+		Tickers:      c.PostForm("tickers"),
+		Start:        c.PostForm("start"),
+		End:          c.PostForm("end"),
+		Contribution: c.PostForm("contribution"),
+		Frequency:    c.PostForm("frequency"),
+		MAPeriods:    c.PostForm("maPeriods"),
+		EMAPeriods:   c.PostForm("emaPeriods"),
+		EMAWinners:   c.PostForm("emaWinners"),
+		MAThresholds: c.PostForm("maThresholds"),
+		DriftBands:   c.PostForm("driftBands"),
+		Folds:        c.PostForm("folds"),
+		TrainMonths:  c.PostForm("trainMonths"),
+		TestMonths:   c.PostForm("testMonths"),
+	}
+
+	req, err := form.request()
+	var result SweepResult
+	if err == nil {
+		result, err = sweep(c.Request.Context(), req)
+	}
+	if err != nil {
+		log.Printf("Parameter sweep failed: %v", err)
+		c.HTML(formErrorStatus(err), "sweep.tmpl.html", gin.H{"Form": form, "Error": err.Error()})
+		return
+	}
+	c.HTML(http.StatusOK, "sweep.tmpl.html", gin.H{"Form": form, "Result": result})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParamGridCombinations(t *testing.T) {
+	defaults := ParamGrid{}.combinations()
+	if !reflect.DeepEqual(defaults, []StrategyParams{defaultStrategyParams}) {
+		t.Errorf("empty grid = %+v, want only the live parameters", defaults)
+	}
+
+	grid := ParamGrid{MAPeriods: []int{100, 200}, EMAWinners: []int{1, 2, 3}, MAThresholds: []float64{0, 0.05}}
+	combos := grid.combinations()
+	if len(combos) != 12 {
+		t.Fatalf("got %d combinations, want 2*1*3*2 = 12", len(combos))
+	}
+	want := defaultStrategyParams
+	want.MAPeriod, want.EMAWinners, want.MAThreshold = 100, 1, 0.05
+	if combos[1] != want {
+		t.Errorf("second combination = %+v, want %+v", combos[1], want)
+	}
+	seen := make(map[StrategyParams]bool)
+	for _, c := range combos {
+		if seen[c] {
+			t.Errorf("combination %+v repeated", c)
+		}
+		seen[c] = true
+	}
+}
+
+func TestParamGridValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		grid  ParamGrid
+		valid bool
+	}{
+		{"empty", ParamGrid{}, true},
+		{"usable values", ParamGrid{MAPeriods: []int{2, 200}, EMAPeriods: []int{50}, EMAWinners: []int{1}, MAThresholds: []float64{0, 0.99}}, true},
+		{"MA period too short", ParamGrid{MAPeriods: []int{1}}, false},
+		{"EMA period too short", ParamGrid{EMAPeriods: []int{0}}, false},
+		{"no EMA winners", ParamGrid{EMAWinners: []int{0}}, false},
+		{"negative threshold", ParamGrid{MAThresholds: []float64{-0.1}}, false},
+		{"threshold of 100%", ParamGrid{MAThresholds: []float64{1}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.grid.validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, errInvalidInput)) {
+			t.Errorf("%s: validate() = %v, want valid = %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestWalkForwardWindows(t *testing.T) {
+	tests := []struct {
+		name  string
+		wf    WalkForward
+		end   string
+		folds []sweepWindow
+	}{
+		{"one fold", WalkForward{Folds: 1, TrainMonths: 12, TestMonths: 6}, "2021-06-30", []sweepWindow{
+			{day("2020-01-01"), day("2020-12-31"), day("2021-01-01"), day("2021-06-30")},
+		}},
+		{"rolling folds", WalkForward{Folds: 3, TrainMonths: 2, TestMonths: 1}, "2020-12-31", []sweepWindow{
+			{day("2020-01-01"), day("2020-02-29"), day("2020-03-01"), day("2020-03-31")},
+			{day("2020-02-01"), day("2020-03-31"), day("2020-04-01"), day("2020-04-30")},
+			{day("2020-03-01"), day("2020-04-30"), day("2020-05-01"), day("2020-05-31")},
+		}},
+		{"last fold ends on the end date", WalkForward{Folds: 2, TrainMonths: 2, TestMonths: 1}, "2020-04-30", []sweepWindow{
+			{day("2020-01-01"), day("2020-02-29"), day("2020-03-01"), day("2020-03-31")},
+			{day("2020-02-01"), day("2020-03-31"), day("2020-04-01"), day("2020-04-30")},
+		}},
+		{"does not fit", WalkForward{Folds: 2, TrainMonths: 2, TestMonths: 1}, "2020-04-29", nil},
+	}
+	for _, tt := range tests {
+		windows, err := tt.wf.windows(day("2020-01-01"), day(tt.end))
+		if tt.folds == nil {
+			if !errors.Is(err, errInvalidInput) {
+				t.Errorf("%s: windows() error = %v, want invalid input", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(windows, tt.folds) {
+			t.Errorf("%s: windows() = %v, %v; want %v", tt.name, windows, err, tt.folds)
+		}
+	}
+}
+
+func TestSweepStatistics(t *testing.T) {
+	tests := []struct {
+		values    []float64
+		mean, min float64
+	}{
+		{nil, 0, 0},
+		{[]float64{0.1}, 0.1, 0.1},
+		{[]float64{0.2, -0.1, 0.5}, 0.2, -0.1},
+	}
+	for _, tt := range tests {
+		if got := mean(tt.values); math.Abs(got-tt.mean) > 1e-12 {
+			t.Errorf("mean(%v) = %v, want %v", tt.values, got, tt.mean)
+		}
+		if got := slicesMin(tt.values); got != tt.min {
+			t.Errorf("slicesMin(%v) = %v, want %v", tt.values, got, tt.min)
+		}
+	}
+
+	if got := windowReturn(StrategyBacktest{FinalValue: 1100, Contributed: 1000}); math.Abs(got-0.1) > 1e-12 {
+		t.Errorf("windowReturn = %v, want 0.1", got)
+	}
+	if got := windowReturn(StrategyBacktest{FinalValue: 50}); got != 0 {
+		t.Errorf("windowReturn without contributions = %v, want 0", got)
+	}
+}
+
+func TestSweepFormRequest(t *testing.T) {
+	form := defaultSweepForm()
+	form.Tickers = "vwce, AAPL"
+	req, err := form.request()
+	if err != nil {
+		t.Fatalf("request() of the default form: %v", err)
+	}
+	if !reflect.DeepEqual(req.Grid.MAPeriods, []int{100, 150, 200, 250}) || !reflect.DeepEqual(req.Grid.MAThresholds, []float64{0, 0.05, 0.1}) {
+		t.Errorf("default form grid = %+v", req.Grid)
+	}
+	if req.Backtest.Contribution != 100 || req.WalkForward != (WalkForward{Folds: 3, TrainMonths: 24, TestMonths: 12}) {
+		t.Errorf("request() = %+v", req)
+	}
+
+	for name, edit := range map[string]func(*sweepForm){
+		"contribution": func(f *sweepForm) { f.Contribution = "a lot" },
+		"MA periods":   func(f *sweepForm) { f.MAPeriods = "100, 1.5" },
+		"thresholds":   func(f *sweepForm) { f.MAThresholds = "0, five" },
+	} {
+		f := defaultSweepForm()
+		edit(&f)
+		if _, err := f.request(); !errors.Is(err, errInvalidInput) {
+			t.Errorf("invalid %s: request() error = %v, want invalid input", name, err)
+		}
+	}
+}
+
+func TestRunSweepFlatPrices(t *testing.T) {
+	prices := map[string][]HistoricalPrice{
+		"A": dailyPrices("2020-01-01", 200, 10),
+		"B": dailyPrices("2020-01-01", 200, 20),
+		"C": dailyPrices("2020-01-01", 200, 40),
+	}
+	cfg := BacktestConfig{Tickers: []string{"A", "B", "C"}, Contribution: 100, Frequency: "weekly", Initial: 300}
+	combos := ParamGrid{MAPeriods: []int{20, 50}, EMAPeriods: []int{10}, EMAWinners: []int{1, 2}}.combinations()
+	windows, err := WalkForward{Folds: 2, TrainMonths: 2, TestMonths: 1}.windows(day("2020-01-01"), day("2020-06-30"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runSweep(cfg, combos, windows, prices)
+
+	// Every strategy gets one row per distinct set of the parameters it uses
+	wantRows := 0
+	for _, s := range strategies {
+		distinct := make(map[StrategyParams]bool)
+		for _, c := range combos {
+			if s.Tuned != nil {
+				c = s.Tuned(c)
+			} else {
+				c = StrategyParams{}
+			}
+			distinct[c] = true
+		}
+		wantRows += len(distinct)
+	}
+	if len(result.Rows) != wantRows {
+		t.Errorf("got %d rows, want %d", len(result.Rows), wantRows)
+	}
+	if len(result.Folds) != 2 {
+		t.Fatalf("got %d folds, want 2", len(result.Folds))
+	}
+	for _, fold := range result.Folds {
+		if len(fold.Picks) != len(strategies) {
+			t.Errorf("fold %d picked for %d strategies, want %d", fold.Fold, len(fold.Picks), len(strategies))
+		}
+	}
+
+	selected := make(map[string]int)
+	for i, row := range result.Rows {
+		selected[row.Key] += row.Selected
+		// Flat prices return nothing, in or out of sample
+		if math.Abs(row.TrainReturn) > 1e-9 || math.Abs(row.TestReturn) > 1e-9 || math.Abs(row.Gap) > 1e-9 {
+			t.Errorf("%s %+v: train %v, test %v, gap %v; want 0", row.Key, row.Params, row.TrainReturn, row.TestReturn, row.Gap)
+		}
+		if i > 0 && row.Robust && !result.Rows[i-1].Robust {
+			t.Errorf("robust row %s %+v sorted after a row that is not", row.Key, row.Params)
+		}
+	}
+	for _, s := range strategies {
+		if selected[s.Key] != len(windows) {
+			t.Errorf("%s was selected %d times, want once per fold", s.Key, selected[s.Key])
+		}
+	}
+}
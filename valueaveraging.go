@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ValueAveraging replaces the fixed budget with contributions that keep the portfolio
+// on a target value path. The path starts at StartValue on Start, grows by
+// MonthlyGrowth every month (compounding) and has MonthlyIncrease added every month.
+// Each cycle contributes the gap between the path and the value of the holdings,
+// limited to [MinContribution, MaxContribution].
+type ValueAveraging struct {
+	Enabled         bool    `firestore:"enabled" json:"enabled"`
+	Start           string  `firestore:"start" json:"start"` // YYYY-MM-DD, required when enabled
+	StartValue      float64 `firestore:"startValue" json:"startValue" openapi:"min=0"`
+	MonthlyIncrease float64 `firestore:"monthlyIncrease" json:"monthlyIncrease" openapi:"min=0"`   // €X added to the path every month
+	MonthlyGrowth   float64 `firestore:"monthlyGrowth" json:"monthlyGrowth" openapi:"min=0,max=1"` // 0.005 = the path compounds by 0.5% a month
+	MinContribution float64 `firestore:"minContribution" json:"minContribution" openapi:"min=0"`   // Contributed even when the portfolio is ahead of the path
+	MaxContribution float64 `firestore:"maxContribution" json:"maxContribution" openapi:"min=0"`   // 0 means no limit
+}
+
+// daysPerMonth is the average length of a month, used to measure the path in months.
+const daysPerMonth = 365.25 / 12
+
+// validate checks that the plan can be followed.
+func (va ValueAveraging) validate() error {
+	for _, v := range []float64{va.StartValue, va.MonthlyIncrease, va.MonthlyGrowth, va.MinContribution, va.MaxContribution} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%w: value averaging amounts must be non-negative numbers", errInvalidInput)
+		}
+	}
+	if va.MonthlyGrowth >= 1 {
+		return fmt.Errorf("%w: the monthly growth must be below 1 (100%%)", errInvalidInput)
+	}
+	if va.MaxContribution > 0 && va.MaxContribution < va.MinContribution {
+		return fmt.Errorf("%w: the maximum contribution must not be below the minimum", errInvalidInput)
+	}
+	if va.Enabled {
+		if _, err := time.Parse("2006-01-02", va.Start); err != nil {
+			return fmt.Errorf("%w: the start must be a date in YYYY-MM-DD format", errInvalidInput)
+		}
+	}
+	return nil
+}
+
+// target returns the value the path asks for at t, and false before the path starts.
+func (va ValueAveraging) target(t time.Time) (float64, bool) {
+	start, err := time.Parse("2006-01-02", va.Start)
+	if err != nil || t.Before(start) {
+		return 0, false
+	}
+	months := t.Sub(start).Hours() / 24 / daysPerMonth
+	if va.MonthlyGrowth == 0 {
+		return va.StartValue + va.MonthlyIncrease*months, true
+	}
+	growth := math.Pow(1+va.MonthlyGrowth, months)
+	return va.StartValue*growth + va.MonthlyIncrease*(growth-1)/va.MonthlyGrowth, true
+}
+
+// contribution returns what to invest at t for a portfolio worth value: the gap to the
+// path, limited to the configured minimum and maximum.
+func (va ValueAveraging) contribution(value float64, t time.Time) float64 {
+	target, ok := va.target(t)
+	if !ok {
+		return va.MinContribution
+	}
+	amount := math.Max(target-value, va.MinContribution)
+	if va.MaxContribution > 0 {
+		amount = math.Min(amount, va.MaxContribution)
+	}
+	return amount
+}
+
+// holdingsValue returns the value of stocks at their last analysed price, falling back
+// to the purchase price for holdings that have not been analysed.
+func holdingsValue(stocks []Stock) float64 {
+	var value float64
+	for _, s := range stocks {
+		value += marketValue(s)
+	}
+	return value
+}
+
+// marketValue is the value of a holding at its last analysed price, or at its purchase
+// price if it has not been analysed.
+func marketValue(s Stock) float64 {
+	price := s.CurrentPrice
+	if price <= 0 {
+		price = s.Price
+	}
+	return s.Quantity * price
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// monthsAfter returns the time exactly months average months after start.
+func monthsAfter(start string, months float64) time.Time {
+	return day(start).Add(time.Duration(months * daysPerMonth * 24 * float64(time.Hour)))
+}
+
+func TestValueAveragingTarget(t *testing.T) {
+	linear := ValueAveraging{Start: "2024-01-01", StartValue: 1000, MonthlyIncrease: 100}
+	compounding := ValueAveraging{Start: "2024-01-01", StartValue: 1000, MonthlyIncrease: 100, MonthlyGrowth: 0.01}
+
+	// Compounding month by month must land on the closed form
+	stepped := 1000.0
+	for m := 0; m < 12; m++ {
+		stepped = stepped*1.01 + 100
+	}
+
+	tests := []struct {
+		name string
+		va   ValueAveraging
+		at   time.Time
+		ok   bool
+		want float64
+	}{
+		{"before the start", linear, day("2023-12-31"), false, 0},
+		{"on the start", linear, day("2024-01-01"), true, 1000},
+		{"after a year", linear, monthsAfter("2024-01-01", 12), true, 2200},
+		{"half way through a month", linear, monthsAfter("2024-01-01", 1.5), true, 1150},
+		{"compounding for a year", compounding, monthsAfter("2024-01-01", 12), true, stepped},
+		{"compounding without increase", ValueAveraging{Start: "2024-01-01", StartValue: 1000, MonthlyGrowth: 0.02}, monthsAfter("2024-01-01", 6), true, 1000 * math.Pow(1.02, 6)},
+		{"invalid start", ValueAveraging{Start: "soon", StartValue: 1000}, day("2024-06-01"), false, 0},
+	}
+	for _, tt := range tests {
+		got, ok := tt.va.target(tt.at)
+		if ok != tt.ok || math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: target = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValueAveragingContribution(t *testing.T) {
+	va := ValueAveraging{Start: "2024-01-01", StartValue: 1000, MonthlyIncrease: 100, MinContribution: 50, MaxContribution: 400}
+	atYear := monthsAfter("2024-01-01", 12) // The path is at 2200
+
+	tests := []struct {
+		name  string
+		va    ValueAveraging
+		value float64
+		at    time.Time
+		want  float64
+	}{
+		{"gap within the limits", va, 2000, atYear, 200},
+		{"gap below the minimum", va, 2180, atYear, 50},
+		{"ahead of the path", va, 3000, atYear, 50},
+		{"gap above the maximum", va, 1000, atYear, 400},
+		{"gap equal to the maximum", va, 1800, atYear, 400},
+		{"no maximum", ValueAveraging{Start: "2024-01-01", StartValue: 1000, MonthlyIncrease: 100}, 1000, atYear, 1200},
+		{"no minimum ahead of the path", ValueAveraging{Start: "2024-01-01", StartValue: 1000}, 1500, atYear, 0},
+		{"before the start", va, 0, day("2023-06-01"), 50},
+	}
+	for _, tt := range tests {
+		if got := tt.va.contribution(tt.value, tt.at); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: contribution = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValueAveragingValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		va    ValueAveraging
+		valid bool
+	}{
+		{"disabled without a start", ValueAveraging{}, true},
+		{"enabled", ValueAveraging{Enabled: true, Start: "2024-01-01", StartValue: 1000, MinContribution: 10, MaxContribution: 10}, true},
+		{"enabled without a start", ValueAveraging{Enabled: true}, false},
+		{"negative increase", ValueAveraging{MonthlyIncrease: -1}, false},
+		{"growth of 100%", ValueAveraging{MonthlyGrowth: 1}, false},
+		{"maximum below the minimum", ValueAveraging{MinContribution: 100, MaxContribution: 50}, false},
+		{"not a number", ValueAveraging{StartValue: math.NaN()}, false},
+	}
+	for _, tt := range tests {
+		err := tt.va.validate()
+		if (err == nil) != tt.valid || (err != nil && !errors.Is(err, errInvalidInput)) {
+			t.Errorf("%s: validate() = %v, want valid = %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestHoldingsValue(t *testing.T) {
+	stocks := []Stock{
+		{Quantity: 2, Price: 10, CurrentPrice: 15},
+		{Quantity: 3, Price: 20}, // Not analysed yet
+	}
+	if got := holdingsValue(stocks); got != 90 {
+		t.Errorf("holdingsValue = %v, want 90", got)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The watchlist holds stocks that are not in the portfolio but that strategies with
+// Candidates set may buy. The list itself is part of the settings, so it is audited
+// and event-sourced like the budget. The analysed prices of the candidates are market
+// data rather than state: they are cached in the watchlist collection by the analysis.
+
+// WatchlistItem is a stock followed without being held.
+type WatchlistItem struct {
+	Ticker string `firestore:"ticker" json:"ticker" openapi:"required,minLength=1"`
+	Name   string `firestore:"name" json:"name"`
+}
+
+func watchlistDoc(ticker string) *firestore.DocumentRef {
+	return firestoreClient.Collection("watchlist").Doc(ticker)
+}
+
+// addToWatchlist adds a ticker to the watchlist, or renames it if it is already there.
+func addToWatchlist(ctx context.Context, item WatchlistItem) (settings Settings, err error) {
+	item.Ticker = strings.TrimSpace(item.Ticker)
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Watchlist = nil
+	for _, existing := range before.Watchlist {
+		if existing.Ticker != item.Ticker {
+			after.Watchlist = append(after.Watchlist, existing)
+		}
+	}
+	after.Watchlist = append(after.Watchlist, item)
+	defer func() { recordAudit(ctx, auditWatchlist, item.Ticker, before, after, err) }()
+
+	if item.Ticker == "" {
+		return Settings{}, fmt.Errorf("%w: ticker is required", errInvalidInput)
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "watchlist", Value: after.Watchlist}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update watchlist: %w", err)
+	}
+	return after, appendEvent(ctx, eventWatchlist, after)
+}
+
+// removeFromWatchlist removes a ticker from the watchlist and drops its cached analysis.
+func removeFromWatchlist(ctx context.Context, ticker string) (settings Settings, err error) {
+	before, err := getSettings(ctx)
+	if err != nil {
+		return Settings{}, err
+	}
+	after := before
+	after.Watchlist = nil
+	for _, existing := range before.Watchlist {
+		if existing.Ticker != ticker {
+			after.Watchlist = append(after.Watchlist, existing)
+		}
+	}
+	defer func() { recordAudit(ctx, auditWatchlist, ticker, before, after, err) }()
+
+	if len(after.Watchlist) == len(before.Watchlist) {
+		return Settings{}, fmt.Errorf("watchlist entry %s: %w", ticker, errNotFound)
+	}
+	if _, err := settingsDoc().Update(ctx, []firestore.Update{{Path: "watchlist", Value: after.Watchlist}}); err != nil {
+		return Settings{}, fmt.Errorf("failed to update watchlist: %w", err)
+	}
+	if _, err := watchlistDoc(ticker).Delete(ctx); err != nil {
+		log.Printf("Failed to delete cached analysis of %s: %v", ticker, err)
+	}
+	return after, appendEvent(ctx, eventWatchlist, after)
+}
+
+// notHeld returns the watchlist items that are not in the portfolio.
+func (s Settings) notHeld(held []Stock) []WatchlistItem {
+	tickers := make(map[string]bool, len(held))
+	for _, stock := range held {
+		tickers[stock.Ticker] = true
+	}
+	var items []WatchlistItem
+	for _, item := range s.Watchlist {
+		if !tickers[item.Ticker] {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// watchlistCandidates returns the analysed watchlist stocks that are not held.
+// Candidates that have not been analysed yet are left out.
+func watchlistCandidates(ctx context.Context, settings Settings, held []Stock) ([]Stock, error) {
+	var candidates []Stock
+	for _, item := range settings.notHeld(held) {
+		doc, err := watchlistDoc(item.Ticker).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get watchlist entry %s: %w", item.Ticker, err)
+		}
+		var stock Stock
+		if err := doc.DataTo(&stock); err != nil {
+			return nil, fmt.Errorf("failed to decode watchlist entry %s: %w", item.Ticker, err)
+		}
+		stock.Name = item.Name
+		candidates = append(candidates, stock)
+	}
+	return candidates, nil
+}
+
+func handleAddToWatchlist(c *gin.Context) {
+	item := WatchlistItem{Ticker: c.PostForm("ticker"), Name: c.PostForm("name")}
+	if _, err := addToWatchlist(c.Request.Context(), item); err != nil {
+		log.Printf("Failed to add %s to the watchlist: %v", item.Ticker, err)
+		c.String(formErrorStatus(err), "Failed to add to the watchlist")
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func handleRemoveFromWatchlist(c *gin.Context) {
+	ticker := c.PostForm("ticker")
+	if _, err := removeFromWatchlist(c.Request.Context(), ticker); err != nil {
+		log.Printf("Failed to remove %s from the watchlist: %v", ticker, err)
+		c.String(formErrorStatus(err), "Failed to remove from the watchlist")
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/")
+}
+
+func apiAddToWatchlist(c *gin.Context) {
+	var req WatchlistItem
+	if err := c.ShouldBindJSON(&req); err != nil {
+		badRequest(c, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	settings, err := addToWatchlist(c.Request.Context(), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func apiRemoveFromWatchlist(c *gin.Context) {
+	if _, err := removeFromWatchlist(c.Request.Context(), c.Param("ticker")); err != nil {
+		respondError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}